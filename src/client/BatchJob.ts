@@ -0,0 +1,102 @@
+import type { BatchRequest } from '../types/config';
+import type { BatchResult, GeminiResponse } from '../types/response';
+
+// Runs one batch request; `signal` aborts when the job is cancelled
+export type BatchRunner = (request: BatchRequest, signal: AbortSignal) => Promise<GeminiResponse>;
+
+/**
+ * Handle for a batch of requests processed by a bounded worker pool.
+ * Results are streamed as they complete via `results()`; `cancel()` stops
+ * scheduling new requests and aborts the ones in flight; `wait()` resolves
+ * with every result once the pool has drained.
+ */
+export class BatchJob {
+  private collected: BatchResult[] = [];
+  private waiters: Array<() => void> = [];
+  private done = false;
+  private cancelled = false;
+  private inFlight: Set<AbortController> = new Set();
+  private completion: Promise<BatchResult[]>;
+
+  constructor(requests: BatchRequest[], run: BatchRunner, concurrency: number) {
+    this.completion = this.start(requests, run, Math.max(1, concurrency));
+  }
+
+  private async start(
+    requests: BatchRequest[],
+    run: BatchRunner,
+    concurrency: number
+  ): Promise<BatchResult[]> {
+    let next = 0;
+
+    const worker = async (): Promise<void> => {
+      while (!this.cancelled && next < requests.length) {
+        const index = next++;
+        // Each request gets its own controller so cancel() can abort it mid-flight
+        const controller = new AbortController();
+        this.inFlight.add(controller);
+        try {
+          const response = await run(requests[index], controller.signal);
+          this.push({ index, response });
+        } catch (error) {
+          this.push({ index, error: error as Error });
+        } finally {
+          this.inFlight.delete(controller);
+        }
+      }
+    };
+
+    const workerCount = Math.min(concurrency, requests.length);
+    await Promise.all(Array.from({ length: workerCount }, () => worker()));
+
+    this.done = true;
+    this.notify();
+    return this.collected;
+  }
+
+  private push(result: BatchResult): void {
+    this.collected.push(result);
+    this.notify();
+  }
+
+  private notify(): void {
+    const waiters = this.waiters;
+    this.waiters = [];
+    waiters.forEach((resolve) => resolve());
+  }
+
+  /**
+   * Yields results in completion order. Each call returns an independent
+   * iterator that starts from the first result.
+   */
+  async *results(): AsyncGenerator<BatchResult> {
+    let position = 0;
+    while (true) {
+      if (position < this.collected.length) {
+        yield this.collected[position++];
+        continue;
+      }
+      if (this.done) {
+        return;
+      }
+      await new Promise<void>((resolve) => this.waiters.push(resolve));
+    }
+  }
+
+  /**
+   * Stops scheduling requests that have not started yet and aborts those in flight,
+   * which are reported with an 'ABORTED' error.
+   */
+  cancel(): void {
+    this.cancelled = true;
+    this.inFlight.forEach((controller) => controller.abort());
+  }
+
+  isCancelled(): boolean {
+    return this.cancelled;
+  }
+
+  wait(): Promise<BatchResult[]> {
+    return this.completion;
+  }
+}
@@ -3,26 +3,271 @@ import type {
   GenerateOptions,
   ChatMessage,
   GenerateContentRequest,
+  OutputSpillOptions,
+  OutputWriter,
+  ModelName,
+  KeyState,
+  BatchRequest,
+  BatchOptions,
+  Content,
+  GenerateJSONOptions,
+  AttachFileOptions,
+  Part,
+  ModelConfig,
+  DefaultGenerationConfig,
+  GenerationConfig,
+  ModelRouteInput,
+  AuditEntry,
+  GenerateCheapestOptions,
 } from '../types/config';
-import type { GeminiResponse, StreamChunk, FallbackStats } from '../types/response';
+import type {
+  GeminiResponse,
+  StreamChunk,
+  FallbackStats,
+  CachedContent,
+  TaggedStreamChunk,
+  TokenUsage,
+  CompareResult,
+  KeyInspection,
+} from '../types/response';
 import type { AttemptRecord } from '../types/errors';
 import type { GeminiModel } from '../types/models';
-import { DEFAULT_CLIENT_OPTIONS } from '../config/defaults';
+import type { RetryOptions } from '../utils/retry';
+import { stat, readFile } from 'fs/promises';
+import {
+  DEFAULT_CLIENT_OPTIONS,
+  DEFAULT_BATCH_CONCURRENCY,
+  DEFAULT_INLINE_LIMIT_BYTES,
+  DEFAULT_POOL_RETRY_DELAY,
+} from '../config/defaults';
 import { ALL_MODELS } from '../types/models';
 import { Logger } from '../utils/logger';
 import { GeminiClient } from './GeminiClient';
-import { GeminiBackError } from '../types/errors';
-import { retryWithBackoff } from '../utils/retry';
-import { ApiKeyRotator } from '../utils/api-key-rotator';
+import { OfflineClient } from './OfflineClient';
+import { BatchJob } from './BatchJob';
+import {
+  GeminiBackError,
+  AbortedError,
+  BillingError,
+  ClientConfigError,
+  SchemaValidationError,
+  ResponseValidationError,
+  IncompleteResponseError,
+} from '../types/errors';
+import { retryWithBackoff, sleep } from '../utils/retry';
+import { ApiKeyRotator, hashToKeyIndex } from '../utils/api-key-rotator';
+import {
+  RequestDeduplicator,
+  copyPlainData,
+  fingerprintRequest,
+} from '../utils/request-deduplicator';
+import { renderTemplate } from '../utils/template';
+import { getContentsByteLength, getContentsText, estimateTokens } from '../utils/prompt-size';
+import { isIncompleteResponse, getBlockedError } from '../utils/finish-reason';
+import { runWithKeyInfo } from '../utils/key-context';
+import { parseJsonWithRepair } from '../utils/json-repair';
+import { validateAgainstSchema } from '../utils/json-schema';
+import { detectMimeType, readHeader, base64Length } from '../utils/mime';
+import { composeSystemInstruction } from '../utils/system-instruction';
+import { sanitizeText } from '../utils/sanitize';
+import { stripMarkdown } from '../utils/markdown';
+import { fromOpenAIMessages } from '../utils/openai';
+import { Semaphore } from '../utils/semaphore';
+import { estimateCost } from '../utils/pricing';
 import { RateLimitTracker } from '../monitoring/rate-limit-tracker';
 import { HealthMonitor } from '../monitoring/health-monitor';
 import {
   isRateLimitError,
-  isRetryableError,
   isAuthError,
+  isBillingError,
   getErrorStatusCode,
+  defaultRetryPolicy,
 } from '../utils/error-handler';
 
+// One (model, API key) pair to try within a request
+interface AttemptTarget {
+  model: GeminiModel;
+  apiKey: string;
+  keyIndex: number | null;
+}
+
+interface ExecutionContext {
+  kind?: 'multimodal';
+  deadline?: number;
+  keySeed?: string;
+  signal?: AbortSignal; // Cancels just this request (default: the client's abort() signal)
+}
+
+// A response from the fallback chain, with the masked key that answered (for the audit entry)
+interface ChainAnswer {
+  response: GeminiResponse;
+  maskedKey?: string;
+}
+
+// A piece of stream output; `reset` discards the text streamed before it
+interface StreamPiece {
+  text: string;
+  raw?: StreamChunk['raw'];
+  reset?: boolean;
+}
+
+// `resumeKey` switches the attempt to another key and returns it (for resumeOnError)
+type StreamFactory = (
+  model: GeminiModel,
+  apiKey: string,
+  resumeKey: () => string
+) => AsyncGenerator<StreamPiece>;
+
+// Text already emitted by a resumable stream, and the model that produced it
+interface ResumeState {
+  emitted: string;
+  model?: GeminiModel;
+  reset: boolean; // The next chunk must tell the caller to discard the emitted text
+}
+
+const MAX_TRUNCATION_PASSES = 5;
+
+const DEFAULT_INSPECT_CONCURRENCY = 4;
+
+const DEFAULT_CHEAPEST_WINDOW_MS = 500;
+
+// Writes spilled output, waiting for 'drain' when a stream reports backpressure
+function writeOutput(writer: OutputWriter, text: string): Promise<void> {
+  if (writer.write(text) !== false || !writer.once) {
+    return Promise.resolve();
+  }
+  const once = writer.once.bind(writer);
+  return new Promise((resolve, reject) => {
+    const onDrain = () => {
+      writer.off?.('error', onError);
+      resolve();
+    };
+    const onError = (error?: Error) => {
+      writer.off?.('drain', onDrain);
+      reject(error ?? new Error('Output writer failed'));
+    };
+    once('drain', onDrain);
+    once('error', onError);
+  });
+}
+
+// SDK generationConfig field set by each per-model generation setting
+const GENERATION_CONFIG_FIELDS: Record<keyof DefaultGenerationConfig, keyof GenerationConfig> = {
+  temperature: 'temperature',
+  maxTokens: 'maxOutputTokens',
+  topP: 'topP',
+  topK: 'topK',
+};
+
+// Options baked into the SDK client pool; a clone overriding any of them gets its own pool
+const CLIENT_OPTION_KEYS: Array<keyof GemBackOptions> = [
+  'timeout',
+  'apiVersion',
+  'textPartSelector',
+  'forwardLabels',
+  'debug',
+  'logLevel',
+  'offline',
+  'cannedResponses',
+];
+
+type ModelSelection = Pick<GenerateOptions, 'model' | 'fallbackOrder'>;
+
+// Raised when a stream produces no chunk within `streamIdleTimeout`; retried like a timeout
+class StreamIdleTimeoutError extends Error {
+  constructor(idleTimeout: number) {
+    super(`Stream idle timeout: no chunk received within ${idleTimeout}ms`);
+    this.name = 'StreamIdleTimeoutError';
+  }
+}
+
+// Strips the API's 'models/' resource prefix, so 'models/gemini-2.5-flash' and
+// 'gemini-2.5-flash' share stats, aliases, modelConfigs, modelWeights and modelPricing
+function normalizeModelName(model: string): string {
+  return model.startsWith('models/') ? model.slice('models/'.length) : model;
+}
+
+// Re-keys a per-model option by normalized model name
+function normalizeModelKeys<T>(byModel: Record<string, T>): Record<string, T> {
+  return Object.fromEntries(
+    Object.entries(byModel).map(([name, value]) => [normalizeModelName(name), value])
+  );
+}
+
+// Settles like `promise`, but rejects as soon as `signal` aborts
+function abortable<T>(promise: Promise<T>, signal: AbortSignal): Promise<T> {
+  return new Promise((resolve, reject) => {
+    const onAbort = () => reject(new Error('Request aborted'));
+    if (signal.aborted) {
+      onAbort();
+    }
+    signal.addEventListener('abort', onAbort, { once: true });
+    promise
+      .then(resolve, reject)
+      .finally(() => signal.removeEventListener('abort', onAbort));
+  });
+}
+
+// A signal that aborts once any of `signals` does; `unlink` detaches it when it is done
+function linkSignals(...signals: AbortSignal[]): { signal: AbortSignal; unlink: () => void } {
+  const controller = new AbortController();
+  const onAbort = () => controller.abort();
+  if (signals.some((signal) => signal.aborted)) {
+    onAbort();
+  }
+  signals.forEach((signal) => signal.addEventListener('abort', onAbort, { once: true }));
+  const unlink = () =>
+    signals.forEach((signal) => signal.removeEventListener('abort', onAbort));
+  return { signal: controller.signal, unlink };
+}
+
+// Runs `run` with a signal that aborts once either `signal` or `clientSignal` does
+async function withLinkedSignal<T>(
+  signal: AbortSignal,
+  clientSignal: AbortSignal,
+  run: (signal: AbortSignal) => Promise<T>
+): Promise<T> {
+  const linked = linkSignals(signal, clientSignal);
+  try {
+    return await run(linked.signal);
+  } finally {
+    linked.unlink();
+  }
+}
+
+// Rejects with StreamIdleTimeoutError unless `promise` settles within `idleTimeout` ms (0: never)
+function withIdleTimeout<T>(promise: Promise<T>, idleTimeout: number): Promise<T> {
+  if (!idleTimeout) {
+    return promise;
+  }
+  let timer: ReturnType<typeof setTimeout> | undefined;
+  const idle = new Promise<never>((_, reject) => {
+    timer = setTimeout(() => reject(new StreamIdleTimeoutError(idleTimeout)), idleTimeout);
+  });
+  return Promise.race([promise, idle]).finally(() => clearTimeout(timer));
+}
+
+// Yields from `source` until `signal` aborts or no chunk arrives for `idleTimeout` ms,
+// without waiting for a pending chunk; the source is then closed so its underlying
+// request is released
+async function* abortableStream<T>(
+  source: AsyncGenerator<T>,
+  signal: AbortSignal,
+  idleTimeout = 0
+): AsyncGenerator<T> {
+  try {
+    while (true) {
+      const next = await abortable(withIdleTimeout(source.next(), idleTimeout), signal);
+      if (next.done) {
+        return;
+      }
+      yield next.value;
+    }
+  } finally {
+    source.return(undefined).catch(() => {});
+  }
+}
+
 export class GemBack {
   private options: Required<Omit<GemBackOptions, 'apiKey' | 'apiKeys'>> & {
     apiKey?: string;
@@ -31,26 +276,63 @@ export class GemBack {
   private logger: Logger;
   private client: GeminiClient;
   private stats: FallbackStats;
+  private totalUsage: Required<Omit<TokenUsage, 'estimated'>> = {
+    promptTokens: 0,
+    completionTokens: 0,
+    totalTokens: 0,
+    cachedTokens: 0,
+  };
   private apiKeyRotator: ApiKeyRotator | null;
   private rateLimitTracker: RateLimitTracker | null;
   private healthMonitor: HealthMonitor | null;
+  private idempotentRequests: RequestDeduplicator<GeminiResponse>;
+  private recentRequests: RequestDeduplicator<GeminiResponse>;
+  private invalidKeys = new Set<string>();
+  // Replaced on every abort(), so only requests already running see the abort
+  private abortController = new AbortController();
+  private requestSlots: Semaphore;
+  private templates: Map<string, string>;
 
   constructor(options: GemBackOptions) {
-    if (!options.apiKey && (!options.apiKeys || options.apiKeys.length === 0)) {
+    const hasApiKey = options.apiKey || (options.apiKeys && options.apiKeys.length > 0);
+    if (!hasApiKey && !options.offline) {
       throw new Error('Either apiKey or apiKeys must be provided');
     }
 
     this.options = { ...DEFAULT_CLIENT_OPTIONS, ...options } as Required<
       Omit<GemBackOptions, 'apiKey' | 'apiKeys'>
     > & { apiKey?: string; apiKeys?: string[] };
+    // modelWeights keys are resolved on use, since they may also be aliases
+    if (options.modelConfigs) {
+      this.options.modelConfigs = normalizeModelKeys(options.modelConfigs);
+    }
+    if (options.modelPricing) {
+      this.options.modelPricing = normalizeModelKeys(options.modelPricing);
+    }
 
     this.logger = new Logger(this.options.debug ? 'debug' : this.options.logLevel, '[GemBack]');
-    this.client = new GeminiClient(this.options.timeout);
+    this.client = this.options.offline
+      ? new OfflineClient(this.options.cannedResponses)
+      : new GeminiClient(this.options.timeout, {
+          textPartSelector: this.options.textPartSelector,
+          apiVersion: this.options.apiVersion,
+          forwardLabels: this.options.forwardLabels,
+          logger: this.logger,
+        });
+    if (this.options.offline) {
+      this.logger.warn('Offline mode: serving canned responses, no API calls will be made');
+    }
 
     const apiKeys = options.apiKeys || (options.apiKey ? [options.apiKey] : []);
     this.apiKeyRotator =
       apiKeys.length > 1
-        ? new ApiKeyRotator(apiKeys, options.apiKeyRotationStrategy || 'round-robin')
+        ? new ApiKeyRotator(
+            apiKeys,
+            options.apiKeyRotationStrategy || 'round-robin',
+            options.keyQuota,
+            options.keyCircuitBreaker,
+            (keyIndex, state) => this.notifyKeyState(apiKeys[keyIndex], state)
+          )
         : null;
 
     const singleKey = !this.apiKeyRotator;
@@ -68,26 +350,93 @@ export class GemBack {
       this.logger.info('Monitoring enabled: Rate limit tracking and health monitoring');
     }
 
+    this.idempotentRequests = new RequestDeduplicator(this.options.idempotencyTTL);
+    this.recentRequests = new RequestDeduplicator(this.options.dedupWindow ?? 0);
+    this.requestSlots = new Semaphore(this.options.maxConcurrency ?? 0);
+    this.templates = new Map(Object.entries(options.templates ?? {}));
+
     this.stats = {
       totalRequests: 0,
       successRate: 0,
       modelUsage: Object.fromEntries(ALL_MODELS.map((m) => [m, 0])) as Record<GeminiModel, number>,
       failureCount: 0,
+      fallbackDepths: {},
       apiKeyStats: this.apiKeyRotator ? this.apiKeyRotator.getStats() : undefined,
     };
   }
 
+  /**
+   * Checks a single API key with a throwaway client, e.g. before adding it to a pool.
+   * Resolves if the key is valid; otherwise throws a GeminiBackError with code
+   * 'INVALID_API_KEY', 'QUOTA_EXCEEDED' or 'NETWORK_ERROR'.
+   */
+  static async checkKey(apiKey: string): Promise<void> {
+    const client = new GeminiClient();
+
+    let isValid: boolean;
+    try {
+      isValid = await client.validateApiKey(apiKey);
+    } catch (error) {
+      const err = error as Error;
+      const statusCode = getErrorStatusCode(err);
+      if (isRateLimitError(err)) {
+        throw new GeminiBackError(
+          `API key is rate limited or out of quota: ${err.message}`,
+          'QUOTA_EXCEEDED',
+          [],
+          statusCode
+        );
+      }
+      throw new GeminiBackError(
+        `Could not verify API key: ${err.message}`,
+        'NETWORK_ERROR',
+        [],
+        statusCode
+      );
+    }
+
+    if (!isValid) {
+      throw new GeminiBackError('Invalid API key. Please check the key.', 'INVALID_API_KEY');
+    }
+  }
+
+  /**
+   * Creates a client with this client's configuration plus `overrides`, e.g. a shorter
+   * timeout or a different fallback order for one feature, without repeating the keys.
+   * The child shares the pool of SDK clients unless an override changes how they are
+   * built (`timeout`, `apiVersion`, `textPartSelector`, `forwardLabels`, `debug`,
+   * `logLevel`, `offline`, `cannedResponses`).
+   * Registered templates are copied. Key rotation, statistics, token usage, monitoring
+   * and idempotency state are independent of the parent.
+   */
+  clone(overrides: Partial<GemBackOptions> = {}): GemBack {
+    const child = new GemBack({ ...this.options, ...overrides } as GemBackOptions);
+    const rebuildsClient = CLIENT_OPTION_KEYS.some((key) => key in overrides);
+    if (!rebuildsClient) {
+      child.client = this.client;
+    }
+    child.templates = new Map([...this.templates, ...child.templates]);
+    return child;
+  }
+
+  /**
+   * Aborts every request in flight (including streams and batches), e.g. for an emergency
+   * shutdown. They fail promptly with code 'ABORTED'; API calls already sent are not
+   * cancelled, their results are discarded. The client stays usable: requests started
+   * after `abort()` run normally.
+   */
+  abort(): void {
+    this.abortController.abort();
+    this.abortController = new AbortController();
+    this.logger.warn('Aborted all in-flight requests');
+  }
+
   /**
    * Validates the configured API key(s).
    * Throws an error if any of the keys are invalid.
    */
   async initialize(): Promise<void> {
-    const keysToCheck: string[] = [];
-    if (this.options.apiKeys && this.options.apiKeys.length > 0) {
-      keysToCheck.push(...this.options.apiKeys);
-    } else if (this.options.apiKey) {
-      keysToCheck.push(this.options.apiKey);
-    }
+    const keysToCheck = this.getConfiguredKeys();
 
     this.logger.debug(`Validating ${keysToCheck.length} API key(s)...`);
 
@@ -116,422 +465,937 @@ export class GemBack {
     this.logger.info('API key validation successful.');
   }
 
-  private getApiKey(): { key: string; index: number | null } {
-    if (this.apiKeyRotator) {
-      const result = this.apiKeyRotator.getNextKey();
-      return { key: result.key, index: result.index };
+  /**
+   * Creates the SDK client for every configured key in parallel, so the first requests
+   * don't pay for client setup. With `ping`, each key also makes a lightweight API call
+   * to open its connection. Failures are collected into one 'WARMUP_FAILED' error.
+   */
+  async warmup(options: { ping?: boolean } = {}): Promise<void> {
+    const keys = this.getConfiguredKeys();
+    const results = await Promise.allSettled(
+      keys.map(async (key) => {
+        this.client.warmup(key);
+        if (options.ping && !(await this.client.validateApiKey(key))) {
+          throw new Error('Invalid API key');
+        }
+      })
+    );
+
+    const failures = results.flatMap((result, index) =>
+      result.status === 'rejected'
+        ? [`${this.options.keyMasker(keys[index])}: ${(result.reason as Error).message}`]
+        : []
+    );
+    if (failures.length > 0) {
+      throw new GeminiBackError(
+        `Warmup failed for ${failures.length} of ${keys.length} API key(s): ${failures.join('; ')}`,
+        'WARMUP_FAILED'
+      );
     }
-    return { key: this.options.apiKey || this.options.apiKeys![0], index: null };
+    this.logger.debug(`Warmed up ${keys.length} API key(s)`);
   }
 
-  async generate(prompt: string, options?: GenerateOptions): Promise<GeminiResponse> {
-    this.stats.totalRequests++;
+  /**
+   * Checks every configured key up front, e.g. before a scheduled batch job decides whether
+   * to run. Each key makes one lightweight API call (at most `concurrency` at a time,
+   * default 4); the report adds the client's own view of the key (open circuit, disabled
+   * after a billing failure) and its `keyQuota` headroom. Never throws for a bad key.
+   */
+  async inspectKeys(options: { concurrency?: number } = {}): Promise<KeyInspection[]> {
+    const keys = this.getConfiguredKeys();
+    const slots = new Semaphore(options.concurrency ?? DEFAULT_INSPECT_CONCURRENCY);
+    const headroom = this.apiKeyRotator?.getQuotaHeadroom();
+
+    return Promise.all(
+      keys.map(async (key, keyIndex): Promise<KeyInspection> => {
+        await slots.acquire();
+        try {
+          return {
+            keyIndex,
+            key: this.options.keyMasker(key),
+            ...(await this.checkKeyStatus(key)),
+            circuitOpen: this.apiKeyRotator?.isCircuitOpen(keyIndex) ?? false,
+            disabled: this.apiKeyRotator?.isDisabled(keyIndex) ?? false,
+            ...(headroom?.[keyIndex] !== undefined && { quotaHeadroom: headroom[keyIndex] }),
+          };
+        } finally {
+          slots.release();
+        }
+      })
+    );
+  }
 
-    const attempts: AttemptRecord[] = [];
-    const modelsToTry = options?.model ? [options.model] : this.options.fallbackOrder;
-    const { key: apiKey, index: keyIndex } = this.getApiKey();
+  private async checkKeyStatus(key: string): Promise<Pick<KeyInspection, 'status' | 'error'>> {
+    try {
+      return { status: (await this.client.validateApiKey(key)) ? 'valid' : 'invalid' };
+    } catch (error) {
+      const err = error as Error;
+      return { status: isRateLimitError(err) ? 'rate-limited' : 'error', error: err.message };
+    }
+  }
 
-    for (const model of modelsToTry) {
-      this.logger.debug(
-        `Attempting: ${model}${keyIndex !== null ? ` (API Key #${keyIndex + 1})` : ''}`
-      );
+  private getConfiguredKeys(): string[] {
+    if (this.options.apiKeys && this.options.apiKeys.length > 0) {
+      return [...this.options.apiKeys];
+    }
+    return this.options.apiKey ? [this.options.apiKey] : [];
+  }
 
-      // Check rate limit prediction before making request
-      if (this.rateLimitTracker) {
-        const status = this.rateLimitTracker.getStatus(model);
-        if (status.willExceedSoon) {
-          this.logger.warn(
-            `Rate limit warning for ${model}: ${status.windowStats.requestsInLastMinute}/${status.maxRPM} RPM`
-          );
-        }
-        if (this.rateLimitTracker.wouldExceedLimit(model)) {
-          const waitTime = this.rateLimitTracker.getRecommendedWaitTime(model);
-          this.logger.warn(`Would exceed rate limit for ${model}. Recommended wait: ${waitTime}ms`);
-        }
-      }
+  private getApiKey(keySeed?: string): { key: string; index: number | null } {
+    if (this.apiKeyRotator) {
+      const start = this.getStartKeyIndex(keySeed);
+      const result =
+        start === undefined
+          ? this.apiKeyRotator.getNextKey()
+          : this.apiKeyRotator.getKeyFrom(start);
+      return { key: result.key, index: result.index };
+    }
+    // Offline mode may run without any key
+    return { key: this.options.apiKey || this.options.apiKeys?.[0] || '', index: null };
+  }
 
-      const startTime = Date.now();
-      try {
-        // Record rate limit tracking (tracked by model, not per API key)
-        if (this.rateLimitTracker) {
-          this.rateLimitTracker.recordRequest(model);
-        }
+  // Start index for `keyStartStrategy`; undefined continues the rotation
+  private getStartKeyIndex(keySeed?: string): number | undefined {
+    const strategy = this.options.keyStartStrategy ?? 'rotate';
+    const totalKeys = this.apiKeyRotator!.getTotalKeys();
+    if (strategy === 'hash' && keySeed !== undefined) {
+      return hashToKeyIndex(keySeed, totalKeys);
+    }
+    if (strategy === 'random' || strategy === 'hash') {
+      return Math.floor(Math.random() * totalKeys);
+    }
+    return undefined;
+  }
 
-        const response = await retryWithBackoff(
-          () => this.client.generate(prompt, model, apiKey, options),
-          {
-            maxRetries: this.options.maxRetries,
-            delay: this.options.retryDelay,
-            shouldRetry: (error: Error) => {
-              if (isAuthError(error)) {
-                this.logger.error(`Authentication error for ${model}: ${error.message}`);
-                return false;
-              }
-              if (isRateLimitError(error)) {
-                this.logger.warn(`Rate limit hit for ${model}: ${error.message}`);
-                return false;
-              }
-              return isRetryableError(error);
-            },
-          }
-        );
+  /**
+   * Index (0-based, in `apiKeys` order) of the key the next request would start on,
+   * without advancing the rotation, e.g. for deterministic tests or debugging rotation.
+   * Pass the request's `keySeed` with `keyStartStrategy: 'hash'`. Undefined when the
+   * start is random, since it can't be known in advance.
+   */
+  peekNextKeyIndex(keySeed?: string): number | undefined {
+    const rotator = this.apiKeyRotator;
+    if (!rotator) {
+      return 0;
+    }
+    const strategy = this.options.keyStartStrategy ?? 'rotate';
+    if (strategy === 'hash' && keySeed !== undefined) {
+      return rotator.peekKeyFrom(hashToKeyIndex(keySeed, rotator.getTotalKeys()));
+    }
+    return strategy === 'rotate' ? rotator.peekNextIndex() : undefined;
+  }
 
-        const responseTime = Date.now() - startTime;
+  /**
+   * Resolves a configured alias (e.g. 'flash') to its model name. Names without an
+   * alias pass through; a 'models/' prefix is ignored on names, aliases and targets.
+   */
+  private resolveModel(model: ModelName): GeminiModel {
+    const name = normalizeModelName(model);
+    const aliases = this.options.modelAliases ?? {};
+    const alias = Object.keys(aliases).find((key) => normalizeModelName(key) === name);
+    return normalizeModelName(alias === undefined ? name : aliases[alias]) as GeminiModel;
+  }
 
-        // Record health monitoring
-        if (this.healthMonitor) {
-          this.healthMonitor.recordRequest(model, responseTime, true);
-        }
+  /**
+   * Models for a request: its pinned `model` (e.g. from `generateCompare`) unless it also
+   * gives a `fallbackOrder`; else the `modelRouter`'s choice for its input, if any; else its
+   * own `fallbackOrder` if given, else the client's fallback order. With `modelWeights`, a
+   * fallback order starts with a weighted random pick.
+   */
+  private getModelsToTry(
+    { model, fallbackOrder }: ModelSelection = {},
+    input?: Pick<ModelRouteInput, 'prompt' | 'contents'>
+  ): GeminiModel[] {
+    if (model && !fallbackOrder?.length) {
+      return [this.resolveModel(model)];
+    }
+    if (input && this.options.modelRouter) {
+      const routed = this.options.modelRouter({ ...input, fallbackOrder });
+      if (routed?.length) {
+        return routed.map((m) => this.resolveModel(m));
+      }
+    }
+    if (fallbackOrder?.length) {
+      return this.withWeightedStart(fallbackOrder.map((m) => this.resolveModel(m)));
+    }
+    return this.withWeightedStart(this.options.fallbackOrder.map((m) => this.resolveModel(m)));
+  }
 
-        this.stats.modelUsage[model]++;
-        this.updateSuccessRate();
-        if (keyIndex !== null && this.apiKeyRotator) {
-          this.apiKeyRotator.recordSuccess(keyIndex);
-        }
-        this.logger.info(`Success: ${model} (${responseTime}ms)`);
-        return response;
-      } catch (error) {
-        const err = error as Error;
-        const statusCode = getErrorStatusCode(err);
-        const responseTime = Date.now() - startTime;
+  /**
+   * Moves a weighted random pick (by `modelWeights`) to the front, so load spreads across
+   * equivalent models; the rest keep their order as fallbacks. Models without a weight are
+   * never picked first. Weight keys may be aliases, like the models themselves.
+   */
+  private withWeightedStart(models: GeminiModel[]): GeminiModel[] {
+    if (!this.options.modelWeights) {
+      return models;
+    }
+    const weights = new Map<GeminiModel, number>();
+    for (const [name, weight] of Object.entries(this.options.modelWeights)) {
+      weights.set(this.resolveModel(name), weight);
+    }
+    const weightOf = (model: GeminiModel) => Math.max(weights.get(model) ?? 0, 0);
+    const total = models.reduce((sum, model) => sum + weightOf(model), 0);
+    if (total <= 0) {
+      return models;
+    }
 
-        // Record health monitoring for failure
-        if (this.healthMonitor) {
-          this.healthMonitor.recordRequest(model, responseTime, false, err.message);
-        }
+    let roll = Math.random() * total;
+    const first =
+      models.find((model) => (roll -= weightOf(model)) < 0) ??
+      [...models].reverse().find((model) => weightOf(model) > 0)!;
+    return [first, ...models.filter((model) => model !== first)];
+  }
 
-        attempts.push({
-          model,
-          error: err.message,
-          timestamp: new Date(),
-          statusCode,
-        });
+  async generate(prompt: string, options?: GenerateOptions): Promise<GeminiResponse> {
+    return this.generateCancellable(prompt, options);
+  }
 
-        this.logger.warn(`Failed (${statusCode || 'unknown'}): ${model} - ${err.message}`);
+  /**
+   * generate() that `signal` can cancel on its own, without aborting the whole client.
+   * Cancellable requests are never deduplicated, so cancelling one can't fail another
+   * caller sharing its result. With an `idempotencyKey` the shared request runs without
+   * `signal`; a cancelled caller stops waiting for it while the others still get the result.
+   */
+  private async generateCancellable(
+    prompt: string,
+    options?: GenerateOptions,
+    signal?: AbortSignal
+  ): Promise<GeminiResponse> {
+    if (options?.truncateToTokens !== undefined) {
+      return this.generateTruncated(prompt, options, options.truncateToTokens, signal);
+    }
 
-        if (isAuthError(err)) {
-          this.stats.failureCount++;
-          this.updateSuccessRate();
-          if (keyIndex !== null && this.apiKeyRotator) {
-            this.apiKeyRotator.recordFailure(keyIndex);
-          }
-          throw new GeminiBackError(
-            'Authentication failed. Please check your API key.',
-            'AUTH_ERROR',
-            attempts,
-            statusCode,
-            model
-          );
-        }
+    this.assertPromptSize(this.getPromptByteLength(prompt, options));
 
-        if (modelsToTry.indexOf(model) < modelsToTry.length - 1) {
-          this.logger.info(`Fallback to: ${modelsToTry[modelsToTry.indexOf(model) + 1]}`);
-        }
+    if (options?.idempotencyKey) {
+      const shared = this.idempotentRequests
+        .run(options.idempotencyKey, () => this.generateWithFallback(prompt, options))
+        .then(copyPlainData);
+      if (!signal) {
+        return shared;
       }
+      return abortable(shared, signal).catch((error: unknown) => {
+        throw signal.aborted ? this.abortedError([]) : error;
+      });
     }
-
-    this.stats.failureCount++;
-    this.updateSuccessRate();
-    if (keyIndex !== null && this.apiKeyRotator) {
-      this.apiKeyRotator.recordFailure(keyIndex);
+    const fingerprint =
+      this.options.dedupWindow && !signal
+        ? fingerprintRequest('generate', prompt, options)
+        : undefined;
+    if (fingerprint) {
+      return this.recentRequests
+        .run(fingerprint, () => this.generateWithFallback(prompt, options))
+        .then(copyPlainData);
     }
-    throw new GeminiBackError(
-      'All models failed. Please try again later.',
-      'ALL_MODELS_FAILED',
-      attempts
-    );
+    return this.generateWithFallback(prompt, options, signal);
   }
 
-  private updateSuccessRate(): void {
-    const totalAttempts = this.stats.totalRequests;
-    const successCount = totalAttempts - this.stats.failureCount;
-    this.stats.successRate = totalAttempts > 0 ? successCount / totalAttempts : 0;
+  // Shorthand for callers that only need the text; use generate() for usage, finish reason, ...
+  async generateText(prompt: string, options?: GenerateOptions): Promise<string> {
+    const response = await this.generate(prompt, options);
+    return response.text;
   }
 
-  async *generateStream(prompt: string, options?: GenerateOptions): AsyncGenerator<StreamChunk> {
-    this.stats.totalRequests++;
+  // Like generateText(), pinned to one model
+  async generateTextWithModel(
+    prompt: string,
+    model: ModelName,
+    options?: Omit<GenerateOptions, 'model' | 'fallbackOrder'>
+  ): Promise<string> {
+    return this.generateText(prompt, { ...options, model, fallbackOrder: undefined });
+  }
 
-    const attempts: AttemptRecord[] = [];
-    const modelsToTry = options?.model ? [options.model] : this.options.fallbackOrder;
-    const { key: apiKey, index: keyIndex } = this.getApiKey();
+  /**
+   * Makes a single attempt with the request's first model and the next API key, for
+   * latency-critical single-key deployments: no retries, no fallback, no statistics or
+   * monitoring. The API's error is thrown as-is instead of being wrapped.
+   */
+  async generateOnce(prompt: string, options?: GenerateOptions): Promise<GeminiResponse> {
+    this.assertWithinBudget();
+    const [model] = this.getModelsToTry(options, { prompt });
+    const requestOptions = this.withModelConfig(
+      this.withResponseLanguage(options),
+      model,
+      options?.deadline
+    );
+    const result = await this.withRequestSlot(() =>
+      this.withApiKey((apiKey) => this.client.generate(prompt, model, apiKey, requestOptions))
+    );
+    const response = this.withUsageEstimate(result, prompt);
+    this.assertNotBlocked(response);
+    if (response.usage) {
+      this.recordUsage(response.usage);
+    }
+    return this.cleanText(response, options);
+  }
 
-    for (const model of modelsToTry) {
-      this.logger.debug(
-        `Attempting stream: ${model}${keyIndex !== null ? ` (API Key #${keyIndex + 1})` : ''}`
-      );
+  /**
+   * Runs the prompt on every model concurrently for side-by-side evaluation; unlike
+   * fallback, each model is pinned (keys still rotate). A failure on one model is
+   * reported in its entry instead of failing the whole call.
+   */
+  async generateCompare(
+    prompt: string,
+    models: ModelName[],
+    options?: Omit<GenerateOptions, 'model' | 'fallbackOrder' | 'idempotencyKey'>
+  ): Promise<Record<string, CompareResult>> {
+    const settled = await Promise.allSettled(
+      models.map((model) =>
+        this.generate(prompt, {
+          ...options,
+          model,
+          fallbackOrder: undefined,
+          idempotencyKey: undefined,
+        })
+      )
+    );
+    return Object.fromEntries(
+      settled.map((result, index) => [
+        models[index],
+        result.status === 'fulfilled'
+          ? { response: result.value }
+          : { error: result.reason as Error },
+      ])
+    );
+  }
 
-      // Check rate limit prediction before making request
-      if (this.rateLimitTracker) {
-        const status = this.rateLimitTracker.getStatus(model);
-        if (status.willExceedSoon) {
-          this.logger.warn(
-            `Rate limit warning for ${model}: ${status.windowStats.requestsInLastMinute}/${status.maxRPM} RPM`
-          );
-        }
-        if (this.rateLimitTracker.wouldExceedLimit(model)) {
-          const waitTime = this.rateLimitTracker.getRecommendedWaitTime(model);
-          this.logger.warn(`Would exceed rate limit for ${model}. Recommended wait: ${waitTime}ms`);
-        }
-      }
+  /**
+   * Races the prompt on every model (each pinned, as in `generateCompare`) and returns
+   * the cheapest success by `modelPricing`. Once the first model succeeds, the others
+   * get `windowMs` to finish; those still running are then cancelled like on abort(), so
+   * they start no further retries or fallbacks. Models without a price or usage rank last,
+   * and ties go to the fastest.
+   */
+  async generateCheapest(
+    prompt: string,
+    models: ModelName[],
+    options: GenerateCheapestOptions = {}
+  ): Promise<GeminiResponse> {
+    if (models.length === 0) {
+      throw new GeminiBackError('generateCheapest() needs at least one model', 'INVALID_REQUEST');
+    }
+    const { windowMs = DEFAULT_CHEAPEST_WINDOW_MS, ...generateOptions } = options;
+    const responses: GeminiResponse[] = [];
+    const errors: Error[] = [];
+    // One controller per candidate, so late ones can be cancelled without aborting the client
+    const controllers = models.map(() => new AbortController());
+    const clientSignal = this.abortController.signal;
+    const cancelAll = () => controllers.forEach((controller) => controller.abort());
+    clientSignal.addEventListener('abort', cancelAll, { once: true });
+
+    await new Promise<void>((resolve) => {
+      let pending = models.length;
+      let window: NodeJS.Timeout | undefined;
+      models.forEach((model, index) => {
+        const { signal } = controllers[index];
+        this.generateCancellable(
+          prompt,
+          { ...generateOptions, model, fallbackOrder: undefined, idempotencyKey: undefined },
+          signal
+        )
+          .then(
+            (response) => {
+              responses.push(response);
+              window ??= setTimeout(resolve, windowMs);
+            },
+            (error: Error) => void errors.push(error)
+          )
+          .finally(() => {
+            if (--pending === 0) {
+              clearTimeout(window);
+              resolve();
+            }
+          });
+      });
+    });
+    // Candidates still running answered too late to be picked
+    clientSignal.removeEventListener('abort', cancelAll);
+    cancelAll();
+
+    if (responses.length === 0) {
+      throw new GeminiBackError(
+        `All ${models.length} candidate models failed: ${errors.map((e) => e.message).join('; ')}`,
+        'ALL_MODELS_FAILED',
+        errors.flatMap((error) => (error instanceof GeminiBackError ? error.allAttempts : []))
+      );
+    }
+    const cost = (response: GeminiResponse) =>
+      estimateCost(response.usage, this.options.modelPricing?.[response.model]) ?? Infinity;
+    const cheapest = responses.reduce((best, response) =>
+      cost(response) < cost(best) ? response : best
+    );
+    this.logger.debug(`generateCheapest: picked ${cheapest.model} of ${responses.length} response(s)`);
+    return cheapest;
+  }
 
-      const startTime = Date.now();
-      try {
-        // Record rate limit tracking (tracked by model, not per API key)
-        if (this.rateLimitTracker) {
-          this.rateLimitTracker.recordRequest(model);
-        }
+  /**
+   * Counts the prompt's tokens with the first model the request would try
+   * (`options.fallbackOrder`, `options.model`, or the client's fallback order).
+   */
+  async countTokens(prompt: string, options?: ModelSelection): Promise<number> {
+    const [model] = this.getModelsToTry(options, { prompt });
+    return this.withApiKey((apiKey) =>
+      this.client.countTokens([{ role: 'user', parts: [{ text: prompt }] }], model, apiKey)
+    );
+  }
 
-        const stream = this.client.generateStream(prompt, model, apiKey, options);
-        let hasYielded = false;
+  /**
+   * Counts the tokens of multimodal contents (text, inline images, uploaded files, ...)
+   * with the first model `generateContent` would try for the same request. Every part is
+   * sent, so the total includes media tokens.
+   */
+  async countContentTokens(
+    request: Pick<GenerateContentRequest, 'contents' | 'model' | 'fallbackOrder'>
+  ): Promise<number> {
+    const [model] = this.getModelsToTry(request, { contents: request.contents });
+    return this.withApiKey((apiKey) => this.client.countTokens(request.contents, model, apiKey));
+  }
 
-        for await (const chunk of stream) {
-          hasYielded = true;
-          yield {
-            text: chunk.text,
-            model,
-            isComplete: false,
-          };
-        }
+  private async generateTruncated(
+    prompt: string,
+    options: GenerateOptions,
+    maxTokens: number,
+    signal?: AbortSignal
+  ): Promise<GeminiResponse> {
+    const { prompt: truncated, truncatedTokens } = await this.truncatePrompt(
+      prompt,
+      maxTokens,
+      options
+    );
+    const response = await this.generateCancellable(
+      truncated,
+      { ...options, truncateToTokens: undefined },
+      signal
+    );
+    return truncatedTokens > 0 ? { ...response, truncatedTokens } : response;
+  }
 
-        if (hasYielded) {
-          yield {
-            text: '',
-            model,
-            isComplete: true,
-          };
+  /**
+   * Cuts the prompt (by code points, so characters are never split) until it fits in
+   * `maxTokens`. Each pass scales the length by the measured overshoot, with a small
+   * margin since tokens are not spread evenly.
+   */
+  private async truncatePrompt(
+    prompt: string,
+    maxTokens: number,
+    options: GenerateOptions
+  ): Promise<{ prompt: string; truncatedTokens: number }> {
+    const originalTokens = await this.countTokens(prompt, options);
+    if (originalTokens <= maxTokens) {
+      return { prompt, truncatedTokens: 0 };
+    }
 
-          const responseTime = Date.now() - startTime;
+    const chars = Array.from(prompt);
+    let keep = chars.length;
+    let tokens = originalTokens;
+    let truncated = prompt;
+
+    for (let pass = 0; pass < MAX_TRUNCATION_PASSES && tokens > maxTokens; pass++) {
+      keep = Math.floor(keep * (maxTokens / tokens) * 0.95);
+      truncated =
+        options.truncateFrom === 'head'
+          ? chars.slice(chars.length - keep).join('')
+          : chars.slice(0, keep).join('');
+      tokens = await this.countTokens(truncated, options);
+    }
 
-          // Record health monitoring
-          if (this.healthMonitor) {
-            this.healthMonitor.recordRequest(model, responseTime, true);
-          }
+    if (tokens > maxTokens) {
+      throw new GeminiBackError(
+        `Could not truncate prompt to ${maxTokens} tokens (still ${tokens}).`,
+        'PROMPT_TOO_LARGE'
+      );
+    }
 
-          this.stats.modelUsage[model]++;
-          this.updateSuccessRate();
-          if (keyIndex !== null && this.apiKeyRotator) {
-            this.apiKeyRotator.recordSuccess(keyIndex);
-          }
-          this.logger.info(`Stream success: ${model} (${responseTime}ms)`);
-          return;
-        }
-      } catch (error) {
-        const err = error as Error;
-        const statusCode = getErrorStatusCode(err);
-        const responseTime = Date.now() - startTime;
+    this.logger.info(`Truncated prompt from ${originalTokens} to ${tokens} tokens`);
+    return { prompt: truncated, truncatedTokens: originalTokens - tokens };
+  }
 
-        // Record health monitoring for failure
-        if (this.healthMonitor) {
-          this.healthMonitor.recordRequest(model, responseTime, false, err.message);
-        }
+  private async generateWithFallback(
+    prompt: string,
+    options?: GenerateOptions,
+    signal?: AbortSignal
+  ): Promise<GeminiResponse> {
+    const requestOptions = this.withResponseLanguage(options);
+    const response = await this.runAudited(prompt, () =>
+      this.withRequestSlot(() =>
+        this.executeWithFallback(
+          this.getModelsToTry(options, { prompt }),
+          (model, apiKey) =>
+            this.client
+              .generate(
+                prompt,
+                model,
+                apiKey,
+                this.withModelConfig(requestOptions, model, options?.deadline)
+              )
+              .then((result) => this.withUsageEstimate(result, prompt)),
+          { deadline: options?.deadline, keySeed: options?.keySeed, signal }
+        )
+      )
+    );
+    const cleaned = this.cleanText(response, options);
+    return this.withRequestInfo(
+      await this.spillOutput(cleaned, options?.spillOutput),
+      prompt,
+      options
+    );
+  }
 
-        attempts.push({
-          model,
-          error: err.message,
-          timestamp: new Date(),
-          statusCode,
-        });
+  private withResponseLanguage(options?: GenerateOptions): GenerateOptions | undefined {
+    if (!options?.responseLanguage) {
+      return options;
+    }
+    const { systemInstruction, responseLanguage } = options;
+    return {
+      ...options,
+      systemInstruction: composeSystemInstruction(systemInstruction, responseLanguage),
+    };
+  }
 
-        this.logger.warn(`Stream failed (${statusCode || 'unknown'}): ${model}`);
+  private getPromptByteLength(prompt: string, options?: GenerateOptions): number {
+    const parts = options?.parts ?? [];
+    return Buffer.byteLength(prompt, 'utf8') + getContentsByteLength([{ role: 'user', parts }]);
+  }
 
-        if (isAuthError(err)) {
-          this.stats.failureCount++;
-          this.updateSuccessRate();
-          if (keyIndex !== null && this.apiKeyRotator) {
-            this.apiKeyRotator.recordFailure(keyIndex);
-          }
-          throw new GeminiBackError(
-            'Authentication failed. Please check your API key.',
-            'AUTH_ERROR',
-            attempts,
-            statusCode,
-            model
-          );
-        }
+  // Rejects oversized prompts before any API call; `maxPromptBytes` of 0 disables the guard
+  private assertPromptSize(bytes: number): void {
+    const limit = this.options.maxPromptBytes;
+    if (limit && bytes > limit) {
+      throw new GeminiBackError(
+        `Prompt is ${bytes} bytes, exceeding maxPromptBytes (${limit}).`,
+        'PROMPT_TOO_LARGE'
+      );
+    }
+  }
 
-        if (modelsToTry.indexOf(model) < modelsToTry.length - 1) {
-          this.logger.info(`Fallback to: ${modelsToTry[modelsToTry.indexOf(model) + 1]}`);
-        }
-      }
+  /**
+   * Writes the response text to the caller's writer when it exceeds the threshold. Every
+   * field that holds the output (`text`, `json`, `logprobs`, text parts and candidate
+   * texts) is dropped from the returned response, so the text is not retained in memory.
+   * A write failure fails the request with SPILL_FAILED. Streaming never buffers the full
+   * text, so it does not need spilling.
+   */
+  private async spillOutput(
+    response: GeminiResponse,
+    spill?: OutputSpillOptions
+  ): Promise<GeminiResponse> {
+    if (!spill) {
+      return response;
     }
 
-    this.stats.failureCount++;
-    this.updateSuccessRate();
-    if (keyIndex !== null && this.apiKeyRotator) {
-      this.apiKeyRotator.recordFailure(keyIndex);
+    const bytes = Buffer.byteLength(response.text, 'utf8');
+    if (bytes <= spill.thresholdBytes) {
+      return response;
     }
-    throw new GeminiBackError(
-      'All models failed for streaming. Please try again later.',
-      'ALL_MODELS_FAILED',
-      attempts
+
+    try {
+      await writeOutput(spill.writer, response.text);
+    } catch (error) {
+      throw new GeminiBackError(
+        `Failed to write spilled output: ${(error as Error).message}`,
+        'SPILL_FAILED'
+      );
+    }
+    this.logger.debug(`Spilled ${bytes} bytes of output to writer`);
+
+    const { json: _json, logprobs: _logprobs, ...metadata } = response;
+    return {
+      ...metadata,
+      text: '',
+      content: metadata.content && { ...metadata.content, textParts: [] },
+      candidates: metadata.candidates?.map((candidate) => ({ ...candidate, text: '' })),
+      spilled: { bytes, writer: spill.writer },
+    };
+  }
+
+  // With `estimateMissingUsage`, fills in a rough local count when the API reported no usage
+  private withUsageEstimate(response: GeminiResponse, prompt: string | Content[]): GeminiResponse {
+    if (response.usage || !this.options.estimateMissingUsage) {
+      return response;
+    }
+    const promptTokens = estimateTokens(
+      typeof prompt === 'string' ? prompt : getContentsText(prompt)
     );
+    const completionTokens = estimateTokens(response.text);
+    return {
+      ...response,
+      usage: {
+        promptTokens,
+        completionTokens,
+        totalTokens: promptTokens + completionTokens,
+        estimated: true,
+      },
+    };
   }
 
-  async chat(messages: ChatMessage[], options?: GenerateOptions): Promise<GeminiResponse> {
-    const conversationPrompt = messages
-      .map((msg) => `${msg.role === 'user' ? 'User' : 'Assistant'}: ${msg.content}`)
-      .join('\n\n');
+  // With `throwOnBlocked`, turns a prompt or response blocked by safety filters into an error
+  private assertNotBlocked(response: GeminiResponse): void {
+    if (!this.options.throwOnBlocked) {
+      return;
+    }
+    const blocked = getBlockedError(response);
+    if (blocked) {
+      throw blocked;
+    }
+  }
 
-    const finalPrompt = `${conversationPrompt}\n\nAssistant:`;
+  /**
+   * Applies `sanitizeOutput`, `stripMarkdown` and `trimOutput` to `text` only; candidates
+   * and content parts are left as returned.
+   */
+  private cleanText(
+    response: GeminiResponse,
+    options?: Pick<GenerateOptions, 'trimOutput' | 'stripMarkdown'>
+  ): GeminiResponse {
+    let text = response.text;
+    if (this.options.sanitizeOutput) {
+      text = sanitizeText(text);
+    }
+    if (options?.stripMarkdown ?? this.options.stripMarkdown) {
+      text = stripMarkdown(text);
+    }
+    if (options?.trimOutput ?? this.options.trimOutput) {
+      text = text.trim();
+    }
+    return text === response.text ? response : { ...response, text };
+  }
 
-    return this.generate(finalPrompt, options);
+  // Copies the prompt (opt-in, as prompts can be large) and labels onto the response
+  private withRequestInfo(
+    response: GeminiResponse,
+    prompt: string | Content[],
+    options?: Pick<GenerateOptions, 'echoPrompt' | 'labels'>
+  ): GeminiResponse {
+    if (!options?.echoPrompt && !options?.labels) {
+      return response;
+    }
+    return {
+      ...response,
+      ...(options.echoPrompt && { prompt }),
+      ...(options.labels && { labels: options.labels }),
+    };
   }
 
-  async generateContent(request: GenerateContentRequest): Promise<GeminiResponse> {
-    this.stats.totalRequests++;
+  private updateSuccessRate(): void {
+    const totalAttempts = this.stats.totalRequests;
+    const successCount = totalAttempts - this.stats.failureCount;
+    this.stats.successRate = totalAttempts > 0 ? successCount / totalAttempts : 0;
+  }
 
-    const attempts: AttemptRecord[] = [];
-    const modelsToTry = request.model ? [request.model] : this.options.fallbackOrder;
-    const { key: apiKey, index: keyIndex } = this.getApiKey();
+  /**
+   * Orders the (model, API key) pairs to try for one request.
+   * Without `attemptOrder` (or with a single key) the request keeps the rotator's
+   * next key and falls back across models. With `attemptOrder`, every key is tried,
+   * starting from the rotator's next key:
+   * - 'keys-first': all keys on a model before falling back to the next model
+   * - 'models-first': all models on a key before rotating to the next key
+   */
+  private buildAttemptPlan(modelsToTry: GeminiModel[], keySeed?: string): AttemptTarget[] {
+    const { key, index } = this.getApiKey(keySeed);
+    const rotator = this.apiKeyRotator;
+    if (index === null || !rotator || !this.options.attemptOrder) {
+      return modelsToTry.map((model) => ({ model, apiKey: key, keyIndex: index }));
+    }
 
-    for (const model of modelsToTry) {
-      this.logger.debug(
-        `Attempting multimodal: ${model}${keyIndex !== null ? ` (API Key #${keyIndex + 1})` : ''}`
+    const totalKeys = rotator.getTotalKeys();
+    const pinned = rotator.getPinnedIndex() !== undefined;
+    const keyOrder = Array.from({ length: totalKeys }, (_, offset) => (index + offset) % totalKeys)
+      // Keys with disabled billing or an open circuit are left out (never the start key);
+      // with a pinned key, so is every other key
+      .filter(
+        (keyIndex) =>
+          keyIndex === index ||
+          (!pinned && !(rotator.isDisabled(keyIndex) || rotator.isCircuitOpen(keyIndex)))
       );
+    const target = (model: GeminiModel, keyIndex: number): AttemptTarget => ({
+      model,
+      apiKey: rotator.getKeyByIndex(keyIndex)!,
+      keyIndex,
+    });
+
+    if (this.options.attemptOrder === 'keys-first') {
+      return modelsToTry.flatMap((model) => keyOrder.map((keyIndex) => target(model, keyIndex)));
+    }
+    return keyOrder.flatMap((keyIndex) => modelsToTry.map((model) => target(model, keyIndex)));
+  }
 
-      // Check rate limit prediction before making request
-      if (this.rateLimitTracker) {
-        const status = this.rateLimitTracker.getStatus(model);
-        if (status.willExceedSoon) {
-          this.logger.warn(
-            `Rate limit warning for ${model}: ${status.windowStats.requestsInLastMinute}/${status.maxRPM} RPM`
+  /**
+   * Runs a non-streaming request and records its audit entry once its outcome is final,
+   * i.e. after `finalFallback` and the blocked-response check. Failed requests (blocked
+   * ones included) are recorded only with `auditFailures`.
+   */
+  private async runAudited(
+    prompt: string | Content[],
+    run: () => Promise<ChainAnswer>
+  ): Promise<GeminiResponse> {
+    const requestStart = Date.now();
+    try {
+      const { response, maskedKey } = await run();
+      this.assertNotBlocked(response);
+      this.recordAudit({
+        timestamp: new Date(),
+        durationMs: Date.now() - requestStart,
+        prompt,
+        model: response.model,
+        maskedKey,
+        response: response.text,
+        usage: response.usage,
+      });
+      return response;
+    } catch (error) {
+      if (this.options.auditFailures) {
+        const err = error as Error;
+        this.recordAudit({
+          timestamp: new Date(),
+          durationMs: Date.now() - requestStart,
+          prompt,
+          error: {
+            code: err instanceof GeminiBackError ? err.code : 'UNKNOWN_ERROR',
+            message: err.message,
+          },
+        });
+      }
+      throw error;
+    }
+  }
+
+  // Runs a non-streaming request through the fallback chain
+  private async executeWithFallback(
+    modelsToTry: GeminiModel[],
+    call: (model: GeminiModel, apiKey: string) => Promise<GeminiResponse>,
+    { kind, deadline, keySeed, signal = this.abortController.signal }: ExecutionContext = {}
+  ): Promise<ChainAnswer> {
+    this.assertWithinBudget();
+    this.stats.totalRequests++;
+
+    const attempts: AttemptRecord[] = [];
+    const usedKeys = new Set<number>();
+    let validationError: ResponseValidationError | undefined;
+    let validationFailures = 0;
+
+    for (let pass = 0; ; pass++) {
+      const plan = this.buildAttemptPlan(modelsToTry, keySeed);
+      const skippedModels = new Set<GeminiModel>();
+      const rateLimitedKeys = new Set<number>();
+      const attemptedModels = new Set<GeminiModel>();
+      const passStart = attempts.length;
+      let rateLimitedAttempts = 0;
+
+      for (const [position, { model, apiKey, keyIndex }] of plan.entries()) {
+        if (skippedModels.has(model)) {
+          continue;
+        }
+        if (signal.aborted) {
+          throw this.failRequest(usedKeys, this.abortedError(attempts));
+        }
+        if (deadline !== undefined && Date.now() >= deadline) {
+          throw this.failRequest(
+            usedKeys,
+            new GeminiBackError(
+              'Request deadline exceeded before all models could be tried.',
+              'DEADLINE_EXCEEDED',
+              attempts
+            )
           );
         }
-        if (this.rateLimitTracker.wouldExceedLimit(model)) {
-          const waitTime = this.rateLimitTracker.getRecommendedWaitTime(model);
-          this.logger.warn(`Would exceed rate limit for ${model}. Recommended wait: ${waitTime}ms`);
+        if (this.skipRateLimitedKey(plan, position, rateLimitedKeys, attemptedModels)) {
+          continue;
         }
-      }
-
-      const startTime = Date.now();
-      try {
-        // Record rate limit tracking (tracked by model, not per API key)
-        if (this.rateLimitTracker) {
-          this.rateLimitTracker.recordRequest(model);
+        if (deadline !== undefined && this.skipForDeadline(attempts, model, deadline)) {
+          continue;
         }
+        attemptedModels.add(model);
+        this.markKeyUsed(keyIndex, usedKeys);
+        this.logger.debug(
+          `Attempting${kind ? ` ${kind}` : ''}: ${model}${keyIndex !== null ? ` (API Key #${keyIndex + 1})` : ''}`
+        );
+        this.checkRateLimitPrediction(model);
+
+        const startTime = Date.now();
+        const keyInfo = { keyIndex, maskedKey: this.options.keyMasker(apiKey), model };
+        let response: GeminiResponse;
+        try {
+          // Record rate limit tracking (tracked by model, not per API key)
+          if (this.rateLimitTracker) {
+            this.rateLimitTracker.recordRequest(model);
+          }
 
-        const response = await retryWithBackoff(
-          () =>
-            this.client.generateContent(request.contents, model, apiKey, {
-              temperature: request.temperature,
-              maxTokens: request.maxTokens,
-              topP: request.topP,
-              topK: request.topK,
-              systemInstruction: request.systemInstruction,
-              tools: request.tools,
-              toolConfig: request.toolConfig,
-              safetySettings: request.safetySettings,
-              responseMimeType: request.responseMimeType,
-              responseSchema: request.responseSchema,
-            }),
-          {
+          const attempt = () =>
+            runWithKeyInfo(keyInfo, () => this.callChecked(call, model, apiKey));
+          const retries = retryWithBackoff(attempt, {
             maxRetries: this.options.maxRetries,
             delay: this.options.retryDelay,
-            shouldRetry: (error: Error) => {
-              if (isAuthError(error)) {
-                this.logger.error(`Authentication error for ${model}: ${error.message}`);
-                return false;
-              }
-              if (isRateLimitError(error)) {
-                this.logger.warn(`Rate limit hit for ${model}: ${error.message}`);
-                return false;
-              }
-              return isRetryableError(error);
-            },
+            jitter: this.options.retryJitter,
+            backoffFor: (error: Error) => this.getBackoff(error),
+            maxRetriesFor: (error: Error) => this.getRetryCount(error),
+            shouldRetry: (error: Error) => !signal.aborted && this.shouldRetry(error, model),
+            signal,
+            deadline,
+            onDeadline: (delay, remaining) =>
+              this.logger.warn(
+                `Skipping retry of ${model}: ${Math.round(delay)}ms backoff exceeds the ${remaining}ms left before the deadline`
+              ),
+          });
+          response = await abortable(retries, signal);
+        } catch (error) {
+          if (signal.aborted) {
+            throw this.failRequest(usedKeys, this.abortedError(attempts));
           }
-        );
+          const err = error as Error;
+          const statusCode = this.recordAttemptFailure(
+            attempts,
+            model,
+            keyIndex,
+            err,
+            startTime
+          );
 
-        const responseTime = Date.now() - startTime;
+          this.logger.warn(`Failed (${statusCode || 'unknown'}): ${model} - ${err.message}`);
 
-        // Record health monitoring
-        if (this.healthMonitor) {
-          this.healthMonitor.recordRequest(model, responseTime, true);
-        }
+          if (err instanceof ClientConfigError) {
+            throw this.failRequest(usedKeys, new ClientConfigError(err.message, attempts));
+          }
+          if (isBillingError(err)) {
+            throw this.failRequest(
+              usedKeys,
+              this.billingError(apiKey, keyIndex, attempts, statusCode, model)
+            );
+          }
+          if (isAuthError(err)) {
+            this.markKeyInvalid(apiKey);
+            throw this.failRequest(
+              usedKeys,
+              new GeminiBackError(
+                'Authentication failed. Please check your API key.',
+                'AUTH_ERROR',
+                attempts,
+                statusCode,
+                model
+              )
+            );
+          }
 
-        this.stats.modelUsage[model]++;
-        this.updateSuccessRate();
-        if (keyIndex !== null && this.apiKeyRotator) {
-          this.apiKeyRotator.recordSuccess(keyIndex);
-        }
-        this.logger.info(`Success: ${model} (${responseTime}ms)`);
-        return response;
-      } catch (error) {
-        const err = error as Error;
-        const statusCode = getErrorStatusCode(err);
-        const responseTime = Date.now() - startTime;
+          if (err instanceof ResponseValidationError) {
+            validationError = err;
+            validationFailures++;
+          }
+          if (isRateLimitError(err)) {
+            rateLimitedAttempts++;
+            if (keyIndex !== null) {
+              rateLimitedKeys.add(keyIndex);
+            }
+          }
+          if (!isRateLimitError(err) && !this.isRetryable(err)) {
+            // The request itself is at fault, so the remaining keys would fail the same way
+            skippedModels.add(model);
+          }
 
-        // Record health monitoring for failure
-        if (this.healthMonitor) {
-          this.healthMonitor.recordRequest(model, responseTime, false, err.message);
+          this.logNextAttempt(plan, position, skippedModels);
+          continue;
         }
 
-        attempts.push({
-          model,
-          error: err.message,
-          timestamp: new Date(),
-          statusCode,
-        });
-
-        this.logger.warn(`Failed (${statusCode || 'unknown'}): ${model} - ${err.message}`);
-
-        if (isAuthError(err)) {
-          this.stats.failureCount++;
-          this.updateSuccessRate();
-          if (keyIndex !== null && this.apiKeyRotator) {
-            this.apiKeyRotator.recordFailure(keyIndex);
+        // A blocked answer fails the request instead of counting as a success; the key worked
+        const blocked = this.options.throwOnBlocked ? getBlockedError(response) : undefined;
+        if (blocked) {
+          throw this.failRequest(usedKeys, blocked, keyIndex);
+        }
+        const fallbackDepth = modelsToTry.indexOf(model);
+        this.recordSuccess(model, fallbackDepth, keyIndex, usedKeys, startTime, 'Success');
+        this.markKeyValid(apiKey);
+        if (response.usage) {
+          this.recordUsage(response.usage);
+          if (keyIndex !== null) {
+            this.apiKeyRotator?.recordTokens(keyIndex, response.usage.totalTokens);
           }
-          throw new GeminiBackError(
-            'Authentication failed. Please check your API key.',
-            'AUTH_ERROR',
-            attempts,
-            statusCode,
-            model
-          );
         }
+        return { response: { ...response, fallbackDepth }, maskedKey: keyInfo.maskedKey };
+      }
 
-        if (modelsToTry.indexOf(model) < modelsToTry.length - 1) {
-          this.logger.info(`Fallback to: ${modelsToTry[modelsToTry.indexOf(model) + 1]}`);
-        }
+      const passAttempts = attempts.length - passStart;
+      const allRateLimited = passAttempts > 0 && rateLimitedAttempts === passAttempts;
+      if (!allRateLimited || !(await this.waitForPoolRetry(pass, signal, deadline))) {
+        break;
       }
     }
 
-    this.stats.failureCount++;
-    this.updateSuccessRate();
-    if (keyIndex !== null && this.apiKeyRotator) {
-      this.apiKeyRotator.recordFailure(keyIndex);
+    if (validationError && validationFailures === attempts.length) {
+      throw this.failRequest(
+        usedKeys,
+        new GeminiBackError(validationError.message, 'VALIDATION_FAILED', attempts)
+      );
     }
-    throw new GeminiBackError(
-      'All models failed. Please try again later.',
-      'ALL_MODELS_FAILED',
-      attempts
+    throw this.failRequest(usedKeys, this.exhaustedError(modelsToTry, attempts));
+  }
+
+  /**
+   * After a pass in which every attempt was rate limited, waits `poolRetryDelay` before
+   * the whole rotation is tried again (up to `poolRetries` passes). Returns false when no
+   * passes are left or the wait would outlast the request deadline. An abort ends the wait
+   * early; the next pass then fails the request as aborted.
+   */
+  private async waitForPoolRetry(
+    pass: number,
+    signal: AbortSignal,
+    deadline?: number
+  ): Promise<boolean> {
+    const poolRetries = this.options.poolRetries ?? 0;
+    const poolRetryDelay = this.options.poolRetryDelay ?? DEFAULT_POOL_RETRY_DELAY;
+    if (pass >= poolRetries) {
+      return false;
+    }
+    if (deadline !== undefined && Date.now() + poolRetryDelay >= deadline) {
+      this.logger.warn('Not retrying the key pool: the cooldown would outlast the deadline');
+      return false;
+    }
+    this.logger.warn(
+      `All attempts were rate limited; retrying the key pool in ${poolRetryDelay}ms (${pass + 1}/${poolRetries})`
     );
+    await sleep(poolRetryDelay, signal);
+    return true;
   }
 
-  async *generateContentStream(request: GenerateContentRequest): AsyncGenerator<StreamChunk> {
+  /**
+   * Runs a stream through the fallback chain. `signal` cancels just this stream; without
+   * one, the stream watches the client's abort() signal.
+   */
+  private async *executeStreamWithFallback(
+    modelsToTry: GeminiModel[],
+    stream: StreamFactory,
+    { kind, keySeed, signal = this.abortController.signal }: ExecutionContext = {}
+  ): AsyncGenerator<StreamChunk> {
+    this.assertWithinBudget();
     this.stats.totalRequests++;
 
     const attempts: AttemptRecord[] = [];
-    const modelsToTry = request.model ? [request.model] : this.options.fallbackOrder;
-    const { key: apiKey, index: keyIndex } = this.getApiKey();
-
-    for (const model of modelsToTry) {
+    const usedKeys = new Set<number>();
+    const plan = this.buildAttemptPlan(modelsToTry, keySeed);
+    const skippedModels = new Set<GeminiModel>();
+    const rateLimitedKeys = new Set<number>();
+    const attemptedModels = new Set<GeminiModel>();
+    const resumedTargets = new Set<AttemptTarget>();
+    let partialText = '';
+    let idleTimeouts = 0;
+
+    for (const [position, target] of plan.entries()) {
+      const { model } = target;
+      // The key answering this attempt; resuming a stream moves it to another key
+      let { apiKey, keyIndex } = target;
+      if (skippedModels.has(model) || resumedTargets.has(target)) {
+        continue;
+      }
+      if (signal.aborted) {
+        throw this.failRequest(usedKeys, this.abortedError(attempts, partialText));
+      }
+      if (this.skipRateLimitedKey(plan, position, rateLimitedKeys, attemptedModels)) {
+        continue;
+      }
+      attemptedModels.add(model);
+      this.markKeyUsed(keyIndex, usedKeys);
       this.logger.debug(
-        `Attempting multimodal stream: ${model}${keyIndex !== null ? ` (API Key #${keyIndex + 1})` : ''}`
+        `Attempting ${kind ? `${kind} ` : ''}stream: ${model}${keyIndex !== null ? ` (API Key #${keyIndex + 1})` : ''}`
       );
-
-      // Check rate limit prediction before making request
-      if (this.rateLimitTracker) {
-        const status = this.rateLimitTracker.getStatus(model);
-        if (status.willExceedSoon) {
-          this.logger.warn(
-            `Rate limit warning for ${model}: ${status.windowStats.requestsInLastMinute}/${status.maxRPM} RPM`
-          );
-        }
-        if (this.rateLimitTracker.wouldExceedLimit(model)) {
-          const waitTime = this.rateLimitTracker.getRecommendedWaitTime(model);
-          this.logger.warn(`Would exceed rate limit for ${model}. Recommended wait: ${waitTime}ms`);
-        }
-      }
+      this.checkRateLimitPrediction(model);
 
       const startTime = Date.now();
       try {
@@ -540,27 +1404,39 @@ export class GemBack {
           this.rateLimitTracker.recordRequest(model);
         }
 
-        const stream = this.client.generateContentStream(request.contents, model, apiKey, {
-          temperature: request.temperature,
-          maxTokens: request.maxTokens,
-          topP: request.topP,
-          topK: request.topK,
-          systemInstruction: request.systemInstruction,
-          tools: request.tools,
-          toolConfig: request.toolConfig,
-          safetySettings: request.safetySettings,
-        });
         let hasYielded = false;
-
-        for await (const chunk of stream) {
+        const resumeKey = () => {
+          const next = this.pickResumeTarget(plan, position, usedKeys, { model, apiKey, keyIndex });
+          resumedTargets.add(next);
+          ({ apiKey, keyIndex } = next);
+          this.markKeyUsed(keyIndex, usedKeys);
+          return apiKey;
+        };
+
+        const chunks = abortableStream(
+          stream(model, apiKey, resumeKey),
+          signal,
+          this.options.streamIdleTimeout ?? 0
+        );
+        for await (const chunk of chunks) {
+          if (signal.aborted) {
+            break;
+          }
           hasYielded = true;
+          const text = this.options.sanitizeOutput ? sanitizeText(chunk.text) : chunk.text;
+          partialText = chunk.reset ? text : partialText + text;
           yield {
-            text: chunk.text,
+            text,
             model,
             isComplete: false,
+            ...(chunk.reset && { reset: true }),
+            ...(chunk.raw && { raw: chunk.raw }),
           };
         }
 
+        if (signal.aborted) {
+          throw new Error('Request aborted');
+        }
         if (hasYielded) {
           yield {
             text: '',
@@ -568,76 +1444,1167 @@ export class GemBack {
             isComplete: true,
           };
 
-          const responseTime = Date.now() - startTime;
+          this.recordSuccess(
+            model,
+            modelsToTry.indexOf(model),
+            keyIndex,
+            usedKeys,
+            startTime,
+            'Stream success'
+          );
+          this.markKeyValid(apiKey);
+          return;
+        }
+      } catch (error) {
+        if (signal.aborted) {
+          throw this.failRequest(usedKeys, this.abortedError(attempts, partialText));
+        }
+        const err = error as Error;
+        const statusCode = this.recordAttemptFailure(attempts, model, keyIndex, err, startTime);
+
+        this.logger.warn(`Stream failed (${statusCode || 'unknown'}): ${model}`);
+
+        if (err instanceof ClientConfigError) {
+          throw this.failRequest(usedKeys, new ClientConfigError(err.message, attempts));
+        }
+        if (isBillingError(err)) {
+          throw this.failRequest(
+            usedKeys,
+            this.billingError(apiKey, keyIndex, attempts, statusCode, model)
+          );
+        }
+        if (isAuthError(err)) {
+          this.markKeyInvalid(apiKey);
+          throw this.failRequest(
+            usedKeys,
+            new GeminiBackError(
+              'Authentication failed. Please check your API key.',
+              'AUTH_ERROR',
+              attempts,
+              statusCode,
+              model
+            )
+          );
+        }
+
+        if (err instanceof StreamIdleTimeoutError) {
+          idleTimeouts++;
+        }
+        if (isRateLimitError(err) && keyIndex !== null) {
+          rateLimitedKeys.add(keyIndex);
+        }
+        if (!isRateLimitError(err) && !this.isRetryable(err)) {
+          // The request itself is at fault, so the remaining keys would fail the same way
+          skippedModels.add(model);
+        }
+
+        this.logNextAttempt(plan, position, skippedModels);
+      }
+    }
+
+    if (idleTimeouts > 0 && idleTimeouts === attempts.length) {
+      throw this.failRequest(
+        usedKeys,
+        new GeminiBackError(
+          `Every stream stalled: no chunk received within ${this.options.streamIdleTimeout}ms.`,
+          'STREAM_IDLE_TIMEOUT',
+          attempts
+        )
+      );
+    }
+    throw this.failRequest(usedKeys, this.exhaustedError(modelsToTry, attempts, 'streaming'));
+  }
+
+  // With `retryOnIncomplete`, turns a possibly truncated response into a retryable error
+  private async callChecked(
+    call: (model: GeminiModel, apiKey: string) => Promise<GeminiResponse>,
+    model: GeminiModel,
+    apiKey: string
+  ): Promise<GeminiResponse> {
+    const response = await call(model, apiKey);
+    if (this.options.retryOnIncomplete && isIncompleteResponse(response)) {
+      throw new IncompleteResponseError(response.finishReason);
+    }
+    if (this.options.validator) {
+      try {
+        await this.options.validator(response);
+      } catch (error) {
+        throw new ResponseValidationError((error as Error).message);
+      }
+    }
+    return response;
+  }
+
+  /**
+   * Builds the final error once every attempt has failed. A single pinned model that
+   * ran out of keys ('ALL_KEYS_EXHAUSTED') is distinguished from a fallback chain where
+   * every model failed ('ALL_MODELS_FAILED'), since only the latter rules out other models.
+   */
+  private exhaustedError(
+    modelsToTry: GeminiModel[],
+    attempts: AttemptRecord[],
+    kind?: 'streaming'
+  ): GeminiBackError {
+    const suffix = kind ? ` for ${kind}` : '';
+    if (modelsToTry.length === 1) {
+      return new GeminiBackError(
+        `All API keys failed for ${modelsToTry[0]}${suffix}. Please try again later.`,
+        'ALL_KEYS_EXHAUSTED',
+        attempts,
+        undefined,
+        modelsToTry[0]
+      );
+    }
+    return new GeminiBackError(
+      `All models failed${suffix}. Please try again later.`,
+      'ALL_MODELS_FAILED',
+      attempts
+    );
+  }
+
+  private checkRateLimitPrediction(model: GeminiModel): void {
+    if (!this.rateLimitTracker) {
+      return;
+    }
+
+    const status = this.rateLimitTracker.getStatus(model);
+    if (status.willExceedSoon) {
+      this.logger.warn(
+        `Rate limit warning for ${model}: ${status.windowStats.requestsInLastMinute}/${status.maxRPM} RPM`
+      );
+    }
+    if (this.rateLimitTracker.wouldExceedLimit(model)) {
+      const waitTime = this.rateLimitTracker.getRecommendedWaitTime(model);
+      this.logger.warn(`Would exceed rate limit for ${model}. Recommended wait: ${waitTime}ms`);
+    }
+  }
+
+  private shouldRetry(error: Error, model: GeminiModel): boolean {
+    if (error instanceof ClientConfigError || isBillingError(error)) {
+      return false;
+    }
+    if (isAuthError(error)) {
+      this.logger.error(`Authentication error for ${model}: ${error.message}`);
+      return false;
+    }
+    if (isRateLimitError(error)) {
+      this.logger.warn(`Rate limit hit for ${model}: ${error.message}`);
+      // Only waited out on the same key when a rate-limit backoff or retry count is configured
+      return this.options.rateLimitBackoff !== undefined || this.getRetryCount(error) !== undefined;
+    }
+    return this.getRetryCount(error) !== undefined || this.isRetryable(error);
+  }
+
+  // Same-key retries configured for the error's status code in `retryCounts`, if any
+  private getRetryCount(error: Error): number | undefined {
+    const statusCode = getErrorStatusCode(error);
+    return statusCode !== undefined ? this.options.retryCounts?.[statusCode] : undefined;
+  }
+
+  // Backoff for retrying `error`: `rateLimitBackoff` for 429s, `serverErrorBackoff` for 5xx
+  // errors, with unset fields (and every other error) using retryDelay / retryJitter
+  private getBackoff(error: Error): Pick<RetryOptions, 'delay' | 'jitter'> {
+    const statusCode = getErrorStatusCode(error);
+    const profile = isRateLimitError(error)
+      ? this.options.rateLimitBackoff
+      : statusCode !== undefined && statusCode >= 500
+        ? this.options.serverErrorBackoff
+        : undefined;
+    return {
+      delay: profile?.delay ?? this.options.retryDelay,
+      jitter: profile?.jitter ?? this.options.retryJitter,
+    };
+  }
+
+  private isRetryable(error: Error): boolean {
+    return (
+      (this.options.retryPolicy ?? defaultRetryPolicy)(error) || this.matchesRetryMessage(error)
+    );
+  }
+
+  /**
+   * Whether an error without an HTTP status (so unclassified by status) mentions one of
+   * the `retryOnMessages` substrings, compared case-insensitively.
+   */
+  private matchesRetryMessage(error: Error): boolean {
+    const messages = this.options.retryOnMessages;
+    if (!messages?.length || getErrorStatusCode(error) !== undefined) {
+      return false;
+    }
+    const message = error.message.toLowerCase();
+    return messages.some((substring) => message.includes(substring.toLowerCase()));
+  }
+
+  /**
+   * Counts a key toward the request. The first key was already counted when the
+   * rotator selected it; keys reached by rotating within the request are counted here.
+   */
+  private markKeyUsed(keyIndex: number | null, usedKeys: Set<number>): void {
+    if (keyIndex === null || usedKeys.has(keyIndex)) {
+      return;
+    }
+    if (usedKeys.size > 0 && this.apiKeyRotator) {
+      this.apiKeyRotator.recordUsage(keyIndex);
+    }
+    usedKeys.add(keyIndex);
+  }
+
+  /**
+   * Records the request outcome once per key used: success for the key that
+   * produced the response, failure for every other key tried.
+   */
+  private recordKeyOutcomes(usedKeys: Set<number>, successKeyIndex: number | null): void {
+    if (!this.apiKeyRotator) {
+      return;
+    }
+    for (const keyIndex of usedKeys) {
+      if (keyIndex === successKeyIndex) {
+        this.apiKeyRotator.recordSuccess(keyIndex);
+      } else {
+        this.apiKeyRotator.recordFailure(keyIndex);
+      }
+    }
+  }
+
+  private recordSuccess(
+    model: GeminiModel,
+    fallbackDepth: number,
+    keyIndex: number | null,
+    usedKeys: Set<number>,
+    startTime: number,
+    label: string
+  ): void {
+    const responseTime = Date.now() - startTime;
+    // Record health monitoring
+    if (this.healthMonitor) {
+      this.healthMonitor.recordRequest(model, responseTime, true);
+    }
+
+    this.stats.modelUsage[model] = (this.stats.modelUsage[model] || 0) + 1;
+    this.stats.fallbackDepths[fallbackDepth] = (this.stats.fallbackDepths[fallbackDepth] || 0) + 1;
+    this.updateSuccessRate();
+    this.recordKeyOutcomes(usedKeys, keyIndex);
+    this.logger.info(`${label}: ${model} (${responseTime}ms)`);
+  }
+
+  /**
+   * Skips an attempt when less than `minAttemptTimeMs` is left before the deadline,
+   * noting it in the attempts so the final error explains why the model wasn't tried.
+   */
+  private skipForDeadline(
+    attempts: AttemptRecord[],
+    model: GeminiModel,
+    deadline: number
+  ): boolean {
+    const remaining = deadline - Date.now();
+    const minimum = this.options.minAttemptTimeMs;
+    if (!minimum || remaining >= minimum) {
+      return false;
+    }
+    const note = `Skipped: insufficient time remaining (${remaining}ms, minimum ${minimum}ms)`;
+    this.logger.warn(`${note}: ${model}`);
+    attempts.push({ model, error: note, timestamp: new Date() });
+    return true;
+  }
+
+  private recordAttemptFailure(
+    attempts: AttemptRecord[],
+    model: GeminiModel,
+    keyIndex: number | null,
+    err: Error,
+    startTime: number
+  ): number | undefined {
+    const statusCode = getErrorStatusCode(err);
+    const responseTime = Date.now() - startTime;
+
+    // Record health monitoring for failure
+    if (this.healthMonitor) {
+      this.healthMonitor.recordRequest(model, responseTime, false, err.message);
+    }
+
+    attempts.push({
+      model,
+      error: err.message,
+      timestamp: new Date(),
+      statusCode,
+      ...(keyIndex !== null && { keyIndex }),
+      durationMs: responseTime,
+    });
+
+    return statusCode;
+  }
+
+  private abortedError(attempts: AttemptRecord[], partialText?: string): AbortedError {
+    return new AbortedError(attempts, partialText);
+  }
+
+  /**
+   * Waits for one of the client's `maxConcurrency` slots, held until the request (with
+   * all its retries and fallbacks) settles. Waiting requests fail with 'ABORTED' on abort().
+   */
+  private async acquireRequestSlot(): Promise<void> {
+    try {
+      await this.requestSlots.acquire(this.abortController.signal);
+    } catch {
+      throw this.abortedError([]);
+    }
+  }
+
+  private async withRequestSlot<T>(run: () => Promise<T>): Promise<T> {
+    await this.acquireRequestSlot();
+    try {
+      return await run();
+    } finally {
+      this.requestSlots.release();
+    }
+  }
 
-          // Record health monitoring
-          if (this.healthMonitor) {
-            this.healthMonitor.recordRequest(model, responseTime, true);
+  /**
+   * Runs a stream that both its own `signal` (when given) and abort() can cancel.
+   * Either way it fails with 'ABORTED' and the text streamed so far as `partialText`.
+   */
+  private async *cancellableStream(
+    signal: AbortSignal | undefined,
+    run: (signal?: AbortSignal) => AsyncGenerator<StreamChunk>
+  ): AsyncGenerator<StreamChunk> {
+    if (!signal) {
+      yield* run();
+      return;
+    }
+    const linked = linkSignals(signal, this.abortController.signal);
+    try {
+      yield* run(linked.signal);
+    } finally {
+      linked.unlink();
+    }
+  }
+
+  // Streams hold their slot until the stream ends or the caller stops reading
+  private async *streamInRequestSlot(
+    stream: AsyncGenerator<StreamChunk>
+  ): AsyncGenerator<StreamChunk> {
+    await this.acquireRequestSlot();
+    try {
+      yield* stream;
+    } finally {
+      this.requestSlots.release();
+    }
+  }
+
+  // Takes a key whose billing is disabled out of rotation for good
+  private billingError(
+    apiKey: string,
+    keyIndex: number | null,
+    attempts: AttemptRecord[],
+    statusCode: number | undefined,
+    model: GeminiModel
+  ): BillingError {
+    const maskedKey = this.options.keyMasker(apiKey);
+    if (keyIndex !== null) {
+      this.apiKeyRotator?.disableKey(keyIndex);
+    }
+    this.markKeyInvalid(apiKey);
+    this.logger.error(`Billing is disabled for API key ${maskedKey}; no longer using it`);
+    return new BillingError(maskedKey, attempts, statusCode, model);
+  }
+
+  // Hands an entry to the `auditSink` without waiting; a sink failure is only logged
+  private recordAudit(entry: AuditEntry): void {
+    const sink = this.options.auditSink;
+    if (!sink) {
+      return;
+    }
+    const warn = (error: unknown) =>
+      this.logger.warn(`Audit sink failed to record a request: ${(error as Error).message}`);
+    try {
+      Promise.resolve(sink.record(entry)).catch(warn);
+    } catch (error) {
+      warn(error);
+    }
+  }
+
+  private notifyKeyState(apiKey: string, state: KeyState): void {
+    const maskedKey = this.options.keyMasker(apiKey);
+    this.logger[state === 'recovered' ? 'info' : 'warn'](`API key ${maskedKey} is now ${state}`);
+    if (this.options.onKeyStateChange) {
+      this.options.onKeyStateChange(maskedKey, state);
+    }
+  }
+
+  // Reports a key the API rejected (authentication or billing) once, until it succeeds again
+  private markKeyInvalid(apiKey: string): void {
+    if (!this.invalidKeys.has(apiKey)) {
+      this.invalidKeys.add(apiKey);
+      this.notifyKeyState(apiKey, 'invalid');
+    }
+  }
+
+  private markKeyValid(apiKey: string): void {
+    if (this.invalidKeys.delete(apiKey)) {
+      this.notifyKeyState(apiKey, 'recovered');
+    }
+  }
+
+  private recordUsage(usage: TokenUsage): void {
+    this.totalUsage.promptTokens += usage.promptTokens;
+    this.totalUsage.completionTokens += usage.completionTokens;
+    this.totalUsage.totalTokens += usage.totalTokens;
+    this.totalUsage.cachedTokens += usage.cachedTokens ?? 0;
+  }
+
+  // Rejects new requests once `maxTotalTokens` has been used up
+  private assertWithinBudget(): void {
+    const budget = this.options.maxTotalTokens;
+    if (budget !== undefined && this.totalUsage.totalTokens >= budget) {
+      throw new GeminiBackError(
+        `Token budget exceeded: ${this.totalUsage.totalTokens} of ${budget} tokens used.`,
+        'BUDGET_EXCEEDED'
+      );
+    }
+  }
+
+  private failRequest(
+    usedKeys: Set<number>,
+    error: GeminiBackError,
+    successKeyIndex: number | null = null
+  ): GeminiBackError {
+    this.stats.failureCount++;
+    this.updateSuccessRate();
+    this.recordKeyOutcomes(usedKeys, successKeyIndex);
+    return error;
+  }
+
+  /**
+   * Whether to skip a key that was rate limited earlier in this request, so a fallback
+   * model doesn't immediately hit it again. Each model still gets at least one attempt:
+   * a rate-limited key is used when no other key is left for a model not yet tried.
+   */
+  private skipRateLimitedKey(
+    plan: AttemptTarget[],
+    position: number,
+    rateLimitedKeys: Set<number>,
+    attemptedModels: Set<GeminiModel>
+  ): boolean {
+    const { model, keyIndex } = plan[position];
+    if (keyIndex === null || !rateLimitedKeys.has(keyIndex)) {
+      return false;
+    }
+
+    const freshKeyLater = plan
+      .slice(position + 1)
+      .some(
+        (target) =>
+          target.model === model &&
+          target.keyIndex !== null &&
+          !rateLimitedKeys.has(target.keyIndex)
+      );
+    if (!attemptedModels.has(model) && !freshKeyLater) {
+      return false;
+    }
+
+    this.logger.debug(
+      `Skipping API Key #${keyIndex + 1} on ${model}: rate limited earlier in this request`
+    );
+    return true;
+  }
+
+  private logNextAttempt(
+    plan: AttemptTarget[],
+    position: number,
+    skippedModels: Set<GeminiModel>
+  ): void {
+    const current = plan[position];
+    const next = plan.slice(position + 1).find((target) => !skippedModels.has(target.model));
+    if (!next) {
+      return;
+    }
+
+    if (next.model !== current.model) {
+      this.logger.info(`Fallback to: ${next.model}`);
+    } else if (next.keyIndex !== null) {
+      this.logger.info(`Rotating to API Key #${next.keyIndex + 1}: ${next.model}`);
+    }
+  }
+
+  async *generateStream(prompt: string, options?: GenerateOptions): AsyncGenerator<StreamChunk> {
+    this.assertPromptSize(this.getPromptByteLength(prompt, options));
+
+    const requestOptions = this.withResponseLanguage(options);
+    const stream: StreamFactory = (model, apiKey) =>
+      this.client.generateStream(
+        prompt,
+        model,
+        apiKey,
+        this.withModelConfig(requestOptions, model)
+      );
+
+    yield* this.streamInRequestSlot(
+      this.cancellableStream(options?.signal, (signal) =>
+        this.executeStreamWithFallback(
+          this.getModelsToTry(options, { prompt }),
+          options?.resumeOnError ? this.resumable(stream) : stream,
+          { keySeed: options?.keySeed, signal }
+        )
+      )
+    );
+  }
+
+  /**
+   * Wraps a stream so that a retryable error after text has been emitted restarts
+   * generation on another API key, skipping the text the caller already received.
+   * Only the same model's output can be matched against the emitted text: when the
+   * fallback chain moves on to another model, that model starts over and its first
+   * chunk is flagged `reset`.
+   */
+  private resumable(stream: StreamFactory): StreamFactory {
+    const state: ResumeState = { emitted: '', reset: false };
+    return (model, apiKey, resumeKey) =>
+      this.resumeStream(stream, model, apiKey, resumeKey, state);
+  }
+
+  private async *resumeStream(
+    stream: StreamFactory,
+    model: GeminiModel,
+    apiKey: string,
+    resumeKey: () => string,
+    state: ResumeState
+  ): AsyncGenerator<StreamPiece> {
+    if (state.emitted && state.model !== model) {
+      state.emitted = '';
+      state.reset = true;
+    }
+    state.model = model;
+    let key = apiKey;
+
+    for (let resumes = 0; ; resumes++) {
+      let produced = '';
+      try {
+        for await (const chunk of stream(model, key, resumeKey)) {
+          const previousLength = produced.length;
+          produced += chunk.text;
+
+          // Still regenerating text the caller already has
+          if (produced.length <= state.emitted.length) {
+            continue;
           }
 
-          this.stats.modelUsage[model]++;
-          this.updateSuccessRate();
-          if (keyIndex !== null && this.apiKeyRotator) {
-            this.apiKeyRotator.recordSuccess(keyIndex);
+          // Exact resumption is best-effort: regenerated text may differ from what was sent
+          if (previousLength < state.emitted.length && !produced.startsWith(state.emitted)) {
+            this.logger.warn(`Resumed stream diverged from the emitted text: ${model}`);
           }
-          this.logger.info(`Stream success: ${model} (${responseTime}ms)`);
-          return;
+
+          const fresh = produced.slice(Math.max(previousLength, state.emitted.length));
+          state.emitted += fresh;
+          const reset = state.reset;
+          state.reset = false;
+          yield { text: fresh, raw: chunk.raw, ...(reset && { reset }) };
         }
+        return;
       } catch (error) {
         const err = error as Error;
-        const statusCode = getErrorStatusCode(err);
-        const responseTime = Date.now() - startTime;
+        const canResume =
+          state.emitted.length > 0 &&
+          resumes < this.options.maxRetries &&
+          !isAuthError(err) &&
+          (isRateLimitError(err) || this.isRetryable(err));
+        if (!canResume) {
+          throw err;
+        }
+
+        key = resumeKey();
+        this.logger.warn(
+          `Stream interrupted: ${model} - ${err.message}. Resuming (${resumes + 1}/${this.options.maxRetries})`
+        );
+      }
+    }
+  }
+
+  /**
+   * Key a stream resumes on after a mid-stream error: the model's next planned key this
+   * request hasn't used, else the next unused key in rotation order. With every key used
+   * (e.g. a single key), the current key is retried.
+   */
+  private pickResumeTarget(
+    plan: AttemptTarget[],
+    position: number,
+    usedKeys: Set<number>,
+    current: AttemptTarget
+  ): AttemptTarget {
+    const planned = plan
+      .slice(position + 1)
+      .find(
+        (target) =>
+          target.model === current.model &&
+          target.keyIndex !== null &&
+          !usedKeys.has(target.keyIndex)
+      );
+    if (planned) {
+      return planned;
+    }
+
+    const rotator = this.apiKeyRotator;
+    if (!rotator || current.keyIndex === null || rotator.getPinnedIndex() !== undefined) {
+      return current;
+    }
+    const totalKeys = rotator.getTotalKeys();
+    for (let offset = 1; offset < totalKeys; offset++) {
+      const keyIndex = (current.keyIndex + offset) % totalKeys;
+      const available = !rotator.isDisabled(keyIndex) && !rotator.isCircuitOpen(keyIndex);
+      if (available && !usedKeys.has(keyIndex)) {
+        return { model: current.model, apiKey: rotator.getKeyByIndex(keyIndex)!, keyIndex };
+      }
+    }
+    return current;
+  }
+
+  /**
+   * Starts generating a batch of prompts on a bounded worker pool and returns
+   * a handle for streaming results, cancelling, or waiting for completion.
+   * Each request can be cancelled by the job or by abort(), so none is deduplicated.
+   */
+  submitBatch(requests: Array<string | BatchRequest>, options?: BatchOptions): BatchJob {
+    const normalized = requests.map((request) =>
+      typeof request === 'string' ? { prompt: request } : request
+    );
+    const concurrency = options?.concurrency ?? DEFAULT_BATCH_CONCURRENCY;
+
+    this.logger.debug(
+      `Submitting batch: ${normalized.length} requests (concurrency ${concurrency})`
+    );
+    return new BatchJob(
+      normalized,
+      (request, signal) =>
+        withLinkedSignal(signal, this.abortController.signal, (linked) =>
+          this.generateCancellable(request.prompt, request.options, linked)
+        ),
+      concurrency
+    );
+  }
 
-        // Record health monitoring for failure
-        if (this.healthMonitor) {
-          this.healthMonitor.recordRequest(model, responseTime, false, err.message);
+  /**
+   * Streams several prompts at once (at most `concurrency` at a time) and interleaves
+   * their chunks as they arrive, each tagged with the index of its request.
+   * Ends once every stream has finished; a failed stream yields one `error` entry.
+   */
+  async *generateBatchStream(
+    requests: Array<string | BatchRequest>,
+    options?: BatchOptions
+  ): AsyncGenerator<TaggedStreamChunk> {
+    const normalized = requests.map((request) =>
+      typeof request === 'string' ? { prompt: request } : request
+    );
+    const concurrency = Math.max(1, options?.concurrency ?? DEFAULT_BATCH_CONCURRENCY);
+
+    const pending: TaggedStreamChunk[] = [];
+    let wake: (() => void) | undefined;
+    let next = 0;
+    let done = false;
+    let stopped = false;
+
+    const push = (entry: TaggedStreamChunk): void => {
+      pending.push(entry);
+      wake?.();
+    };
+
+    const worker = async (): Promise<void> => {
+      while (!stopped && next < normalized.length) {
+        const index = next++;
+        const request = normalized[index];
+        try {
+          for await (const chunk of this.generateStream(request.prompt, request.options)) {
+            if (stopped) {
+              break;
+            }
+            push({ index, chunk });
+          }
+        } catch (error) {
+          push({ index, error: error as Error });
         }
+      }
+    };
 
-        attempts.push({
-          model,
-          error: err.message,
-          timestamp: new Date(),
-          statusCode,
+    const workerCount = Math.min(concurrency, normalized.length);
+    const workers = Promise.all(Array.from({ length: workerCount }, () => worker())).then(() => {
+      done = true;
+      wake?.();
+    });
+
+    try {
+      while (true) {
+        if (pending.length > 0) {
+          yield pending.shift()!;
+          continue;
+        }
+        if (done) {
+          break;
+        }
+        await new Promise<void>((resolve) => {
+          wake = resolve;
         });
+        wake = undefined;
+      }
+      await workers;
+    } finally {
+      // The consumer may stop early; don't start streams nobody will read
+      stopped = true;
+    }
+  }
 
-        this.logger.warn(`Stream failed (${statusCode || 'unknown'}): ${model}`);
+  async chat(messages: ChatMessage[], options?: GenerateOptions): Promise<GeminiResponse> {
+    const conversationPrompt = messages
+      .map((msg) => `${msg.role === 'user' ? 'User' : 'Assistant'}: ${msg.content}`)
+      .join('\n\n');
 
-        if (isAuthError(err)) {
-          this.stats.failureCount++;
-          this.updateSuccessRate();
-          if (keyIndex !== null && this.apiKeyRotator) {
-            this.apiKeyRotator.recordFailure(keyIndex);
-          }
+    const finalPrompt = `${conversationPrompt}\n\nAssistant:`;
+
+    return this.generate(finalPrompt, options);
+  }
+
+  /**
+   * Generates from OpenAI-style messages, for code migrating off the OpenAI SDK: `system`
+   * messages become the system instruction (taking precedence over the request's own),
+   * `assistant` turns are sent as the model's. Fails with 'INVALID_REQUEST' before any API
+   * call when there is no user or assistant message.
+   */
+  async generateFromMessages(
+    messages: ChatMessage[],
+    options: Omit<GenerateContentRequest, 'contents'> = {}
+  ): Promise<GeminiResponse> {
+    const { contents, systemInstruction } = fromOpenAIMessages(messages);
+    if (contents.length === 0) {
+      throw new GeminiBackError(
+        messages.length
+          ? 'Only system messages were given; add a user message to generate a reply.'
+          : 'No messages were given.',
+        'INVALID_REQUEST'
+      );
+    }
+    return this.generateContent({
+      ...options,
+      contents,
+      systemInstruction: systemInstruction ?? options.systemInstruction,
+    });
+  }
+
+  registerTemplate(name: string, template: string): void {
+    this.templates.set(name, template);
+  }
+
+  /**
+   * Renders a registered template with `data` and generates from the result.
+   * Unknown templates and missing variables fail with 'TEMPLATE_ERROR' before any API call.
+   */
+  async generateFromTemplate(
+    name: string,
+    data: Record<string, unknown>,
+    options?: GenerateOptions
+  ): Promise<GeminiResponse> {
+    return this.generate(this.renderPrompt(name, data), options);
+  }
+
+  /**
+   * Generates in JSON mode and returns the parsed value. With `repairJson`, malformed
+   * output is repaired before parsing and, if still invalid, generated once more.
+   * Fails with 'INVALID_JSON' when no valid JSON is produced, or with 'TRUNCATED_JSON'
+   * when the output was cut off by `maxTokens` (finish reason MAX_TOKENS). With
+   * `validateSchema`, JSON that doesn't match `responseSchema` is regenerated up to
+   * `maxRetries` times before failing with SchemaValidationError.
+   */
+  async generateJSON<T = unknown>(prompt: string, options: GenerateJSONOptions = {}): Promise<T> {
+    const { repairJson, validateSchema, ...generateOptions } = options;
+    const request = { ...generateOptions, responseMimeType: 'application/json' };
+    const schema = validateSchema ? request.responseSchema : undefined;
+
+    for (let retry = 0; ; retry++) {
+      const { value, model } = await this.generateParsedJSON(prompt, request, repairJson);
+      const violations = schema ? validateAgainstSchema(value, schema) : [];
+      if (violations.length === 0) {
+        return value as T;
+      }
+      if (retry >= this.options.maxRetries) {
+        throw new SchemaValidationError(violations, model);
+      }
+      this.logger.warn(
+        `JSON from ${model} does not match the schema (retry ${retry + 1}/${this.options.maxRetries}): ${violations.join('; ')}`
+      );
+    }
+  }
+
+  private async generateParsedJSON(
+    prompt: string,
+    request: GenerateOptions,
+    repairJson?: boolean
+  ): Promise<{ value: unknown; model: GeminiModel }> {
+    const maxAttempts = repairJson ? 2 : 1;
+
+    let lastError: Error | undefined;
+    for (let attempt = 1; attempt <= maxAttempts; attempt++) {
+      const response = await this.generate(prompt, request);
+      try {
+        const value = repairJson ? parseJsonWithRepair(response.text) : JSON.parse(response.text);
+        return { value, model: response.model };
+      } catch (error) {
+        lastError = error as Error;
+        // Generating again would hit the same limit, so fail with a specific code instead
+        if (response.finishReason === 'MAX_TOKENS') {
           throw new GeminiBackError(
-            'Authentication failed. Please check your API key.',
-            'AUTH_ERROR',
-            attempts,
-            statusCode,
-            model
+            `Response JSON was truncated at the output token limit; raise maxTokens: ${lastError.message}`,
+            'TRUNCATED_JSON',
+            [],
+            undefined,
+            response.model
           );
         }
-
-        if (modelsToTry.indexOf(model) < modelsToTry.length - 1) {
-          this.logger.info(`Fallback to: ${modelsToTry[modelsToTry.indexOf(model) + 1]}`);
-        }
+        this.logger.warn(`Invalid JSON from ${response.model} (attempt ${attempt}/${maxAttempts})`);
       }
     }
 
-    this.stats.failureCount++;
-    this.updateSuccessRate();
-    if (keyIndex !== null && this.apiKeyRotator) {
-      this.apiKeyRotator.recordFailure(keyIndex);
+    throw new GeminiBackError(`Response is not valid JSON: ${lastError!.message}`, 'INVALID_JSON');
+  }
+
+  private renderPrompt(name: string, data: Record<string, unknown>): string {
+    const template = this.templates.get(name);
+    if (template === undefined) {
+      throw new GeminiBackError(`Unknown template: ${name}`, 'TEMPLATE_ERROR');
     }
-    throw new GeminiBackError(
-      'All models failed for streaming. Please try again later.',
-      'ALL_MODELS_FAILED',
-      attempts
+
+    try {
+      return renderTemplate(template, data);
+    } catch (error) {
+      throw new GeminiBackError(
+        `Failed to render template "${name}": ${(error as Error).message}`,
+        'TEMPLATE_ERROR'
+      );
+    }
+  }
+
+  async generateContent(request: GenerateContentRequest): Promise<GeminiResponse> {
+    this.assertPromptSize(getContentsByteLength(request.contents));
+
+    if (request.idempotencyKey) {
+      return this.idempotentRequests
+        .run(request.idempotencyKey, () => this.generateContentWithFallback(request))
+        .then(copyPlainData);
+    }
+    const fingerprint = this.options.dedupWindow
+      ? fingerprintRequest('generateContent', request)
+      : undefined;
+    if (fingerprint) {
+      return this.recentRequests
+        .run(fingerprint, () => this.generateContentWithFallback(request))
+        .then(copyPlainData);
+    }
+    return this.generateContentWithFallback(request);
+  }
+
+  private async generateContentWithFallback(
+    request: GenerateContentRequest
+  ): Promise<GeminiResponse> {
+    const options = {
+      timeout: request.timeout,
+      temperature: request.temperature,
+      maxTokens: request.maxTokens,
+      topP: request.topP,
+      topK: request.topK,
+      seed: request.seed,
+      candidateCount: request.candidateCount,
+      responseLogprobs: request.responseLogprobs,
+      logprobs: request.logprobs,
+      mediaResolution: request.mediaResolution,
+      audioTimestamp: request.audioTimestamp,
+      includeThoughts: request.includeThoughts,
+      systemInstruction: composeSystemInstruction(
+        request.systemInstruction,
+        request.responseLanguage
+      ),
+      tools: request.tools,
+      toolConfig: request.toolConfig,
+      safetySettings: request.safetySettings,
+      responseMimeType: request.responseMimeType,
+      responseSchema: request.responseSchema,
+      generationConfig: request.generationConfig,
+      labels: request.labels,
+    };
+
+    const response = await this.runAudited(request.contents, () =>
+      this.withRequestSlot(() =>
+        this.executeWithFallback(
+          this.getModelsToTry(request, { contents: request.contents }),
+          (model, apiKey) =>
+            this.client
+              .generateContent(
+                request.contents,
+                model,
+                apiKey,
+                this.withModelConfig(options, model, request.deadline)
+              )
+              .then((result) => this.withUsageEstimate(result, request.contents)),
+          { kind: 'multimodal', deadline: request.deadline, keySeed: request.keySeed }
+        )
+      ).catch(async (error: unknown) => ({ response: await this.runFinalFallback(request, error) }))
+    );
+    return this.withRequestInfo(
+      await this.spillOutput(this.cleanText(response, request), request.spillOutput),
+      request.contents,
+      request
+    );
+  }
+
+  /**
+   * Hands a request to `finalFallback` once the whole Gemini chain is exhausted (every
+   * key and model failed). Failures that end the chain early, such as an auth error or
+   * an abort, are rethrown as-is. If the final fallback fails too, the Gemini error is
+   * rethrown with its message appended, keeping the Gemini attempts.
+   */
+  private async runFinalFallback(
+    request: GenerateContentRequest,
+    error: unknown
+  ): Promise<GeminiResponse> {
+    const { finalFallback } = this.options;
+    const exhausted =
+      error instanceof GeminiBackError &&
+      (error.code === 'ALL_MODELS_FAILED' || error.code === 'ALL_KEYS_EXHAUSTED');
+    if (!finalFallback || !exhausted) {
+      throw error;
+    }
+
+    this.logger.warn(`Every Gemini attempt failed; using finalFallback: ${error.message}`);
+    try {
+      return await finalFallback(request);
+    } catch (fallbackError) {
+      throw new GeminiBackError(
+        `${error.message} Final fallback failed: ${(fallbackError as Error).message}`,
+        error.code,
+        error.allAttempts,
+        error.statusCode,
+        error.modelAttempted
+      );
+    }
+  }
+
+  /**
+   * Applies the model's `modelConfigs` entry: its generation settings and timeout fill
+   * in whatever the request leaves unset, then `defaultGenerationConfig` fills in whatever
+   * both leave unset. A setting counts as set by the request when it is given either
+   * directly or in the request's `generationConfig`. With a deadline, the timeout is capped
+   * at the time left so an attempt can't outlast it.
+   */
+  private withModelConfig<T extends ModelConfig & { generationConfig?: GenerationConfig }>(
+    options: T | undefined,
+    model: GeminiModel,
+    deadline?: number
+  ): T | undefined {
+    const { timeout: modelTimeout, ...modelGeneration } = this.options.modelConfigs?.[model] ?? {};
+    const generation = { ...this.options.defaultGenerationConfig, ...modelGeneration };
+    const defaults = Object.entries(generation).filter(([key, value]) => {
+      const field = key as keyof DefaultGenerationConfig;
+      return (
+        value !== undefined &&
+        options?.[field] === undefined &&
+        options?.generationConfig?.[GENERATION_CONFIG_FIELDS[field]] === undefined
+      );
+    });
+    const merged = defaults.length
+      ? ({ ...options, ...Object.fromEntries(defaults) } as T)
+      : options;
+
+    if (deadline !== undefined) {
+      const timeout = merged?.timeout ?? modelTimeout ?? this.options.timeout;
+      const remaining = Math.max(deadline - Date.now(), 0);
+      return { ...merged, timeout: Math.min(timeout, remaining) } as T;
+    }
+    if (modelTimeout === undefined || merged?.timeout !== undefined) {
+      return merged;
+    }
+    return { ...merged, timeout: modelTimeout } as T;
+  }
+
+  async *generateContentStream(request: GenerateContentRequest): AsyncGenerator<StreamChunk> {
+    this.assertPromptSize(getContentsByteLength(request.contents));
+
+    const options: Omit<GenerateContentRequest, 'contents' | 'model'> = {
+      temperature: request.temperature,
+      maxTokens: request.maxTokens,
+      topP: request.topP,
+      topK: request.topK,
+      seed: request.seed,
+      mediaResolution: request.mediaResolution,
+      audioTimestamp: request.audioTimestamp,
+      includeThoughts: request.includeThoughts,
+      systemInstruction: composeSystemInstruction(
+        request.systemInstruction,
+        request.responseLanguage
+      ),
+      tools: request.tools,
+      toolConfig: request.toolConfig,
+      safetySettings: request.safetySettings,
+      generationConfig: request.generationConfig,
+      labels: request.labels,
+    };
+    const stream: StreamFactory = (model, apiKey) =>
+      this.client.generateContentStream(
+        request.contents,
+        model,
+        apiKey,
+        this.withModelConfig(options, model)
+      );
+
+    yield* this.streamInRequestSlot(
+      this.cancellableStream(request.signal, (signal) =>
+        this.executeStreamWithFallback(
+          this.getModelsToTry(request, { contents: request.contents }),
+          request.resumeOnError ? this.resumable(stream) : stream,
+          { kind: 'multimodal', keySeed: request.keySeed, signal }
+        )
+      )
+    );
+  }
+
+  /**
+   * Extends the lifetime of a context cache to `ttlSeconds` from now.
+   * Caches belong to the project of the key that created them, so with multiple
+   * keys every key should belong to the same project.
+   */
+  async refreshCache(name: string, ttlSeconds: number): Promise<CachedContent> {
+    return this.withApiKey((apiKey) => this.client.refreshCache(name, ttlSeconds, apiKey));
+  }
+
+  async deleteCache(name: string): Promise<void> {
+    return this.withApiKey((apiKey) => this.client.deleteCache(name, apiKey));
+  }
+
+  async listCaches(): Promise<CachedContent[]> {
+    return this.withApiKey((apiKey) => this.client.listCaches(apiKey));
+  }
+
+  /**
+   * Reads a local file into a part for `parts` / `contents`. Files whose base64 encoding
+   * fits in `inlineLimitBytes` are inlined; larger ones are uploaded via the File API
+   * and referenced by URI. The MIME type is detected from the extension or file header.
+   */
+  async attachFile(path: string, options: AttachFileOptions = {}): Promise<Part> {
+    const limit = options.inlineLimitBytes ?? DEFAULT_INLINE_LIMIT_BYTES;
+    const { size } = await stat(path);
+
+    if (base64Length(size) <= limit) {
+      const data = await readFile(path);
+      const mimeType = options.mimeType ?? detectMimeType(path, data);
+      return { inlineData: { mimeType, data: data.toString('base64') } };
+    }
+
+    const mimeType = options.mimeType ?? detectMimeType(path, await readHeader(path));
+    this.logger.debug(`Uploading ${path} (${size} bytes) via the File API`);
+    const fileData = await this.withApiKey((apiKey) =>
+      this.client.uploadFile(path, mimeType, apiKey)
     );
+    return { fileData };
+  }
+
+  // Runs a single non-generation call on the next rotated key, surfacing SDK errors as-is
+  private async withApiKey<T>(call: (apiKey: string) => Promise<T>): Promise<T> {
+    const { key, index } = this.getApiKey();
+    try {
+      const result = await call(key);
+      if (index !== null && this.apiKeyRotator) {
+        this.apiKeyRotator.recordSuccess(index);
+      }
+      return result;
+    } catch (error) {
+      if (index !== null && this.apiKeyRotator) {
+        this.apiKeyRotator.recordFailure(index);
+      }
+      throw error;
+    }
+  }
+
+  /**
+   * Returns a copy of the effective configuration (defaults applied) for debugging and
+   * admin endpoints. API keys are masked so the result is safe to expose.
+   */
+  getConfig(): GemBackOptions {
+    const { apiKey, apiKeys, ...options } = this.options;
+    return {
+      ...options,
+      apiKey: apiKey ? options.keyMasker(apiKey) : undefined,
+      apiKeys: apiKeys?.map((key) => options.keyMasker(key)),
+      fallbackOrder: [...options.fallbackOrder],
+    };
+  }
+
+  /**
+   * Returns the models tried by default, in fallback order, with aliases resolved.
+   */
+  getFallbackOrder(): GeminiModel[] {
+    return this.getModelsToTry();
+  }
+
+  /**
+   * Replaces the default fallback order, e.g. to drop a model during an outage.
+   * Only requests started afterwards are affected; in-flight requests keep the
+   * order they started with.
+   */
+  setFallbackOrder(fallbackOrder: ModelName[]): void {
+    if (fallbackOrder.length === 0) {
+      throw new Error('fallbackOrder must contain at least one model');
+    }
+    if (fallbackOrder.some((model) => typeof model !== 'string' || model.trim() === '')) {
+      throw new Error('fallbackOrder must only contain non-empty model names');
+    }
+
+    this.options.fallbackOrder = [...fallbackOrder];
+    this.logger.info(`Fallback order updated: ${this.getModelsToTry().join(' → ')}`);
+  }
+
+  /**
+   * Sends every request to the key at `keyIndex` (0-based, in `apiKeys` order) until
+   * `unpinKey()`, e.g. to route all traffic to a known-good key during an incident.
+   * Retries still apply, on that key; rotation, quota and circuit state are ignored.
+   * Only requests started afterwards are affected.
+   */
+  pinKey(keyIndex: number): void {
+    const keys = this.getConfiguredKeys();
+    if (!Number.isInteger(keyIndex) || keyIndex < 0 || keyIndex >= keys.length) {
+      throw new Error(`No API key at index ${keyIndex}; ${keys.length} key(s) configured`);
+    }
+
+    this.apiKeyRotator?.pin(keyIndex);
+    this.logger.warn(`All requests pinned to API key ${this.options.keyMasker(keys[keyIndex])}`);
+  }
+
+  // Restores normal key rotation after `pinKey()`
+  unpinKey(): void {
+    this.apiKeyRotator?.unpin();
+    this.logger.info('API key pin removed; rotation restored');
+  }
+
+  /**
+   * Tokens each API key has left of its `keyQuota` in the current window, keyed by masked
+   * key. Uses the same counters as quota-based rotation; keys without a quota are left out,
+   * and the result is empty without `keyQuota` or with a single key.
+   */
+  getQuotaHeadroom(): Record<string, number> {
+    const headroom: Record<string, number> = {};
+    const rotator = this.apiKeyRotator;
+    rotator?.getQuotaHeadroom().forEach((remaining, keyIndex) => {
+      if (remaining !== undefined) {
+        headroom[this.options.keyMasker(rotator.getKeyByIndex(keyIndex)!)] = remaining;
+      }
+    });
+    return headroom;
+  }
+
+  /**
+   * Tokens used by all successful non-streaming requests (including batches) since
+   * the client was created. Streams don't report usage and aren't counted.
+   */
+  getTotalUsage(): Required<Omit<TokenUsage, 'estimated'>> {
+    return { ...this.totalUsage };
   }
 
   getFallbackStats(): FallbackStats {
     const stats: FallbackStats = {
       ...this.stats,
+      fallbackDepths: { ...this.stats.fallbackDepths },
       apiKeyStats: this.apiKeyRotator ? this.apiKeyRotator.getStats() : undefined,
     };
 
@@ -1,8 +1,25 @@
 import { GoogleGenAI, FunctionCallingConfigMode } from '@google/genai';
+import type {
+  GenerateContentConfig,
+  GenerateContentParameters,
+  GenerateContentResponse,
+  GenerateContentResponseUsageMetadata,
+  MediaResolution as SDKMediaResolution,
+} from '@google/genai';
 import { isAuthError } from '../utils/error-handler';
+import { Logger } from '../utils/logger';
+import { ClientConfigError, EmptyResponseError } from '../types/errors';
 import type { GeminiModel } from '../types/models';
-import type { GenerateOptions, GenerateContentRequest, Content } from '../types/config';
-import type { GeminiResponse } from '../types/response';
+import type {
+  GenerateOptions,
+  GenerateContentRequest,
+  Content,
+  FileData,
+  Part,
+  TextPartSelector,
+  ResponsePart,
+} from '../types/config';
+import type { GeminiResponse, CachedContent, TokenUsage } from '../types/response';
 
 // Type guard for parts with function calls
 interface PartWithFunctionCall {
@@ -22,21 +39,190 @@ function hasFunctionCall(part: unknown): part is PartWithFunctionCall {
   );
 }
 
+// Part fields the client knows about; a part with none of them is of a newer, unknown kind
+const KNOWN_PART_FIELDS = new Set([
+  'text',
+  'thought',
+  'thoughtSignature',
+  'functionCall',
+  'functionResponse',
+  'inlineData',
+  'fileData',
+  'executableCode',
+  'codeExecutionResult',
+  'videoMetadata',
+]);
+
+// A part's text, also accepting string-like wrappers (`new String(...)`) in place of a string
+function getPartText(part: ResponsePart): string | undefined {
+  const text: unknown = part.text;
+  if (typeof text === 'string') {
+    return text;
+  }
+  return text instanceof String ? text.valueOf() : undefined;
+}
+
+// Text of the thought parts, which `getTextParts` leaves out
+function getThoughts(parts: ResponsePart[]): string {
+  return parts
+    .filter((part) => part.thought)
+    .map((part) => getPartText(part) ?? '')
+    .join('');
+}
+
+// Text of the non-thought text parts, matching what `GenerateContentResponse.text` joins.
+// Parts of an unknown kind are skipped with a debug log instead of being dropped silently.
+function getTextParts(parts: ResponsePart[], logger: Logger): string[] {
+  return parts.flatMap((part) => {
+    if (part.thought) {
+      return [];
+    }
+    const text = getPartText(part);
+    if (text !== undefined) {
+      return [text];
+    }
+    const fields = Object.keys(part);
+    if (!fields.some((field) => KNOWN_PART_FIELDS.has(field))) {
+      logger.debug(`Skipping response part of unknown type (${fields.join(', ') || 'empty'})`);
+    }
+    return [];
+  });
+}
+
+/**
+ * Maps usage metadata to TokenUsage. Some responses report the total but leave the prompt
+ * or completion count at zero; the missing one is then derived by subtraction (thinking
+ * and tool-use prompt tokens are also part of the total) and `estimated` is set.
+ * Metadata without any token count means no usage was reported, not zero tokens.
+ */
+function toTokenUsage(metadata?: GenerateContentResponseUsageMetadata): TokenUsage | undefined {
+  if (
+    metadata?.totalTokenCount === undefined &&
+    metadata?.promptTokenCount === undefined &&
+    metadata?.candidatesTokenCount === undefined
+  ) {
+    return undefined;
+  }
+  let promptTokens = metadata.promptTokenCount || 0;
+  let completionTokens = metadata.candidatesTokenCount || 0;
+  const totalTokens = metadata.totalTokenCount || 0;
+  const unaccounted =
+    totalTokens -
+    promptTokens -
+    completionTokens -
+    (metadata.thoughtsTokenCount || 0) -
+    (metadata.toolUsePromptTokenCount || 0);
+
+  // With both counts missing there is nothing to subtract from, so leave them as reported
+  const estimated = unaccounted > 0 && (promptTokens === 0) !== (completionTokens === 0);
+  if (estimated) {
+    if (promptTokens === 0) {
+      promptTokens = unaccounted;
+    } else {
+      completionTokens = unaccounted;
+    }
+  }
+
+  return {
+    promptTokens,
+    completionTokens,
+    totalTokens,
+    cachedTokens: metadata.cachedContentTokenCount,
+    ...(estimated && { estimated }),
+  };
+}
+
+// Settings some models reject; the first key is the one that marks the setting as in use
+const OPTIONAL_SETTINGS = [
+  { name: 'logprobs', keys: ['responseLogprobs', 'logprobs'], pattern: /logprobs/i },
+  { name: 'media resolution', keys: ['mediaResolution'], pattern: /media_?resolution/i },
+  { name: 'audio timestamps', keys: ['audioTimestamp'], pattern: /audio_?timestamp/i },
+  { name: 'labels', keys: ['labels'], pattern: /labels/i },
+];
+
+/**
+ * Removes the optional setting that an error says the model rejects and returns its
+ * name, or undefined if the error is about something else. Thoughts are only dropped
+ * when requested via `includeThoughts`, and only that flag: the rest of a caller's own
+ * `thinkingConfig` (e.g. `thinkingBudget`) is kept, so its errors still surface.
+ */
+function removeUnsupportedSetting(
+  config: GenerateContentConfig,
+  message: string,
+  includeThoughts?: boolean
+): string | undefined {
+  const settings = config as Record<string, unknown>;
+  const unsupported = OPTIONAL_SETTINGS.find(
+    ({ keys, pattern }) => settings[keys[0]] && pattern.test(message)
+  );
+  if (unsupported) {
+    for (const key of unsupported.keys) {
+      delete settings[key];
+    }
+    return unsupported.name;
+  }
+
+  const { thinkingConfig } = config;
+  if (includeThoughts && thinkingConfig?.includeThoughts && /thinking|thoughts/i.test(message)) {
+    const { includeThoughts: _includeThoughts, ...rest } = thinkingConfig;
+    if (Object.keys(rest).length > 0) {
+      config.thinkingConfig = rest;
+    } else {
+      delete config.thinkingConfig;
+    }
+    return 'thoughts';
+  }
+  return undefined;
+}
+
+export interface GeminiClientOptions {
+  textPartSelector?: TextPartSelector;
+  apiVersion?: string; // e.g. 'v1' or 'v1beta'; defaults to the SDK's choice
+  forwardLabels?: boolean; // Send request `labels` as API labels (billing attribution)
+  logger?: Logger; // Defaults to a logger that prints warnings and errors
+}
+
 export class GeminiClient {
   private timeout: number;
+  private textPartSelector?: TextPartSelector;
+  private apiVersion?: string;
+  private forwardLabels: boolean;
+  private logger: Logger;
   private clientCache: Map<string, GoogleGenAI> = new Map();
 
-  constructor(timeout = 30000) {
+  constructor(timeout = 30000, options: GeminiClientOptions = {}) {
     this.timeout = timeout;
+    this.textPartSelector = options.textPartSelector;
+    this.apiVersion = options.apiVersion;
+    this.forwardLabels = options.forwardLabels ?? false;
+    this.logger = options.logger ?? new Logger('warn');
   }
 
   private getClient(apiKey: string): GoogleGenAI {
     if (!this.clientCache.has(apiKey)) {
-      this.clientCache.set(apiKey, new GoogleGenAI({ apiKey }));
+      this.clientCache.set(apiKey, this.createClient(apiKey));
     }
     return this.clientCache.get(apiKey)!;
   }
 
+  /**
+   * Constructs the SDK client. Only the key differs between keys, so a construction
+   * error that doesn't concern the key (e.g. an invalid option) would fail for every key
+   * and is raised as a ClientConfigError instead.
+   */
+  private createClient(apiKey: string): GoogleGenAI {
+    const apiVersion = this.apiVersion ? { apiVersion: this.apiVersion } : {};
+    try {
+      return new GoogleGenAI({ apiKey, ...apiVersion });
+    } catch (error) {
+      const message = (error as Error).message ?? String(error);
+      if (/api[\s_-]?key/i.test(message)) {
+        throw error;
+      }
+      throw new ClientConfigError(`Could not create the Gemini client: ${message}`);
+    }
+  }
+
   private normalizeSystemInstruction(systemInstruction?: string | Content): Content | undefined {
     if (!systemInstruction) {
       return undefined;
@@ -47,36 +233,23 @@ export class GeminiClient {
     return systemInstruction;
   }
 
-  clearCache(): void {
-    this.clientCache.clear();
-  }
-
-  async validateApiKey(apiKey: string): Promise<boolean> {
-    const ai = this.getClient(apiKey);
-    try {
-      // Use list() as a lightweight check.
-      // Even if we don't list all models, a successful auth check is enough.
-      // We limit to 1 to keep it light if possible, though list() usually pages.
-      await ai.models.list({ config: { pageSize: 1 } });
-      return true;
-    } catch (error) {
-      // If it's an auth error, return false.
-      if (isAuthError(error as Error)) {
-        return false;
-      }
-      // For other errors (e.g. network), we rethrow so the user knows something else is wrong
-      throw error;
-    }
+  // The prompt text before (default) or after the pre-built parts, which keep their order;
+  // an empty prompt adds no text part
+  private buildPromptContents(prompt: string, options?: GenerateOptions): Content[] {
+    const promptParts: Part[] = prompt ? [{ text: prompt }] : [];
+    const extraParts = options?.parts ?? [];
+    const parts =
+      options?.promptPosition === 'after'
+        ? [...extraParts, ...promptParts]
+        : [...promptParts, ...extraParts];
+    return [{ role: 'user', parts }];
   }
 
-  async generate(
-    prompt: string,
-    modelName: GeminiModel,
-    apiKey: string,
-    options?: GenerateOptions
-  ): Promise<GeminiResponse> {
-    const ai = this.getClient(apiKey);
-
+  /**
+   * Builds the SDK generation config. A passthrough `generationConfig` is applied first,
+   * then any individually set fields (temperature, maxTokens, ...) override its values.
+   */
+  private buildConfig(options?: Omit<GenerateOptions, 'model'>): GenerateContentConfig {
     const systemInstruction = this.normalizeSystemInstruction(options?.systemInstruction);
     const tools = options?.tools ? [{ functionDeclarations: options.tools }] : undefined;
     const toolConfig = options?.toolConfig
@@ -92,31 +265,81 @@ export class GeminiClient {
         }
       : undefined;
 
-    const config = {
+    const fields: GenerateContentConfig = {
       temperature: options?.temperature,
       maxOutputTokens: options?.maxTokens,
       topP: options?.topP,
       topK: options?.topK,
+      seed: options?.seed,
+      candidateCount: options?.candidateCount,
+      responseLogprobs: options?.responseLogprobs,
+      logprobs: options?.logprobs,
+      mediaResolution: options?.mediaResolution
+        ? (`MEDIA_RESOLUTION_${options.mediaResolution.toUpperCase()}` as SDKMediaResolution)
+        : undefined,
+      audioTimestamp: options?.audioTimestamp,
       systemInstruction,
       tools,
       toolConfig,
       safetySettings: options?.safetySettings,
       responseMimeType: options?.responseMimeType,
       responseSchema: options?.responseSchema,
+      labels: this.forwardLabels ? options?.labels : undefined,
+      thinkingConfig: options?.includeThoughts
+        ? { ...options.generationConfig?.thinkingConfig, includeThoughts: true }
+        : undefined,
     };
 
-    const timeoutPromise = new Promise<never>((_, reject) => {
-      setTimeout(() => reject(new Error('Request timeout')), this.timeout);
-    });
+    const config: GenerateContentConfig = { ...options?.generationConfig };
+    for (const [key, value] of Object.entries(fields)) {
+      if (value !== undefined) {
+        (config as Record<string, unknown>)[key] = value;
+      }
+    }
+    return config;
+  }
 
-    const generatePromise = ai.models.generateContent({
-      model: modelName,
-      contents: [{ role: 'user', parts: [{ text: prompt }] }],
-      config,
-    });
+  // Builds a candidate's text the same way `text` is built for the first candidate
+  private candidateText(parts: ResponsePart[]): string {
+    if (this.textPartSelector) {
+      return this.textPartSelector(parts);
+    }
+    return getTextParts(parts, this.logger).join('');
+  }
 
-    const result = await Promise.race([generatePromise, timeoutPromise]);
-    const text = result.text ?? '';
+  // A stream chunk's text, from its parts like a full response's `text`
+  private chunkText(chunk: GenerateContentResponse | null | undefined): string {
+    const parts = chunk?.candidates?.[0]?.content?.parts;
+    return parts ? getTextParts(parts, this.logger).join('') : (chunk?.text ?? '');
+  }
+
+  private toGeminiResponse(
+    result: GenerateContentResponse | null | undefined,
+    modelName: GeminiModel,
+    options?: Omit<GenerateOptions, 'model'>
+  ): GeminiResponse {
+    // Some proxies resolve with no body instead of failing; surface it as a retryable error
+    if (!result) {
+      throw new EmptyResponseError();
+    }
+
+    // Text is built from the first candidate's parts, like `content.textParts`. Bodies
+    // without parts (some proxies only fill `text`) keep the SDK's `text`.
+    const parts = result.candidates?.[0]?.content?.parts;
+    const textParts = parts ? getTextParts(parts, this.logger) : undefined;
+    const text = this.textPartSelector
+      ? this.textPartSelector(parts ?? [])
+      : (textParts?.join('') ?? result.text ?? '');
+
+    // Alternatives from candidateCount > 1; usage only reports their combined token count
+    const candidates =
+      result.candidates && result.candidates.length > 1
+        ? result.candidates.map((candidate, index) => ({
+            text: index === 0 ? text : this.candidateText(candidate.content?.parts ?? []),
+            finishReason: candidate.finishReason,
+            tokenCount: candidate.tokenCount,
+          }))
+        : undefined;
 
     // Parse JSON if response is JSON
     let json: unknown = undefined;
@@ -125,7 +348,7 @@ export class GeminiClient {
         json = JSON.parse(text);
       } catch (error) {
         // If JSON parsing fails, leave json undefined and keep the text
-        console.warn('Failed to parse JSON response:', error);
+        this.logger.warn('Failed to parse JSON response:', error);
       }
     }
 
@@ -136,69 +359,173 @@ export class GeminiClient {
         name: part.functionCall.name,
         args: part.functionCall.args || {},
       }));
+    const hasFunctionCalls = Boolean(functionCalls?.length);
+
+    // A tool-call-only candidate has no text; flag it so it isn't mistaken for an empty reply
+    const isToolCall = hasFunctionCalls && !text ? true : undefined;
+
+    const citations = result.candidates?.[0]?.citationMetadata?.citations;
+
+    const thoughts = parts ? getThoughts(parts) : '';
+    const content = parts?.length
+      ? {
+          textParts: textParts ?? [],
+          functionCalls: functionCalls ?? [],
+          blobs: parts.flatMap((part) => (part.inlineData ? [part.inlineData] : [])),
+        }
+      : undefined;
 
     return {
       text,
+      ...(thoughts && { thoughts }),
       model: modelName,
       finishReason: result.candidates?.[0]?.finishReason,
-      functionCalls: functionCalls?.length ? functionCalls : undefined,
+      functionCalls: hasFunctionCalls ? functionCalls : undefined,
+      isToolCall,
       json,
-      usage: result.usageMetadata
-        ? {
-            promptTokens: result.usageMetadata.promptTokenCount || 0,
-            completionTokens: result.usageMetadata.candidatesTokenCount || 0,
-            totalTokens: result.usageMetadata.totalTokenCount || 0,
-          }
-        : undefined,
+      usage: toTokenUsage(result.usageMetadata),
+      candidates,
+      content,
+      promptFeedback: result.promptFeedback,
+      safetyRatings: result.candidates?.[0]?.safetyRatings,
+      citations: citations?.length ? citations : undefined,
+      logprobs: result.candidates?.[0]?.logprobsResult,
+      avgLogprobs: result.candidates?.[0]?.avgLogprobs,
     };
   }
 
-  async *generateStream(
+  clearCache(): void {
+    this.clientCache.clear();
+  }
+
+  // Creates and caches the SDK client for a key ahead of its first request
+  warmup(apiKey: string): void {
+    this.getClient(apiKey);
+  }
+
+  async validateApiKey(apiKey: string): Promise<boolean> {
+    const ai = this.getClient(apiKey);
+    try {
+      // Use list() as a lightweight check.
+      // Even if we don't list all models, a successful auth check is enough.
+      // We limit to 1 to keep it light if possible, though list() usually pages.
+      await ai.models.list({ config: { pageSize: 1 } });
+      return true;
+    } catch (error) {
+      // If it's an auth error, return false.
+      if (isAuthError(error as Error)) {
+        return false;
+      }
+      // For other errors (e.g. network), we rethrow so the user knows something else is wrong
+      throw error;
+    }
+  }
+
+  async refreshCache(name: string, ttlSeconds: number, apiKey: string): Promise<CachedContent> {
+    const ai = this.getClient(apiKey);
+    return ai.caches.update({ name, config: { ttl: `${ttlSeconds}s` } });
+  }
+
+  async deleteCache(name: string, apiKey: string): Promise<void> {
+    const ai = this.getClient(apiKey);
+    await ai.caches.delete({ name });
+  }
+
+  async listCaches(apiKey: string): Promise<CachedContent[]> {
+    const ai = this.getClient(apiKey);
+    const pager = await ai.caches.list();
+
+    const caches: CachedContent[] = [];
+    for await (const cache of pager) {
+      caches.push(cache);
+    }
+    return caches;
+  }
+
+  async uploadFile(path: string, mimeType: string, apiKey: string): Promise<FileData> {
+    const ai = this.getClient(apiKey);
+    const file = await ai.files.upload({ file: path, config: { mimeType } });
+    if (!file.uri) {
+      throw new Error(`Upload of ${path} returned no file URI`);
+    }
+    return { mimeType: file.mimeType ?? mimeType, fileUri: file.uri };
+  }
+
+  async countTokens(contents: Content[], modelName: GeminiModel, apiKey: string): Promise<number> {
+    const ai = this.getClient(apiKey);
+    const result = await ai.models.countTokens({ model: modelName, contents });
+    return result.totalTokens ?? 0;
+  }
+
+  /**
+   * Sends a request with `send` (the SDK's streaming or non-streaming call). Models that
+   * don't support an optional setting (logprobs, media resolution, audio timestamps,
+   * labels, thoughts) reject requests that use it, so those are retried once without that
+   * setting. Streams are rejected before their first chunk, so the same retry covers them.
+   */
+  private async requestContent<T>(
+    send: (params: GenerateContentParameters) => Promise<T>,
+    params: GenerateContentParameters,
+    includeThoughts?: boolean
+  ): Promise<T> {
+    try {
+      return await send(params);
+    } catch (error) {
+      const config: GenerateContentConfig = { ...params.config };
+      const message = (error as Error).message ?? '';
+      const unsupported = removeUnsupportedSetting(config, message, includeThoughts);
+      if (!unsupported) {
+        throw error;
+      }
+      this.logger.warn(`${params.model} does not support ${unsupported}; retrying without it`);
+      return send({ ...params, config });
+    }
+  }
+
+  async generate(
     prompt: string,
     modelName: GeminiModel,
     apiKey: string,
     options?: GenerateOptions
-  ): AsyncGenerator<{ text: string }> {
+  ): Promise<GeminiResponse> {
     const ai = this.getClient(apiKey);
 
-    const systemInstruction = this.normalizeSystemInstruction(options?.systemInstruction);
-    const tools = options?.tools ? [{ functionDeclarations: options.tools }] : undefined;
-    const toolConfig = options?.toolConfig
-      ? {
-          functionCallingConfig: {
-            mode: options.toolConfig.functionCallingMode
-              ? FunctionCallingConfigMode[
-                  options.toolConfig.functionCallingMode.toUpperCase() as keyof typeof FunctionCallingConfigMode
-                ]
-              : undefined,
-            allowedFunctionNames: options.toolConfig.allowedFunctionNames,
-          },
-        }
-      : undefined;
-
-    const config = {
-      temperature: options?.temperature,
-      maxOutputTokens: options?.maxTokens,
-      topP: options?.topP,
-      topK: options?.topK,
-      systemInstruction,
-      tools,
-      toolConfig,
-      safetySettings: options?.safetySettings,
-      responseMimeType: options?.responseMimeType,
-      responseSchema: options?.responseSchema,
-    };
+    const config = this.buildConfig(options);
 
-    const response = await ai.models.generateContentStream({
-      model: modelName,
-      contents: [{ role: 'user', parts: [{ text: prompt }] }],
-      config,
+    const timeoutPromise = new Promise<never>((_, reject) => {
+      setTimeout(() => reject(new Error('Request timeout')), options?.timeout ?? this.timeout);
     });
 
+    const generatePromise = this.requestContent(
+      (params) => ai.models.generateContent(params),
+      { model: modelName, contents: this.buildPromptContents(prompt, options), config },
+      options?.includeThoughts
+    );
+
+    const result = await Promise.race([generatePromise, timeoutPromise]);
+    return this.toGeminiResponse(result, modelName, options);
+  }
+
+  async *generateStream(
+    prompt: string,
+    modelName: GeminiModel,
+    apiKey: string,
+    options?: GenerateOptions
+  ): AsyncGenerator<{ text: string; raw: GenerateContentResponse }> {
+    const ai = this.getClient(apiKey);
+
+    const config = this.buildConfig(options);
+
+    const response = await this.requestContent(
+      (params) => ai.models.generateContentStream(params),
+      { model: modelName, contents: this.buildPromptContents(prompt, options), config },
+      options?.includeThoughts
+    );
+
     for await (const chunk of response) {
-      const chunkText = chunk.text ?? '';
+      const chunkText = this.chunkText(chunk);
       if (chunkText) {
-        yield { text: chunkText };
+        yield { text: chunkText, raw: chunk };
       }
     }
   }
@@ -211,80 +538,20 @@ export class GeminiClient {
   ): Promise<GeminiResponse> {
     const ai = this.getClient(apiKey);
 
-    const systemInstruction = this.normalizeSystemInstruction(options?.systemInstruction);
-    const tools = options?.tools ? [{ functionDeclarations: options.tools }] : undefined;
-    const toolConfig = options?.toolConfig
-      ? {
-          functionCallingConfig: {
-            mode: options.toolConfig.functionCallingMode
-              ? FunctionCallingConfigMode[
-                  options.toolConfig.functionCallingMode.toUpperCase() as keyof typeof FunctionCallingConfigMode
-                ]
-              : undefined,
-            allowedFunctionNames: options.toolConfig.allowedFunctionNames,
-          },
-        }
-      : undefined;
-
-    const config = {
-      temperature: options?.temperature,
-      maxOutputTokens: options?.maxTokens,
-      topP: options?.topP,
-      topK: options?.topK,
-      systemInstruction,
-      tools,
-      toolConfig,
-      safetySettings: options?.safetySettings,
-      responseMimeType: options?.responseMimeType,
-      responseSchema: options?.responseSchema,
-    };
+    const config = this.buildConfig(options);
 
     const timeoutPromise = new Promise<never>((_, reject) => {
-      setTimeout(() => reject(new Error('Request timeout')), this.timeout);
+      setTimeout(() => reject(new Error('Request timeout')), options?.timeout ?? this.timeout);
     });
 
-    const generatePromise = ai.models.generateContent({
-      model: modelName,
-      contents,
-      config,
-    });
+    const generatePromise = this.requestContent(
+      (params) => ai.models.generateContent(params),
+      { model: modelName, contents, config },
+      options?.includeThoughts
+    );
 
     const result = await Promise.race([generatePromise, timeoutPromise]);
-    const text = result.text ?? '';
-
-    // Parse JSON if response is JSON
-    let json: unknown = undefined;
-    if (options?.responseMimeType === 'application/json' && text) {
-      try {
-        json = JSON.parse(text);
-      } catch (error) {
-        // If JSON parsing fails, leave json undefined and keep the text
-        console.warn('Failed to parse JSON response:', error);
-      }
-    }
-
-    // Extract function calls from response
-    const functionCalls = result.candidates?.[0]?.content?.parts
-      ?.filter(hasFunctionCall)
-      .map((part) => ({
-        name: part.functionCall.name,
-        args: part.functionCall.args || {},
-      }));
-
-    return {
-      text,
-      model: modelName,
-      finishReason: result.candidates?.[0]?.finishReason,
-      functionCalls: functionCalls?.length ? functionCalls : undefined,
-      json,
-      usage: result.usageMetadata
-        ? {
-            promptTokens: result.usageMetadata.promptTokenCount || 0,
-            completionTokens: result.usageMetadata.candidatesTokenCount || 0,
-            totalTokens: result.usageMetadata.totalTokenCount || 0,
-          }
-        : undefined,
-    };
+    return this.toGeminiResponse(result, modelName, options);
   }
 
   async *generateContentStream(
@@ -292,47 +559,21 @@ export class GeminiClient {
     modelName: GeminiModel,
     apiKey: string,
     options?: Omit<GenerateContentRequest, 'contents' | 'model'>
-  ): AsyncGenerator<{ text: string }> {
+  ): AsyncGenerator<{ text: string; raw: GenerateContentResponse }> {
     const ai = this.getClient(apiKey);
 
-    const systemInstruction = this.normalizeSystemInstruction(options?.systemInstruction);
-    const tools = options?.tools ? [{ functionDeclarations: options.tools }] : undefined;
-    const toolConfig = options?.toolConfig
-      ? {
-          functionCallingConfig: {
-            mode: options.toolConfig.functionCallingMode
-              ? FunctionCallingConfigMode[
-                  options.toolConfig.functionCallingMode.toUpperCase() as keyof typeof FunctionCallingConfigMode
-                ]
-              : undefined,
-            allowedFunctionNames: options.toolConfig.allowedFunctionNames,
-          },
-        }
-      : undefined;
-
-    const config = {
-      temperature: options?.temperature,
-      maxOutputTokens: options?.maxTokens,
-      topP: options?.topP,
-      topK: options?.topK,
-      systemInstruction,
-      tools,
-      toolConfig,
-      safetySettings: options?.safetySettings,
-      responseMimeType: options?.responseMimeType,
-      responseSchema: options?.responseSchema,
-    };
+    const config = this.buildConfig(options);
 
-    const response = await ai.models.generateContentStream({
-      model: modelName,
-      contents,
-      config,
-    });
+    const response = await this.requestContent(
+      (params) => ai.models.generateContentStream(params),
+      { model: modelName, contents, config },
+      options?.includeThoughts
+    );
 
     for await (const chunk of response) {
-      const chunkText = chunk.text ?? '';
+      const chunkText = this.chunkText(chunk);
       if (chunkText) {
-        yield { text: chunkText };
+        yield { text: chunkText, raw: chunk };
       }
     }
   }
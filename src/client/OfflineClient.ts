@@ -0,0 +1,109 @@
+import type { GeminiModel } from '../types/models';
+import type {
+  GenerateOptions,
+  GenerateContentRequest,
+  Content,
+  FileData,
+} from '../types/config';
+import type { GeminiResponse, CachedContent } from '../types/response';
+import { GeminiClient } from './GeminiClient';
+import { estimateTokens } from '../utils/prompt-size';
+
+/**
+ * Stand-in for GeminiClient used when `offline: true`. Answers from the canned
+ * responses (keyed by prompt text) and echoes any other prompt, without network calls.
+ */
+export class OfflineClient extends GeminiClient {
+  private cannedResponses: Map<string, string>;
+
+  constructor(cannedResponses: Record<string, string> = {}) {
+    super();
+    this.cannedResponses = new Map(Object.entries(cannedResponses));
+  }
+
+  private respond(prompt: string): string {
+    return this.cannedResponses.get(prompt) ?? `[offline] ${prompt}`;
+  }
+
+  async validateApiKey(_apiKey: string): Promise<boolean> {
+    return true;
+  }
+
+  warmup(_apiKey: string): void {}
+
+  async refreshCache(_name: string, _ttlSeconds: number, _apiKey: string): Promise<CachedContent> {
+    throw new Error('Context caches are not available in offline mode');
+  }
+
+  async deleteCache(_name: string, _apiKey: string): Promise<void> {
+    throw new Error('Context caches are not available in offline mode');
+  }
+
+  async listCaches(_apiKey: string): Promise<CachedContent[]> {
+    return [];
+  }
+
+  async uploadFile(path: string, mimeType: string, _apiKey: string): Promise<FileData> {
+    return { mimeType, fileUri: `offline://${path}` };
+  }
+
+  // Rough estimate, since nothing is sent to the API
+  async countTokens(
+    contents: Content[],
+    _modelName: GeminiModel,
+    _apiKey: string
+  ): Promise<number> {
+    return estimateTokens(lastUserText(contents));
+  }
+
+  async generate(
+    prompt: string,
+    modelName: GeminiModel,
+    _apiKey: string,
+    _options?: GenerateOptions
+  ): Promise<GeminiResponse> {
+    return { text: this.respond(prompt), model: modelName, finishReason: 'STOP' };
+  }
+
+  async *generateStream(
+    prompt: string,
+    _modelName: GeminiModel,
+    _apiKey: string,
+    _options?: GenerateOptions
+  ): AsyncGenerator<{ text: string }> {
+    yield* streamWords(this.respond(prompt));
+  }
+
+  async generateContent(
+    contents: Content[],
+    modelName: GeminiModel,
+    _apiKey: string,
+    _options?: Omit<GenerateContentRequest, 'contents' | 'model'>
+  ): Promise<GeminiResponse> {
+    return { text: this.respond(lastUserText(contents)), model: modelName, finishReason: 'STOP' };
+  }
+
+  async *generateContentStream(
+    contents: Content[],
+    _modelName: GeminiModel,
+    _apiKey: string,
+    _options?: Omit<GenerateContentRequest, 'contents' | 'model'>
+  ): AsyncGenerator<{ text: string }> {
+    yield* streamWords(this.respond(lastUserText(contents)));
+  }
+}
+
+// Canned responses for multi-turn contents are keyed by the text of the latest user turn
+function lastUserText(contents: Content[]): string {
+  const lastUser = [...contents].reverse().find((content) => content.role === 'user');
+  return (lastUser?.parts ?? [])
+    .map((part) => ('text' in part ? part.text : ''))
+    .join('');
+}
+
+// Splits the response into word-sized chunks so streaming UIs behave as they would online
+function* streamWords(text: string): Generator<{ text: string }> {
+  for (const word of text.match(/\S+\s*/g) ?? []) {
+    yield { text: word };
+  }
+}
@@ -1,10 +1,15 @@
 import type { GemBackOptions, LogLevel } from '../types/config';
 import { DEFAULT_FALLBACK_ORDER } from '../types/models';
+import { maskApiKey } from '../utils/mask';
 
 export const DEFAULT_MAX_RETRIES = 2;
 export const DEFAULT_TIMEOUT = 30000;
 export const DEFAULT_RETRY_DELAY = 1000;
 export const DEFAULT_LOG_LEVEL: LogLevel = 'error';
+export const DEFAULT_IDEMPOTENCY_TTL = 60000;
+export const DEFAULT_BATCH_CONCURRENCY = 4;
+export const DEFAULT_INLINE_LIMIT_BYTES = 20 * 1024 * 1024;
+export const DEFAULT_POOL_RETRY_DELAY = 10000;
 
 export const DEFAULT_CLIENT_OPTIONS: Partial<GemBackOptions> = {
   fallbackOrder: DEFAULT_FALLBACK_ORDER,
@@ -14,4 +19,6 @@ export const DEFAULT_CLIENT_OPTIONS: Partial<GemBackOptions> = {
   debug: false,
   logLevel: DEFAULT_LOG_LEVEL,
   apiKeyRotationStrategy: 'round-robin',
+  idempotencyTTL: DEFAULT_IDEMPOTENCY_TTL,
+  keyMasker: maskApiKey,
 };
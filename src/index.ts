@@ -1,17 +1,92 @@
 export { GemBack } from './client/FallbackClient';
 export { GeminiClient } from './client/GeminiClient';
+export type { GeminiClientOptions } from './client/GeminiClient';
+export { BatchJob } from './client/BatchJob';
+export { createHttpHandler, getHttpStatus } from './server/http-handler';
+export { schemaFromExample, jsonMode, validateAgainstSchema } from './utils/json-schema';
+export { isIncompleteResponse } from './utils/finish-reason';
+export { getCurrentKeyInfo } from './utils/key-context';
+export { repairJson } from './utils/json-repair';
+export { detectMimeType } from './utils/mime';
+export { sentenceStream } from './utils/sentence-stream';
+export { toOpenAIResponse, toOpenAIFinishReason, fromOpenAIMessages } from './utils/openai';
+export { JsonlAuditSink } from './utils/audit';
+export { configFromEnv } from './utils/env-config';
+export { estimateCost } from './utils/pricing';
+export type { KeyInfo } from './utils/key-context';
+export type { SchemaFromExampleOptions } from './utils/json-schema';
+export type { SentenceStreamOptions } from './utils/sentence-stream';
+export type {
+  OpenAIChatCompletion,
+  OpenAIChatCompletionChoice,
+  OpenAIFinishReason,
+  OpenAIToolCall,
+  ToOpenAIResponseOptions,
+} from './utils/openai';
+export type { HttpHandler } from './server/http-handler';
 export type {
   GeminiModel,
+  ModelName,
   GemBackOptions,
   GeminiBackClientOptions,
   GenerateOptions,
+  GenerateJSONOptions,
+  GenerateCheapestOptions,
+  AttachFileOptions,
   ChatMessage,
   Part,
   Content,
   InlineData,
   FileData,
   GenerateContentRequest,
+  GenerationConfig,
+  OutputWriter,
+  OutputSpillOptions,
+  BatchRequest,
+  BatchOptions,
+  AttemptOrder,
+  RetryPolicy,
+  KeyQuotaOptions,
+  KeyCircuitBreakerOptions,
+  BackoffOptions,
+  KeyState,
+  KeyStartStrategy,
+  ResponsePart,
+  TextPartSelector,
+  MediaResolution,
+  ModelRouteInput,
+  ModelRouter,
+  ContentGenerator,
+  AuditEntry,
+  AuditSink,
+  DefaultGenerationConfig,
+  ModelPricing,
 } from './types/config';
-export type { GeminiResponse, StreamChunk, FallbackStats, ApiKeyStats } from './types/response';
+export type {
+  GeminiResponse,
+  StreamChunk,
+  FallbackStats,
+  ApiKeyStats,
+  KeyInspection,
+  PromptFeedback,
+  SafetyRating,
+  BatchResult,
+  CompareResult,
+  TaggedStreamChunk,
+  TokenUsage,
+  CachedContent,
+  Citation,
+  LogprobsResult,
+  CandidateOutput,
+  ResponseContent,
+  Blob,
+} from './types/response';
 export type { HealthStatus, ModelHealth, RateLimitStatus } from './monitoring';
-export { GeminiBackError } from './types/errors';
+export {
+  GeminiBackError,
+  AbortedError,
+  BillingError,
+  BlockedError,
+  ClientConfigError,
+  SchemaValidationError,
+} from './types/errors';
@@ -27,13 +27,16 @@ export interface RateLimitStatus {
   };
 }
 
+// Applied to models without a configured limit (e.g. newer models passed by name)
+const DEFAULT_LIMIT: RateLimitConfig = { rpm: 15, rpd: 1500 };
+
 /**
  * Tracks rate limiting for each model and API key combination
  * Provides predictions and warnings before hitting limits
  */
 export class RateLimitTracker {
   private readonly defaultLimits: Record<GeminiModel, RateLimitConfig> = Object.fromEntries(
-    ALL_MODELS.map((model) => [model, { rpm: 15, rpd: 1500 }])
+    ALL_MODELS.map((model) => [model, { ...DEFAULT_LIMIT }])
   ) as Record<GeminiModel, RateLimitConfig>;
 
   private requestHistory: Map<string, Date[]> = new Map();
@@ -69,7 +72,7 @@ export class RateLimitTracker {
    */
   getStatus(model: GeminiModel, apiKeyIndex?: number): RateLimitStatus {
     const key = this.getKey(model, apiKeyIndex);
-    const config = this.defaultLimits[model];
+    const config = this.defaultLimits[model] ?? DEFAULT_LIMIT;
     const history = this.requestHistory.get(key) || [];
 
     const now = new Date();
@@ -186,7 +189,7 @@ export class RateLimitTracker {
 
     this.requestHistory.forEach((history, key) => {
       const model = this.extractModelFromKey(key);
-      requestsByModel[model] += history.length;
+      requestsByModel[model] = (requestsByModel[model] || 0) + history.length;
       totalRequests += history.length;
 
       requestsInLastMinute += history.filter((t) => t >= oneMinuteAgo).length;
@@ -0,0 +1,204 @@
+import type { IncomingMessage, ServerResponse } from 'http';
+import type { GemBack } from '../client/FallbackClient';
+import type { GenerateContentRequest } from '../types/config';
+import { GeminiBackError } from '../types/errors';
+
+export type HttpHandler = (req: IncomingMessage, res: ServerResponse) => Promise<void>;
+
+// Body limit when the client has no `maxPromptBytes`: the Gemini API's inline request limit
+const DEFAULT_MAX_BODY_BYTES = 20 * 1024 * 1024;
+
+// Room for the JSON around the prompt (roles, options, ...) on top of `maxPromptBytes`
+const BODY_OVERHEAD_BYTES = 64 * 1024;
+
+class BodyTooLargeError extends Error {}
+
+/**
+ * Maps an error thrown by GemBack to an HTTP status code:
+ * - every attempt rate limited → 429
+ * - prompt larger than `maxPromptBytes` → 413
+ * - blocked by safety filters (`throwOnBlocked`) → 422
+ * - the server's own API key was rejected → 502 (the caller is not at fault)
+ * - all models (or all keys for a pinned model) failed for other reasons → 503
+ * - anything else → 500
+ */
+export function getHttpStatus(error: Error): number {
+  if (!(error instanceof GeminiBackError)) {
+    return 500;
+  }
+
+  const attempts = error.allAttempts;
+  if (attempts.length > 0 && attempts.every((attempt) => attempt.statusCode === 429)) {
+    return 429;
+  }
+  if (error.code === 'PROMPT_TOO_LARGE') {
+    return 413;
+  }
+  if (error.code === 'BLOCKED') {
+    return 422;
+  }
+  if (error.code === 'AUTH_ERROR') {
+    return 502;
+  }
+  if (error.code === 'ALL_MODELS_FAILED' || error.code === 'ALL_KEYS_EXHAUSTED') {
+    return 503;
+  }
+  return 500;
+}
+
+/**
+ * Creates a Node.js `http` request handler that serves `generateContent`.
+ *
+ * POST a JSON `GenerateContentRequest`; the response is the JSON `GeminiResponse`.
+ * Bodies larger than the client's `maxPromptBytes` (plus room for the JSON around the
+ * prompt; 20 MB without one) are rejected with 413 before they are buffered.
+ * Prompts blocked by safety filters return 422 with the response body.
+ * Send `Accept: text/event-stream` (or `?stream=true`) to receive `StreamChunk`s as
+ * server-sent events instead.
+ *
+ * @example
+ * http.createServer(createHttpHandler(client)).listen(8080);
+ */
+export function createHttpHandler(client: GemBack): HttpHandler {
+  const { maxPromptBytes } = client.getConfig();
+  const maxBodyBytes = maxPromptBytes
+    ? maxPromptBytes + BODY_OVERHEAD_BYTES
+    : DEFAULT_MAX_BODY_BYTES;
+
+  return async (req, res) => {
+    if (req.method !== 'POST') {
+      res.setHeader('Allow', 'POST');
+      sendError(res, 405, 'Method not allowed', 'METHOD_NOT_ALLOWED');
+      return;
+    }
+
+    let request: GenerateContentRequest;
+    try {
+      request = await readJsonBody(req, maxBodyBytes);
+    } catch (error) {
+      if (error instanceof BodyTooLargeError) {
+        sendError(res, 413, error.message, 'PROMPT_TOO_LARGE');
+        return;
+      }
+      sendError(res, 400, 'Request body must be valid JSON', 'INVALID_REQUEST');
+      return;
+    }
+
+    if (!request || !Array.isArray(request.contents)) {
+      sendError(res, 400, 'Request body must include a "contents" array', 'INVALID_REQUEST');
+      return;
+    }
+
+    if (wantsStream(req)) {
+      await streamContent(client, request, res);
+      return;
+    }
+
+    try {
+      const response = await client.generateContent(request);
+      sendJson(res, response.promptFeedback?.blockReason ? 422 : 200, toPayload(response));
+    } catch (error) {
+      const err = error as Error;
+      sendError(res, getHttpStatus(err), err.message, errorCode(err));
+    }
+  };
+}
+
+async function streamContent(
+  client: GemBack,
+  request: GenerateContentRequest,
+  res: ServerResponse
+): Promise<void> {
+  try {
+    for await (const chunk of client.generateContentStream(request)) {
+      // Headers are deferred until the first chunk so early failures keep a real status code
+      if (!res.headersSent) {
+        res.writeHead(200, {
+          'Content-Type': 'text/event-stream',
+          'Cache-Control': 'no-cache',
+          Connection: 'keep-alive',
+        });
+      }
+      res.write(`data: ${JSON.stringify(toPayload(chunk))}\n\n`);
+    }
+    res.end();
+  } catch (error) {
+    const err = error as Error;
+    if (!res.headersSent) {
+      sendError(res, getHttpStatus(err), err.message, errorCode(err));
+      return;
+    }
+    const payload = { error: { message: err.message, code: errorCode(err) } };
+    res.write(`event: error\ndata: ${JSON.stringify(payload)}\n\n`);
+    res.end();
+  }
+}
+
+function wantsStream(req: IncomingMessage): boolean {
+  const url = new URL(req.url ?? '/', 'http://localhost');
+  return (
+    url.searchParams.get('stream') === 'true' ||
+    (req.headers.accept ?? '').includes('text/event-stream')
+  );
+}
+
+/**
+ * Reads and parses a JSON body of at most `maxBytes`. A larger body is rejected as soon
+ * as it is detected (from Content-Length or while reading); the rest is discarded unread
+ * so the 413 response can still be delivered.
+ */
+function readJsonBody<T>(req: IncomingMessage, maxBytes: number): Promise<T> {
+  return new Promise((resolve, reject) => {
+    const tooLarge = () => {
+      req.off('data', onData);
+      req.off('end', onEnd);
+      req.resume();
+      reject(new BodyTooLargeError(`Request body exceeds ${maxBytes} bytes`));
+    };
+    const chunks: Buffer[] = [];
+    let size = 0;
+    const onData = (chunk: Buffer | string) => {
+      const buffer = typeof chunk === 'string' ? Buffer.from(chunk) : chunk;
+      size += buffer.length;
+      if (size > maxBytes) {
+        tooLarge();
+        return;
+      }
+      chunks.push(buffer);
+    };
+    const onEnd = () => {
+      try {
+        resolve(JSON.parse(Buffer.concat(chunks).toString('utf8')) as T);
+      } catch (error) {
+        reject(error);
+      }
+    };
+
+    if (Number(req.headers['content-length']) > maxBytes) {
+      tooLarge();
+      return;
+    }
+    req.on('data', onData);
+    req.on('end', onEnd);
+    req.on('error', reject);
+  });
+}
+
+// What clients receive: raw SDK responses and spill writers stay server-side
+function toPayload(value: object): Record<string, unknown> {
+  const { raw: _raw, spilled: _spilled, ...payload } = value as Record<string, unknown>;
+  return payload;
+}
+
+function errorCode(error: Error): string {
+  return error instanceof GeminiBackError ? error.code : 'INTERNAL_ERROR';
+}
+
+function sendJson(res: ServerResponse, status: number, body: unknown): void {
+  res.writeHead(status, { 'Content-Type': 'application/json' });
+  res.end(JSON.stringify(body));
+}
+
+function sendError(res: ServerResponse, status: number, message: string, code: string): void {
+  sendJson(res, status, { error: { message, code } });
+}
@@ -1,4 +1,5 @@
 import type { GeminiModel } from './models';
+import type { GeminiResponse, TokenUsage } from './response';
 import type {
   FunctionDeclaration as SDKFunctionDeclaration,
   FunctionCall as SDKFunctionCall,
@@ -7,10 +8,15 @@ import type {
   HarmCategory as SDKHarmCategory,
   HarmBlockThreshold as SDKHarmBlockThreshold,
   Schema as SDKSchema,
+  GenerateContentConfig as SDKGenerateContentConfig,
+  Part as SDKPart,
 } from '@google/genai';
 
 export type LogLevel = 'debug' | 'info' | 'warn' | 'error' | 'silent';
 
+// A supported model, or an alias / newer model name passed through to the API as-is
+export type ModelName = GeminiModel | (string & {});
+
 // Re-export SDK types for function calling
 export type FunctionDeclaration = SDKFunctionDeclaration;
 export type FunctionCall = SDKFunctionCall;
@@ -24,35 +30,241 @@ export type HarmBlockThreshold = SDKHarmBlockThreshold;
 // Re-export SDK types for JSON mode
 export type ResponseSchema = SDKSchema;
 
+// Full SDK generation config, for parameters not mirrored as individual options
+export type GenerationConfig = SDKGenerateContentConfig;
+
+// A part of a response candidate (text, thought, function call, inline data, ...)
+export type ResponsePart = SDKPart;
+
+// Builds `GeminiResponse.text` from the candidate's parts
+export type TextPartSelector = (parts: ResponsePart[]) => string;
+
+// Resolution at which image and video inputs are tokenized; lower uses fewer tokens
+export type MediaResolution = 'low' | 'medium' | 'high';
+
+// How a request walks (model, API key) pairs when multiple keys are configured:
+// 'keys-first' tries every key on a model before falling back to the next model,
+// 'models-first' tries every model on a key before rotating to the next key
+export type AttemptOrder = 'keys-first' | 'models-first';
+
+// Which API key a request starts from: 'rotate' continues the client's rotation,
+// 'random' picks any key, 'hash' derives it from the request's `keySeed` (random without one).
+// 'random' and 'hash' suit clients that are recreated per invocation (e.g. serverless).
+export type KeyStartStrategy = 'rotate' | 'random' | 'hash';
+
+// Decides whether a failed attempt is worth retrying. Non-retryable errors are not
+// retried and, when rotating keys, skip the model's remaining keys.
+export type RetryPolicy = (error: Error) => boolean;
+
+// Per-model overrides, keyed by model name. Request options take precedence.
+export interface ModelConfig {
+  timeout?: number; // Overrides the client `timeout` for attempts on this model
+  temperature?: number;
+  maxTokens?: number; // e.g. a smaller output budget for a cheaper fallback tier
+  topP?: number;
+  topK?: number;
+}
+
+// Generation settings for every request; the model's `modelConfigs` entry and the
+// request's own options take precedence
+export type DefaultGenerationConfig = Omit<ModelConfig, 'timeout'>;
+
+// Price in USD per million tokens, used to compare models' costs (e.g. generateCheapest)
+export interface ModelPricing {
+  input: number;
+  output: number;
+}
+
+// Soft per-key token quota. Keys whose usage in the current window reaches
+// `threshold` of their quota are skipped by rotation until the window resets.
+export interface KeyQuotaOptions {
+  dailyTokens: number | number[]; // One quota for every key, or one per key (by index)
+  threshold?: number; // Fraction of the quota at which a key is skipped (default: 0.9)
+  resetIntervalMs?: number; // Length of the usage window (default: 24 hours)
+  now?: () => number; // Clock used for the window (default: Date.now)
+}
+
+// Per-key circuit breaker: a key whose requests keep failing is skipped by rotation
+// for `resetTimeoutMs`, then gets one trial request that closes or reopens the circuit.
+export interface KeyCircuitBreakerOptions {
+  failureThreshold?: number; // Consecutive failed requests that open the circuit (default: 5)
+  resetTimeoutMs?: number; // How long an open key is skipped (default: 60000)
+  now?: () => number; // Clock used for the timeout (default: Date.now)
+}
+
+// Reported by `onKeyStateChange`: 'invalid' on an authentication or billing failure,
+// 'recovered' when an invalid key or a key with an open circuit succeeds again
+export type KeyState = 'invalid' | 'circuit-open' | 'circuit-half-open' | 'recovered';
+
+// One request as recorded by `auditSink`: `response` on success, `error` on failure
+export interface AuditEntry {
+  timestamp: Date; // When the request finished
+  durationMs: number;
+  prompt: string | Content[];
+  model?: GeminiModel; // The model that answered
+  maskedKey?: string; // The key that answered, masked with `keyMasker`
+  response?: string; // The response text, before trimOutput / stripMarkdown
+  usage?: TokenUsage;
+  error?: { code: string; message: string };
+}
+
+// Receives audit entries; a failure (thrown or rejected) is logged and never fails the request
+export interface AuditSink {
+  record(entry: AuditEntry): void | Promise<void>;
+}
+
+// Backoff for retries of one class of error; unset fields use retryDelay / retryJitter
+export interface BackoffOptions {
+  delay?: number; // Initial delay (ms), doubled on each retry
+  jitter?: 'none' | 'full' | 'equal';
+}
+
+// What `modelRouter` sees of a request; `fallbackOrder` is the request's own
+export interface ModelRouteInput {
+  prompt?: string; // generate(), generateStream(), generateOnce() and countTokens()
+  contents?: Content[]; // generateContent() and generateContentStream()
+  fallbackOrder?: ModelName[];
+}
+
+// Returns the models to try, in order; nothing (or an empty list) keeps the usual selection
+export type ModelRouter = (input: ModelRouteInput) => ModelName[] | undefined;
+
+// Serves a generateContent() request elsewhere, e.g. an adapter for another provider
+export type ContentGenerator = (request: GenerateContentRequest) => Promise<GeminiResponse>;
+
 export interface GemBackOptions {
   apiKey?: string;
   apiKeys?: string[];
-  fallbackOrder?: GeminiModel[];
+  fallbackOrder?: ModelName[];
+  modelWeights?: Record<string, number>; // Start each request on a weighted random model
   maxRetries?: number;
   timeout?: number;
+  streamIdleTimeout?: number; // Fail a stream attempt when no chunk arrives for this many ms
   retryDelay?: number;
+  retryJitter?: 'none' | 'full' | 'equal'; // Randomize backoff delays (default: 'none')
+  rateLimitBackoff?: BackoffOptions; // Retry 429s on the same key with this backoff before rotating
+  serverErrorBackoff?: BackoffOptions; // Backoff for retrying 5xx errors
+  retryCounts?: Record<number, number>; // Same-key retries per HTTP status, e.g. { 503: 2, 500: 0 }
+  retryPolicy?: RetryPolicy; // Default: 4xx (except 429) fail fast, 5xx/timeouts/network retry
+  retryOnMessages?: string[]; // Also retry errors without a status whose message contains one
   debug?: boolean;
   logLevel?: LogLevel;
   apiKeyRotationStrategy?: 'round-robin' | 'least-used';
   enableMonitoring?: boolean; // Enable rate limit tracking and health monitoring
   enableRateLimitPrediction?: boolean; // Enable predictive rate limit warnings
+  idempotencyTTL?: number; // How long (ms) idempotent results are replayed (default: 60000)
+  dedupWindow?: number; // Replay results of identical requests made within this many ms
+  maxConcurrency?: number; // Cap on requests in flight across the client (default: 0, unlimited)
+  modelAliases?: Record<string, GeminiModel>; // e.g. { flash: 'gemini-2.5-flash' }
+  modelRouter?: ModelRouter; // Per-request model choice over fallbackOrder; pinned models skip it
+  attemptOrder?: AttemptOrder; // Unset: one key per request, falling back across models only
+  textPartSelector?: TextPartSelector; // Non-streaming; default concatenates all text parts
+  templates?: Record<string, string>; // Prompt templates with {{variable}} placeholders
+  maxPromptBytes?: number; // Reject larger prompts before any API call (default: 0, no limit)
+  modelConfigs?: Record<string, ModelConfig>; // e.g. { 'gemini-2.5-pro': { timeout: 60000 } }
+  defaultGenerationConfig?: DefaultGenerationConfig; // temperature, topP, ... for every request
+  offline?: boolean; // Serve canned responses without calling the API (local development)
+  cannedResponses?: Record<string, string>; // Offline mode: prompt → response; others are echoed
+  keyQuota?: KeyQuotaOptions; // Multi-key: rotate away from keys nearing a daily token quota
+  retryOnIncomplete?: boolean; // Retry OTHER/unspecified finish reasons (default: false)
+  apiVersion?: string; // Gemini API version, e.g. 'v1' or 'v1beta' (default: the SDK default)
+  forwardLabels?: boolean; // Also send request `labels` as API labels (where supported)
+  keyStartStrategy?: KeyStartStrategy; // Multi-key: where each request starts (default: 'rotate')
+  validator?: (response: GeminiResponse) => void | Promise<void>; // Throw to reject and retry
+  finalFallback?: ContentGenerator; // generateContent() last resort once all keys and models fail
+  maxTotalTokens?: number; // Fail further calls once the client has used this many tokens
+  trimOutput?: boolean; // Trim whitespace around non-streaming `text` (default: false)
+  sanitizeOutput?: boolean; // Strip BOMs and control characters from text and stream chunks
+  stripMarkdown?: boolean; // Convert non-streaming `text` from markdown to plain text
+  estimateMissingUsage?: boolean; // Estimate usage locally when the API reports none
+  throwOnBlocked?: boolean; // Throw BlockedError for blocked prompts/responses (non-streaming)
+  poolRetries?: number; // Retry the whole key rotation when every attempt was rate limited
+  poolRetryDelay?: number; // Cooldown before each pool retry (ms, default: 10000)
+  minAttemptTimeMs?: number; // With a request deadline, skip attempts with less time left
+  keyMasker?: (apiKey: string) => string; // How keys appear in logs, errors and getConfig()
+  keyCircuitBreaker?: KeyCircuitBreakerOptions; // Multi-key: skip keys that keep failing
+  onKeyStateChange?: (maskedKey: string, state: KeyState) => void; // e.g. page on-call
+  auditSink?: AuditSink; // Records every non-streaming request's prompt and response
+  auditFailures?: boolean; // Also record failed requests to the auditSink (default: false)
+  modelPricing?: Record<string, ModelPricing>; // USD per 1M tokens by model, for generateCheapest
 }
 
 // Deprecated: Use GemBackOptions instead
 export type GeminiBackClientOptions = GemBackOptions;
 
+// Minimal writable target for spilled output (e.g. fs.WriteStream, process.stdout)
+export interface OutputWriter {
+  write(chunk: string): unknown; // Returning false (backpressure) waits for 'drain' via `once`
+  once?(event: 'drain' | 'error', listener: (error?: Error) => void): unknown;
+  off?(event: 'drain' | 'error', listener: (error?: Error) => void): unknown;
+}
+
+export interface OutputSpillOptions {
+  writer: OutputWriter;
+  thresholdBytes: number; // Responses larger than this (UTF-8 bytes) are written to `writer`
+}
+
 export interface GenerateOptions {
-  model?: GeminiModel;
+  model?: ModelName;
+  fallbackOrder?: ModelName[]; // Overrides the client's fallback order (and `model`) for this call
+  timeout?: number; // Per-request timeout (ms); overrides modelConfigs and the client timeout
   temperature?: number;
   maxTokens?: number;
   topP?: number;
   topK?: number;
+  seed?: number; // Fixed sampling seed; determinism also needs temperature 0 and is best-effort
+  candidateCount?: number; // Alternatives to generate; see GeminiResponse.candidates
+  responseLogprobs?: boolean; // Return token log probabilities (ignored by unsupported models)
+  logprobs?: number; // Top candidate tokens per step to include with responseLogprobs
+  mediaResolution?: MediaResolution; // Image/video input resolution (ignored by unsupported models)
+  audioTimestamp?: boolean; // Let the model reference timestamps in audio inputs (same)
+  includeThoughts?: boolean; // Return thought summaries in `thoughts` (thinking models only)
   systemInstruction?: string | Content;
+  responseLanguage?: string; // Adds "Respond in {language}." to the system instruction
+  trimOutput?: boolean; // Overrides the client's trimOutput for this call
+  stripMarkdown?: boolean; // Overrides the client's stripMarkdown for this call
   tools?: FunctionDeclaration[];
   toolConfig?: ToolConfig;
   safetySettings?: SafetySetting[];
   responseMimeType?: string;
   responseSchema?: ResponseSchema;
+  spillOutput?: OutputSpillOptions; // Write large responses to a writer instead of returning them
+  generationConfig?: GenerationConfig; // Applied as-is; individual fields above override its values
+  idempotencyKey?: string; // Requests sharing a key run once; duplicates receive the same result
+  resumeOnError?: boolean; // Streaming: resume on the next key after a mid-stream error
+  signal?: AbortSignal; // Streaming: cancels this stream alone (abort() cancels every request)
+  deadline?: number; // Epoch ms (e.g. Date.now() + 5000); no retries or fallbacks start after it
+  echoPrompt?: boolean; // Copy the prompt onto the response, e.g. to correlate batch results
+  labels?: Record<string, string>; // Copied onto the response; sent to the API with forwardLabels
+  parts?: Part[]; // Sent in order with the prompt text; pass '' as the prompt to send only these
+  promptPosition?: 'before' | 'after'; // Prompt text before (default) or after `parts`
+  truncateToTokens?: number; // Shorten a longer prompt to fit (uses countTokens) instead of failing
+  truncateFrom?: 'head' | 'tail'; // Which end of the prompt to cut (default: 'tail')
+  keySeed?: string; // keyStartStrategy 'hash': equal seeds start on the same key
+}
+
+export interface GenerateJSONOptions extends GenerateOptions {
+  repairJson?: boolean; // Fix code fences, surrounding prose and trailing commas; regenerate once
+  validateSchema?: boolean; // Regenerate JSON not matching responseSchema (up to maxRetries)
+}
+
+export interface GenerateCheapestOptions
+  extends Omit<GenerateOptions, 'model' | 'fallbackOrder' | 'idempotencyKey'> {
+  windowMs?: number; // How long to wait for cheaper models after the first success (default: 500)
+}
+
+export interface AttachFileOptions {
+  inlineLimitBytes?: number; // Base64 size above which files are uploaded (default: 20 MB)
+  mimeType?: string; // Skip detection and use this MIME type
+}
+
+export interface BatchRequest {
+  prompt: string;
+  options?: GenerateOptions;
+}
+
+export interface BatchOptions {
+  concurrency?: number; // Maximum requests in flight at once (default: 4)
 }
 
 export interface ChatMessage {
@@ -87,17 +299,38 @@ export interface ToolConfig {
 
 export interface GenerateContentRequest {
   contents: Content[];
-  model?: GeminiModel;
+  model?: ModelName;
+  fallbackOrder?: ModelName[]; // Overrides the client's fallback order (and `model`) for this call
+  timeout?: number;
   temperature?: number;
   maxTokens?: number;
   topP?: number;
   topK?: number;
+  seed?: number;
+  candidateCount?: number;
+  responseLogprobs?: boolean;
+  logprobs?: number;
+  mediaResolution?: MediaResolution;
+  audioTimestamp?: boolean;
+  includeThoughts?: boolean;
   systemInstruction?: string | Content;
+  responseLanguage?: string; // Adds "Respond in {language}." to the system instruction
+  trimOutput?: boolean; // Overrides the client's trimOutput for this call
+  stripMarkdown?: boolean; // Overrides the client's stripMarkdown for this call
   tools?: FunctionDeclaration[];
   toolConfig?: ToolConfig;
   safetySettings?: SafetySetting[];
   responseMimeType?: string;
   responseSchema?: ResponseSchema;
+  spillOutput?: OutputSpillOptions;
+  generationConfig?: GenerationConfig;
+  idempotencyKey?: string;
+  resumeOnError?: boolean;
+  signal?: AbortSignal;
+  deadline?: number;
+  echoPrompt?: boolean;
+  labels?: Record<string, string>;
+  keySeed?: string;
 }
 
 export { GeminiModel };
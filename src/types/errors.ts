@@ -1,10 +1,13 @@
 import type { GeminiModel } from './models';
+import type { SafetyRating } from './response';
 
 export interface AttemptRecord {
   model: GeminiModel;
   error: string;
   timestamp: Date;
   statusCode?: number;
+  keyIndex?: number; // Index of the API key used (multi-key mode only)
+  durationMs?: number; // Time spent on the attempt, including its retries
 }
 
 export class GeminiBackError extends Error {
@@ -29,3 +32,135 @@ export class GeminiBackError extends Error {
     Error.captureStackTrace(this, this.constructor);
   }
 }
+
+/**
+ * The key's billing or quota project is disabled. The key is skipped for the rest of
+ * the client's life; `maskedKey` identifies it without exposing the secret.
+ */
+export class BillingError extends GeminiBackError {
+  public readonly maskedKey: string;
+
+  constructor(
+    maskedKey: string,
+    allAttempts: AttemptRecord[] = [],
+    statusCode?: number,
+    modelAttempted?: GeminiModel
+  ) {
+    super(
+      `Billing is disabled for API key ${maskedKey}. The key will no longer be used.`,
+      'BILLING_ERROR',
+      allAttempts,
+      statusCode,
+      modelAttempted
+    );
+    this.name = 'BillingError';
+    this.maskedKey = maskedKey;
+  }
+}
+
+/**
+ * The prompt or the response was blocked by safety filters (thrown with `throwOnBlocked`).
+ * `safetyRatings` holds the ratings that triggered the block: those the API flagged as
+ * blocked, or failing that, those rated MEDIUM or HIGH.
+ */
+export class BlockedError extends GeminiBackError {
+  public readonly blockReason: string;
+  public readonly blockedPart: 'prompt' | 'response';
+  public readonly safetyRatings: SafetyRating[];
+
+  constructor(
+    blockReason: string,
+    blockedPart: 'prompt' | 'response',
+    safetyRatings: SafetyRating[],
+    modelAttempted?: GeminiModel
+  ) {
+    const categories = safetyRatings.flatMap((rating) => rating.category ?? []);
+    super(
+      `The ${blockedPart} was blocked (${blockReason})` +
+        (categories.length ? `: ${categories.join(', ')}` : ''),
+      'BLOCKED',
+      [],
+      undefined,
+      modelAttempted
+    );
+    this.name = 'BlockedError';
+    this.blockReason = blockReason;
+    this.blockedPart = blockedPart;
+    this.safetyRatings = safetyRatings;
+  }
+
+  // Harm categories of the triggering ratings, e.g. ['HARM_CATEGORY_DANGEROUS_CONTENT']
+  categories(): string[] {
+    return this.safetyRatings.flatMap((rating) => rating.category ?? []);
+  }
+}
+
+/**
+ * The request was cancelled by `abort()`. For streams, `partialText` holds the text
+ * already yielded to the caller, so it isn't lost; it is empty for other requests.
+ */
+export class AbortedError extends GeminiBackError {
+  public readonly partialText: string;
+
+  constructor(allAttempts: AttemptRecord[] = [], partialText = '') {
+    super('Request aborted by abort().', 'ABORTED', allAttempts);
+    this.name = 'AbortedError';
+    this.partialText = partialText;
+  }
+}
+
+/**
+ * The SDK client could not be created for a reason unrelated to the API key, such as
+ * an invalid option. Every key would fail the same way, so no other key is tried.
+ */
+export class ClientConfigError extends GeminiBackError {
+  constructor(message: string, allAttempts: AttemptRecord[] = []) {
+    super(message, 'CLIENT_CONFIG_ERROR', allAttempts);
+    this.name = 'ClientConfigError';
+  }
+}
+
+// The API resolved without a response body; retried like a server error
+export class EmptyResponseError extends Error {
+  constructor() {
+    super('Empty response from API');
+    this.name = 'EmptyResponseError';
+  }
+}
+
+// A response stopped for an OTHER/unspecified reason; retried with `retryOnIncomplete`
+export class IncompleteResponseError extends Error {
+  constructor(finishReason?: string) {
+    super(`Incomplete response (finish reason ${finishReason})`);
+    this.name = 'IncompleteResponseError';
+  }
+}
+
+// The `validator` option rejected a response, so it is retried like a failure
+export class ResponseValidationError extends Error {
+  constructor(reason: string) {
+    super(`Response validation failed: ${reason}`);
+    this.name = 'ResponseValidationError';
+  }
+}
+
+/**
+ * `generateJSON` with `validateSchema` kept getting JSON that doesn't match the response
+ * schema. `violations` lists the last response's problems, e.g.
+ * `$.email: missing required field`.
+ */
+export class SchemaValidationError extends GeminiBackError {
+  public readonly violations: string[];
+
+  constructor(violations: string[], modelAttempted?: GeminiModel) {
+    super(
+      `Response JSON does not match the schema: ${violations.join('; ')}`,
+      'SCHEMA_VALIDATION_FAILED',
+      [],
+      undefined,
+      modelAttempted
+    );
+    this.name = 'SchemaValidationError';
+    this.violations = violations;
+  }
+}
@@ -1,23 +1,110 @@
+import type {
+  Blob as SDKBlob,
+  CachedContent as SDKCachedContent,
+  Citation as SDKCitation,
+  GenerateContentResponse as SDKGenerateContentResponse,
+  GenerateContentResponsePromptFeedback,
+  LogprobsResult as SDKLogprobsResult,
+  SafetyRating as SDKSafetyRating,
+} from '@google/genai';
 import type { GeminiModel } from './models';
-import type { FunctionCall } from './config';
+import type { Content, FunctionCall, OutputWriter } from './config';
+
+export interface TokenUsage {
+  promptTokens: number;
+  completionTokens: number; // Summed over all candidates when candidateCount > 1
+  totalTokens: number;
+  cachedTokens?: number; // Prompt tokens served from a context cache (billed at a reduced rate)
+  estimated?: boolean; // True when a count was derived from the total or estimated locally
+}
 
 export interface GeminiResponse {
   text: string;
+  thoughts?: string; // Thought summaries, kept out of `text`; set with `includeThoughts`
   model: GeminiModel;
   finishReason?: string;
   functionCalls?: FunctionCall[];
+  isToolCall?: boolean; // True when the response is only function calls (empty text is expected)
   json?: unknown; // Parsed JSON response when using JSON mode
-  usage?: {
-    promptTokens: number;
-    completionTokens: number;
-    totalTokens: number;
+  usage?: TokenUsage;
+  candidates?: CandidateOutput[]; // Every candidate, set only when more than one was returned
+  content?: ResponseContent; // Every part of the first candidate, grouped by kind
+  promptFeedback?: PromptFeedback; // Block reason and safety ratings for the prompt itself
+  safetyRatings?: SafetyRating[]; // The first candidate's safety ratings
+  citations?: Citation[]; // Sources the candidate recited from, for attribution
+  logprobs?: LogprobsResult; // Token log probabilities, set when requested with responseLogprobs
+  avgLogprobs?: number; // Average log probability of the candidate's tokens
+  prompt?: string | Content[]; // The request prompt, when `echoPrompt` is set
+  labels?: Record<string, string>; // The request's `labels`, for correlation
+  fallbackDepth?: number; // Position of `model` in the fallback chain (0 = first model succeeded)
+  truncatedTokens?: number; // Tokens cut from the prompt by `truncateToTokens`
+  spilled?: {
+    bytes: number; // Size of the text written to the writer
+    writer: OutputWriter; // The writer that received the text
   };
 }
 
+// Inline binary data (e.g. a generated image) returned in a response part
+export type Blob = SDKBlob;
+
+// The first candidate's parts by kind, so mixed responses don't lose non-text parts
+export interface ResponseContent {
+  textParts: string[]; // `text` is their concatenation (thought parts are excluded)
+  functionCalls: FunctionCall[];
+  blobs: Blob[];
+}
+
+// One of several alternatives generated with candidateCount > 1
+export interface CandidateOutput {
+  text: string;
+  finishReason?: string;
+  tokenCount?: number; // This candidate's completion tokens, when the API reports them
+}
+
+export type PromptFeedback = GenerateContentResponsePromptFeedback;
+
+// Harm category, probability and whether it caused a block
+export type SafetyRating = SDKSafetyRating;
+
+// Source attribution (uri, title, license, text span) for recited content
+export type Citation = SDKCitation;
+
+// Chosen and top candidate tokens with their log probabilities, per decoding step
+export type LogprobsResult = SDKLogprobsResult;
+
+// Context cache metadata (name, model, expireTime, usage) as returned by the API
+export type CachedContent = SDKCachedContent;
+
 export interface StreamChunk {
   text: string;
   model: GeminiModel;
   isComplete: boolean;
+  // With resumeOnError, set when a fallback model restarts the output: discard the text
+  // received before this chunk
+  reset?: boolean;
+  // The SDK response this chunk came from, e.g. to watch safety ratings during a stream.
+  // Absent on the final `isComplete` chunk and in offline mode.
+  raw?: SDKGenerateContentResponse;
+}
+
+// A chunk from one stream of `generateBatchStream`, tagged with the request's index.
+// A stream that fails yields a single entry with `error` instead of `chunk`.
+export interface TaggedStreamChunk {
+  index: number;
+  chunk?: StreamChunk;
+  error?: Error;
+}
+
+export interface BatchResult {
+  index: number; // Position of the request in the submitted batch
+  response?: GeminiResponse;
+  error?: Error;
+}
+
+// One model's outcome in `generateCompare`: its response, or the error it failed with
+export interface CompareResult {
+  response?: GeminiResponse;
+  error?: Error;
 }
 
 export interface ApiKeyStats {
@@ -29,11 +116,24 @@ export interface ApiKeyStats {
   lastUsed?: Date;
 }
 
+// One key's report from `inspectKeys`
+export interface KeyInspection {
+  keyIndex: number;
+  key: string; // Masked with `keyMasker`
+  // From a lightweight API call; 'error' covers network and other unexpected failures
+  status: 'valid' | 'invalid' | 'rate-limited' | 'error';
+  error?: string; // Why the call failed, for 'rate-limited' and 'error'
+  circuitOpen: boolean; // Rotation is currently skipping the key after repeated failures
+  disabled: boolean; // Dropped from rotation after a billing failure
+  quotaHeadroom?: number; // Tokens left of its `keyQuota` in the current window
+}
+
 export interface FallbackStats {
   totalRequests: number;
   successRate: number;
   modelUsage: Record<GeminiModel, number>;
   failureCount: number;
+  fallbackDepths: Record<number, number>; // Successful requests by depth reached (0 = first model)
   apiKeyStats?: ApiKeyStats[];
   monitoring?: {
     rateLimitStatus?: import('../monitoring').RateLimitStatus[];
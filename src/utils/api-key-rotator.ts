@@ -1,14 +1,48 @@
 import type { ApiKeyStats } from '../types/response';
+import type { KeyQuotaOptions, KeyCircuitBreakerOptions, KeyState } from '../types/config';
+
+const DEFAULT_QUOTA_THRESHOLD = 0.9;
+const DEFAULT_QUOTA_RESET_INTERVAL = 24 * 60 * 60 * 1000;
+const DEFAULT_CIRCUIT_FAILURE_THRESHOLD = 5;
+const DEFAULT_CIRCUIT_RESET_TIMEOUT = 60000;
 
 export type RotationStrategy = 'round-robin' | 'least-used';
 
+/**
+ * Maps a string to a stable key index (FNV-1a), so equal seeds pick the same key.
+ */
+export function hashToKeyIndex(seed: string, totalKeys: number): number {
+  let hash = 0x811c9dc5;
+  for (let i = 0; i < seed.length; i++) {
+    hash ^= seed.charCodeAt(i);
+    hash = Math.imul(hash, 0x01000193);
+  }
+  return (hash >>> 0) % totalKeys;
+}
+
 export class ApiKeyRotator {
   private apiKeys: string[];
   private currentIndex: number;
   private strategy: RotationStrategy;
   private keyStats: Map<number, ApiKeyStats>;
+  private quota?: KeyQuotaOptions;
+  private tokensUsed: number[];
+  private quotaWindowStart: number;
+  private disabledKeys: Set<number>;
+  private circuitBreaker?: KeyCircuitBreakerOptions;
+  private onStateChange?: (keyIndex: number, state: KeyState) => void;
+  private consecutiveFailures: number[];
+  private circuitOpenedAt: Map<number, number>;
+  private halfOpenTrials: Map<number, number>; // Key index → when its trial request started
+  private pinnedIndex?: number;
 
-  constructor(apiKeys: string[], strategy: RotationStrategy = 'round-robin') {
+  constructor(
+    apiKeys: string[],
+    strategy: RotationStrategy = 'round-robin',
+    quota?: KeyQuotaOptions,
+    circuitBreaker?: KeyCircuitBreakerOptions,
+    onStateChange?: (keyIndex: number, state: KeyState) => void
+  ) {
     if (!apiKeys || apiKeys.length === 0) {
       throw new Error('At least one API key is required');
     }
@@ -17,6 +51,15 @@ export class ApiKeyRotator {
     this.currentIndex = 0;
     this.strategy = strategy;
     this.keyStats = new Map();
+    this.quota = quota;
+    this.tokensUsed = apiKeys.map(() => 0);
+    this.quotaWindowStart = this.now();
+    this.disabledKeys = new Set();
+    this.circuitBreaker = circuitBreaker;
+    this.onStateChange = onStateChange;
+    this.consecutiveFailures = apiKeys.map(() => 0);
+    this.circuitOpenedAt = new Map();
+    this.halfOpenTrials = new Map();
 
     this.apiKeys.forEach((_, index) => {
       this.keyStats.set(index, {
@@ -34,31 +77,225 @@ export class ApiKeyRotator {
     const index = this.selectKeyIndex();
     const key = this.apiKeys[index];
 
-    const stats = this.keyStats.get(index)!;
-    stats.totalRequests++;
-    stats.lastUsed = new Date();
+    this.recordUsage(index);
 
     return { key, index };
   }
 
-  private selectKeyIndex(): number {
+  /**
+   * Picks a key starting from `startIndex` instead of the rotation position, e.g. a
+   * random or hashed start. Keys near their quota are passed over like in rotation.
+   */
+  getKeyFrom(startIndex: number): { key: string; index: number } {
+    const index = this.peekKeyFrom(startIndex);
+
+    this.recordUsage(index);
+    return { key: this.apiKeys[index], index };
+  }
+
+  /**
+   * The index `getNextKey()` would return, without advancing the rotation or counting
+   * a request against the key.
+   */
+  peekNextIndex(): number {
+    if (this.pinnedIndex !== undefined) {
+      return this.pinnedIndex;
+    }
     if (this.strategy === 'round-robin') {
-      const index = this.currentIndex;
-      this.currentIndex = (this.currentIndex + 1) % this.apiKeys.length;
-      return index;
-    } else {
-      return this.getLeastUsedKeyIndex();
+      // Skip disabled keys and keys near their quota; if none are left, rotate as usual
+      return this.findSelectableIndex(this.currentIndex) ?? this.currentIndex;
+    }
+    return this.getLeastUsedKeyIndex();
+  }
+
+  // The index `getKeyFrom(startIndex)` would return, without counting a request
+  peekKeyFrom(startIndex: number): number {
+    return (
+      this.pinnedIndex ?? this.findSelectableIndex(startIndex) ?? startIndex % this.apiKeys.length
+    );
+  }
+
+  /**
+   * Selects only the key at `keyIndex` until `unpin()`, whatever its quota, circuit or
+   * disabled state, e.g. to route all traffic to a known-good key during an incident.
+   */
+  pin(keyIndex: number): void {
+    this.pinnedIndex = keyIndex;
+  }
+
+  unpin(): void {
+    this.pinnedIndex = undefined;
+  }
+
+  getPinnedIndex(): number | undefined {
+    return this.pinnedIndex;
+  }
+
+  /**
+   * Stops selecting a key for the rest of the rotator's life (e.g. its billing is
+   * disabled). If every key is disabled, rotation falls back to all keys.
+   */
+  disableKey(keyIndex: number): void {
+    this.disabledKeys.add(keyIndex);
+  }
+
+  isDisabled(keyIndex: number): boolean {
+    return this.disabledKeys.has(keyIndex);
+  }
+
+  /**
+   * Counts a request against a key without advancing the rotation.
+   * Used when a request rotates through additional keys after `getNextKey()`.
+   */
+  recordUsage(keyIndex: number): void {
+    const stats = this.keyStats.get(keyIndex);
+    if (stats) {
+      stats.totalRequests++;
+      stats.lastUsed = new Date();
+    }
+    this.startTrialIfHalfOpen(keyIndex);
+  }
+
+  /**
+   * Adds tokens consumed by a request to the key's usage in the current quota window.
+   */
+  recordTokens(keyIndex: number, tokens: number): void {
+    if (!this.quota) {
+      return;
+    }
+    this.resetQuotaWindowIfDue();
+    if (keyIndex >= 0 && keyIndex < this.tokensUsed.length) {
+      this.tokensUsed[keyIndex] += tokens;
+    }
+  }
+
+  /**
+   * Whether the key has used `threshold` of its soft token quota in the current window.
+   */
+  isNearQuota(keyIndex: number): boolean {
+    if (!this.quota) {
+      return false;
     }
+    this.resetQuotaWindowIfDue();
+    const { dailyTokens, threshold = DEFAULT_QUOTA_THRESHOLD } = this.quota;
+    const limit = Array.isArray(dailyTokens) ? dailyTokens[keyIndex] : dailyTokens;
+    return limit !== undefined && this.tokensUsed[keyIndex] >= limit * threshold;
+  }
+
+  /**
+   * Tokens each key has left of its soft quota in the current window (never negative);
+   * undefined for keys without a quota.
+   */
+  getQuotaHeadroom(): Array<number | undefined> {
+    if (!this.quota) {
+      return this.apiKeys.map(() => undefined);
+    }
+    this.resetQuotaWindowIfDue();
+    const { dailyTokens } = this.quota;
+    return this.tokensUsed.map((used, keyIndex) => {
+      const limit = Array.isArray(dailyTokens) ? dailyTokens[keyIndex] : dailyTokens;
+      return limit === undefined ? undefined : Math.max(limit - used, 0);
+    });
+  }
+
+  private now(): number {
+    return this.quota?.now ? this.quota.now() : Date.now();
+  }
+
+  /**
+   * Whether rotation should skip the key: its circuit opened less than the reset timeout
+   * ago, or it is half-open and its one trial request is still in flight. Read-only; the
+   * half-open transition happens when a request is counted against the key.
+   */
+  isCircuitOpen(keyIndex: number): boolean {
+    const openedAt = this.circuitOpenedAt.get(keyIndex);
+    if (openedAt === undefined) {
+      return false;
+    }
+    const resetTimeout = this.circuitBreaker?.resetTimeoutMs ?? DEFAULT_CIRCUIT_RESET_TIMEOUT;
+    const now = this.circuitNow();
+    if (now - openedAt < resetTimeout) {
+      return true;
+    }
+    // A trial whose outcome never arrives (e.g. an aborted request) expires the same way
+    const trialStartedAt = this.halfOpenTrials.get(keyIndex);
+    return trialStartedAt !== undefined && now - trialStartedAt < resetTimeout;
+  }
+
+  // Lets one request through a circuit whose reset timeout has passed
+  private startTrialIfHalfOpen(keyIndex: number): void {
+    if (!this.circuitOpenedAt.has(keyIndex) || this.isCircuitOpen(keyIndex)) {
+      return;
+    }
+    this.halfOpenTrials.set(keyIndex, this.circuitNow());
+    this.onStateChange?.(keyIndex, 'circuit-half-open');
+  }
+
+  private openCircuit(keyIndex: number): void {
+    this.circuitOpenedAt.set(keyIndex, this.circuitNow());
+    this.halfOpenTrials.delete(keyIndex);
+    this.onStateChange?.(keyIndex, 'circuit-open');
+  }
+
+  private circuitNow(): number {
+    return this.circuitBreaker?.now ? this.circuitBreaker.now() : Date.now();
+  }
+
+  private resetQuotaWindowIfDue(): void {
+    const interval = this.quota?.resetIntervalMs ?? DEFAULT_QUOTA_RESET_INTERVAL;
+    const now = this.now();
+    if (now - this.quotaWindowStart >= interval) {
+      this.tokensUsed.fill(0);
+      this.quotaWindowStart = now;
+    }
+  }
+
+  /**
+   * First key from `startIndex` that is enabled, has a closed circuit and is not near its
+   * quota; failing that, the first enabled key with a closed circuit, then the first
+   * enabled key. Undefined when every key is disabled.
+   */
+  private findSelectableIndex(startIndex: number): number | undefined {
+    const total = this.apiKeys.length;
+    const preferences = [
+      (index: number) => !this.isCircuitOpen(index) && !this.isNearQuota(index),
+      (index: number) => !this.isCircuitOpen(index),
+      () => true,
+    ];
+    for (const isPreferred of preferences) {
+      for (let offset = 0; offset < total; offset++) {
+        const index = (startIndex + offset) % total;
+        if (!this.disabledKeys.has(index) && isPreferred(index)) {
+          return index;
+        }
+      }
+    }
+    return undefined;
+  }
+
+  private selectKeyIndex(): number {
+    const index = this.peekNextIndex();
+    if (this.pinnedIndex === undefined && this.strategy === 'round-robin') {
+      this.currentIndex = (index + 1) % this.apiKeys.length;
+    }
+    return index;
   }
 
   private getLeastUsedKeyIndex(): number {
     let minRequests = Infinity;
     let selectedIndex = 0;
 
-    this.keyStats.forEach((stats, index) => {
+    // Keys near their quota or with an open circuit are only picked when every enabled key is
+    const allStats = Array.from(this.keyStats.values());
+    const enabled = allStats.filter((stats) => !this.disabledKeys.has(stats.keyIndex));
+    const closed = enabled.filter((stats) => !this.isCircuitOpen(stats.keyIndex));
+    const available = closed.filter((stats) => !this.isNearQuota(stats.keyIndex));
+    const candidates = [available, closed, enabled, allStats].find((stats) => stats.length > 0)!;
+
+    candidates.forEach((stats) => {
       if (stats.totalRequests < minRequests) {
         minRequests = stats.totalRequests;
-        selectedIndex = index;
+        selectedIndex = stats.keyIndex;
       }
     });
 
@@ -71,6 +308,11 @@ export class ApiKeyRotator {
       stats.successCount++;
       this.updateSuccessRate(stats);
     }
+    this.consecutiveFailures[keyIndex] = 0;
+    if (this.circuitOpenedAt.delete(keyIndex)) {
+      this.halfOpenTrials.delete(keyIndex);
+      this.onStateChange?.(keyIndex, 'recovered');
+    }
   }
 
   recordFailure(keyIndex: number): void {
@@ -79,6 +321,18 @@ export class ApiKeyRotator {
       stats.failureCount++;
       this.updateSuccessRate(stats);
     }
+    if (!this.circuitBreaker || stats === undefined) {
+      return;
+    }
+    this.consecutiveFailures[keyIndex]++;
+    const threshold = this.circuitBreaker.failureThreshold ?? DEFAULT_CIRCUIT_FAILURE_THRESHOLD;
+    // A failed trial reopens a half-open circuit right away
+    if (
+      this.halfOpenTrials.has(keyIndex) ||
+      (!this.circuitOpenedAt.has(keyIndex) && this.consecutiveFailures[keyIndex] >= threshold)
+    ) {
+      this.openCircuit(keyIndex);
+    }
   }
 
   private updateSuccessRate(stats: ApiKeyStats): void {
@@ -0,0 +1,30 @@
+import { appendFile } from 'fs/promises';
+import type { AuditEntry, AuditSink } from '../types/config';
+
+/**
+ * Appends each audit entry as one JSON line to a file, e.g. for a compliance trail.
+ * The file is only ever appended to; writes are serialized so lines never interleave.
+ *
+ * @example
+ * const client = new GemBack({ apiKey, auditSink: new JsonlAuditSink('./audit.jsonl') });
+ */
+export class JsonlAuditSink implements AuditSink {
+  private path: string;
+  private pending: Promise<void> = Promise.resolve();
+
+  constructor(path: string) {
+    this.path = path;
+  }
+
+  // Resolves once the entry is written; a failed write doesn't block later ones
+  record(entry: AuditEntry): Promise<void> {
+    const write = this.pending.then(() => appendFile(this.path, `${JSON.stringify(entry)}\n`));
+    this.pending = write.catch(() => {});
+    return write;
+  }
+
+  // Waits for every entry recorded so far to be written, e.g. before the process exits
+  flush(): Promise<void> {
+    return this.pending;
+  }
+}
@@ -0,0 +1,51 @@
+import type { GemBackOptions, DefaultGenerationConfig } from '../types/config';
+
+interface EnvSetting {
+  name: string;
+  key: keyof DefaultGenerationConfig;
+  min: number;
+  max?: number;
+  integer?: boolean;
+}
+
+const GENERATION_ENV_SETTINGS: EnvSetting[] = [
+  { name: 'GEMBACK_TEMPERATURE', key: 'temperature', min: 0, max: 2 },
+  { name: 'GEMBACK_TOP_P', key: 'topP', min: 0, max: 1 },
+  { name: 'GEMBACK_TOP_K', key: 'topK', min: 1, integer: true },
+  { name: 'GEMBACK_MAX_TOKENS', key: 'maxTokens', min: 1, integer: true },
+];
+
+/**
+ * Reads client options from environment variables, e.g. to tweak sampling during prompt
+ * experiments without code changes. GEMBACK_TEMPERATURE (0–2), GEMBACK_TOP_P (0–1),
+ * GEMBACK_TOP_K and GEMBACK_MAX_TOKENS (positive integers) become
+ * `defaultGenerationConfig`. Unset or empty variables are skipped; an invalid value
+ * throws, naming the variable.
+ *
+ * @example
+ * const client = new GemBack({ apiKey: process.env.GEMINI_API_KEY, ...configFromEnv() });
+ */
+export function configFromEnv(
+  env: Record<string, string | undefined> = process.env
+): Pick<GemBackOptions, 'defaultGenerationConfig'> {
+  const config: DefaultGenerationConfig = {};
+  for (const setting of GENERATION_ENV_SETTINGS) {
+    const raw = env[setting.name]?.trim();
+    if (raw) {
+      config[setting.key] = parseSetting(setting, raw);
+    }
+  }
+  return Object.keys(config).length > 0 ? { defaultGenerationConfig: config } : {};
+}
+
+function parseSetting({ name, min, max, integer }: EnvSetting, raw: string): number {
+  const value = Number(raw);
+  if (!Number.isFinite(value) || (integer && !Number.isInteger(value))) {
+    throw new Error(`${name} must be ${integer ? 'an integer' : 'a number'}, got "${raw}"`);
+  }
+  if (value < min || (max !== undefined && value > max)) {
+    const range = max !== undefined ? `between ${min} and ${max}` : `at least ${min}`;
+    throw new Error(`${name} must be ${range}, got ${value}`);
+  }
+  return value;
+}
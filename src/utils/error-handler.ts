@@ -1,9 +1,73 @@
+import type { RetryPolicy } from '../types/config';
+import {
+  EmptyResponseError,
+  IncompleteResponseError,
+  ResponseValidationError,
+} from '../types/errors';
+
 interface ErrorResponse {
   error?: {
     message?: string;
+    status?: string;
+    details?: Array<{ reason?: string }>;
+    errors?: Array<{ reason?: string }>;
   };
 }
 
+// Reason codes for keys whose billing / quota project is disabled or suspended
+const BILLING_REASONS = new Set([
+  'BILLING_DISABLED',
+  'BILLING_NOT_ACTIVE',
+  'CONSUMER_SUSPENDED',
+  'billingNotEnabled',
+  'accountDisabled',
+]);
+
+// gRPC status codes (google.rpc.Code) with their HTTP equivalents
+const GRPC_STATUSES: Record<string, { code: number; httpStatus: number }> = {
+  DEADLINE_EXCEEDED: { code: 4, httpStatus: 504 },
+  PERMISSION_DENIED: { code: 7, httpStatus: 403 },
+  RESOURCE_EXHAUSTED: { code: 8, httpStatus: 429 },
+  INTERNAL: { code: 13, httpStatus: 500 },
+  UNAVAILABLE: { code: 14, httpStatus: 503 },
+  UNAUTHENTICATED: { code: 16, httpStatus: 401 },
+};
+
+const GRPC_STATUS_PATTERN = new RegExp(`\\b(${Object.keys(GRPC_STATUSES).join('|')})\\b`);
+
+function isGrpcStatusName(value: unknown): value is string {
+  return typeof value === 'string' && Object.prototype.hasOwnProperty.call(GRPC_STATUSES, value);
+}
+
+/**
+ * Returns the gRPC status name (e.g. 'RESOURCE_EXHAUSTED') of an error, read from a
+ * string `status` property, a numeric gRPC `code` property, a JSON error body, or the
+ * message text.
+ */
+export function getGrpcStatus(error: Error): string | undefined {
+  const { code, status } = error as Error & { code?: unknown; status?: unknown };
+  if (isGrpcStatusName(status)) {
+    return status;
+  }
+  if (typeof code === 'number') {
+    const name = Object.keys(GRPC_STATUSES).find((key) => GRPC_STATUSES[key].code === code);
+    if (name) {
+      return name;
+    }
+  }
+
+  try {
+    const json = JSON.parse(error.message) as ErrorResponse;
+    if (isGrpcStatusName(json.error?.status)) {
+      return json.error.status;
+    }
+  } catch (e) {
+    // Not JSON, continue
+  }
+
+  return error.message.match(GRPC_STATUS_PATTERN)?.[1];
+}
+
 export function normalizeErrorMessage(error: Error): string {
   try {
     const json = JSON.parse(error.message) as ErrorResponse;
@@ -22,11 +86,19 @@ export function isRateLimitError(error: Error): boolean {
     message.includes('429') ||
     message.includes('rate limit') ||
     message.includes('quota exceeded') ||
-    message.includes('too many requests')
+    message.includes('too many requests') ||
+    getGrpcStatus(error) === 'RESOURCE_EXHAUSTED'
   );
 }
 
 export function isRetryableError(error: Error): boolean {
+  if (
+    error instanceof EmptyResponseError ||
+    error instanceof IncompleteResponseError ||
+    error instanceof ResponseValidationError
+  ) {
+    return true;
+  }
   const message = normalizeErrorMessage(error);
   return (
     message.includes('timeout') ||
@@ -34,7 +106,8 @@ export function isRetryableError(error: Error): boolean {
     message.includes('econnreset') ||
     message.includes('enotfound') ||
     message.includes('5') ||
-    isRateLimitError(error)
+    isRateLimitError(error) ||
+    ['UNAVAILABLE', 'INTERNAL', 'DEADLINE_EXCEEDED'].includes(getGrpcStatus(error) ?? '')
   );
 }
 
@@ -46,11 +119,60 @@ export function isAuthError(error: Error): boolean {
     message.includes('unauthorized') ||
     message.includes('forbidden') ||
     message.includes('invalid api key') ||
-    message.includes('api key not valid')
+    message.includes('api key not valid') ||
+    ['UNAUTHENTICATED', 'PERMISSION_DENIED'].includes(getGrpcStatus(error) ?? '')
   );
 }
 
+// Parses the JSON error body, which the SDK may prefix with the HTTP status line
+function parseErrorBody(error: Error): ErrorResponse | undefined {
+  const start = error.message.indexOf('{');
+  if (start === -1) {
+    return undefined;
+  }
+  try {
+    return JSON.parse(error.message.slice(start)) as ErrorResponse;
+  } catch (e) {
+    return undefined;
+  }
+}
+
+/**
+ * Whether the error says the key's billing or quota project is disabled, judged by the
+ * reason codes in the error details (`ErrorInfo.reason` or the legacy `errors[].reason`).
+ */
+export function isBillingError(error: Error): boolean {
+  const body = parseErrorBody(error)?.error;
+  const reasons = [...(body?.details ?? []), ...(body?.errors ?? [])].map(
+    (detail) => detail.reason
+  );
+  return reasons.some((reason) => reason !== undefined && BILLING_REASONS.has(reason));
+}
+
 export function getErrorStatusCode(error: Error): number | undefined {
+  // SDK ApiError carries the HTTP status as a number
+  const { status } = error as Error & { status?: unknown };
+  if (typeof status === 'number') {
+    return status;
+  }
+
   const match = error.message.match(/\b([45]\d{2})\b/);
-  return match ? parseInt(match[1], 10) : undefined;
+  if (match) {
+    return parseInt(match[1], 10);
+  }
+
+  const grpcStatus = getGrpcStatus(error);
+  return grpcStatus ? GRPC_STATUSES[grpcStatus].httpStatus : undefined;
 }
+
+/**
+ * Default retry policy: client errors (4xx other than 429) are never retryable because
+ * the request itself is at fault; anything else defers to `isRetryableError`.
+ */
+export const defaultRetryPolicy: RetryPolicy = (error) => {
+  const statusCode = getErrorStatusCode(error);
+  if (statusCode !== undefined && statusCode >= 400 && statusCode < 500 && statusCode !== 429) {
+    return false;
+  }
+  return isRetryableError(error);
+};
@@ -0,0 +1,61 @@
+import type { GeminiResponse, SafetyRating } from '../types/response';
+import { BlockedError } from '../types/errors';
+
+// Finish reasons that don't say why generation stopped, so the text may be cut short
+const INCOMPLETE_FINISH_REASONS = ['OTHER', 'FINISH_REASON_UNSPECIFIED'];
+
+// Finish reasons meaning the response was withheld by a content filter
+const BLOCKED_FINISH_REASONS = [
+  'SAFETY',
+  'BLOCKLIST',
+  'PROHIBITED_CONTENT',
+  'SPII',
+  'IMAGE_SAFETY',
+];
+
+/**
+ * Whether the response may be incomplete: the model stopped with an unspecified
+ * or `OTHER` finish reason rather than a clean stop.
+ */
+export function isIncompleteResponse(response: GeminiResponse): boolean {
+  return (
+    response.finishReason !== undefined &&
+    INCOMPLETE_FINISH_REASONS.includes(response.finishReason)
+  );
+}
+
+/**
+ * A BlockedError describing why the prompt (`promptFeedback.blockReason`) or the
+ * response (a filter finish reason) was blocked, or undefined if it wasn't.
+ */
+export function getBlockedError(response: GeminiResponse): BlockedError | undefined {
+  const promptBlockReason = response.promptFeedback?.blockReason;
+  if (promptBlockReason) {
+    return new BlockedError(
+      promptBlockReason,
+      'prompt',
+      triggeringRatings(response.promptFeedback?.safetyRatings),
+      response.model
+    );
+  }
+  if (response.finishReason && BLOCKED_FINISH_REASONS.includes(response.finishReason)) {
+    return new BlockedError(
+      response.finishReason,
+      'response',
+      triggeringRatings(response.safetyRatings),
+      response.model
+    );
+  }
+  return undefined;
+}
+
+// The ratings the API flagged as blocked; without any flag, those rated MEDIUM or HIGH
+function triggeringRatings(ratings: SafetyRating[] = []): SafetyRating[] {
+  const flagged = ratings.filter((rating) => rating.blocked);
+  if (flagged.length) {
+    return flagged;
+  }
+  return ratings.filter(
+    (rating) => rating.probability === 'MEDIUM' || rating.probability === 'HIGH'
+  );
+}
@@ -0,0 +1,68 @@
+const CODE_FENCE_PATTERN = /```(?:json)?\s*([\s\S]*?)\s*```/i;
+
+/**
+ * Applies basic repairs to model output that should be JSON:
+ * - unwraps a markdown code fence (```json ... ```)
+ * - drops prose before the first `{` / `[` and after the last `}` / `]`
+ * - removes trailing commas before `}` and `]` (outside strings)
+ */
+export function repairJson(text: string): string {
+  let json = text.trim();
+
+  const fenced = json.match(CODE_FENCE_PATTERN);
+  if (fenced) {
+    json = fenced[1];
+  }
+
+  const start = json.search(/[{[]/);
+  const end = Math.max(json.lastIndexOf('}'), json.lastIndexOf(']'));
+  if (start !== -1 && end > start) {
+    json = json.slice(start, end + 1);
+  }
+
+  return removeTrailingCommas(json);
+}
+
+/**
+ * Parses JSON, retrying with `repairJson` when the text is not valid as-is.
+ * Throws the original parse error if the repaired text is still invalid.
+ */
+export function parseJsonWithRepair(text: string): unknown {
+  try {
+    return JSON.parse(text);
+  } catch (error) {
+    try {
+      return JSON.parse(repairJson(text));
+    } catch {
+      throw error;
+    }
+  }
+}
+
+function removeTrailingCommas(json: string): string {
+  let result = '';
+  let inString = false;
+
+  for (let i = 0; i < json.length; i++) {
+    const char = json[i];
+
+    if (inString) {
+      result += char;
+      if (char === '\\') {
+        result += json[++i] ?? '';
+      } else if (char === '"') {
+        inString = false;
+      }
+      continue;
+    }
+
+    if (char === '"') {
+      inString = true;
+    } else if (char === ',' && /^\s*[}\]]/.test(json.slice(i + 1))) {
+      continue;
+    }
+    result += char;
+  }
+
+  return result;
+}
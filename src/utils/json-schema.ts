@@ -0,0 +1,150 @@
+import type { GenerateOptions, ResponseSchema } from '../types/config';
+
+export interface SchemaFromExampleOptions {
+  optional?: string[]; // Property names (at any depth) left out of `required`
+}
+
+type SchemaType = NonNullable<ResponseSchema['type']>;
+
+/**
+ * Builds a response schema from an example value, so a typed sample object can stand in
+ * for a hand-written schema:
+ * - strings → STRING, integers → INTEGER, other numbers → NUMBER, booleans → BOOLEAN
+ * - arrays → ARRAY, with `items` inferred from the first element (must be non-empty)
+ * - objects → OBJECT; keys become property names (in order) and are all required
+ *   unless listed in `options.optional`
+ * - null and undefined cannot be inferred and throw
+ */
+export function schemaFromExample(
+  example: unknown,
+  options: SchemaFromExampleOptions = {}
+): ResponseSchema {
+  return inferSchema(example, new Set(options.optional ?? []), '$');
+}
+
+function inferSchema(example: unknown, optional: Set<string>, path: string): ResponseSchema {
+  if (typeof example === 'string') {
+    return { type: 'STRING' as SchemaType };
+  }
+  if (typeof example === 'number') {
+    return { type: (Number.isInteger(example) ? 'INTEGER' : 'NUMBER') as SchemaType };
+  }
+  if (typeof example === 'boolean') {
+    return { type: 'BOOLEAN' as SchemaType };
+  }
+  if (Array.isArray(example)) {
+    if (example.length === 0) {
+      throw new Error(`Cannot infer the item type of empty array at ${path}`);
+    }
+    return {
+      type: 'ARRAY' as SchemaType,
+      items: inferSchema(example[0], optional, `${path}[0]`),
+    };
+  }
+  if (example !== null && typeof example === 'object') {
+    const keys = Object.keys(example);
+    const properties: Record<string, ResponseSchema> = {};
+    for (const key of keys) {
+      const value = (example as Record<string, unknown>)[key];
+      properties[key] = inferSchema(value, optional, `${path}.${key}`);
+    }
+    return {
+      type: 'OBJECT' as SchemaType,
+      properties,
+      required: keys.filter((key) => !optional.has(key)),
+      propertyOrdering: keys,
+    };
+  }
+
+  throw new Error(`Cannot infer a schema type for ${String(example)} at ${path}`);
+}
+
+/**
+ * Checks a parsed JSON value against a response schema and lists the violations, each
+ * prefixed with its path (e.g. `$.user.age: expected INTEGER, got string`). Covers
+ * `type`, `nullable`, `required`, `enum`, `properties` and `items`; other keywords are
+ * not checked. An empty list means the value conforms.
+ */
+export function validateAgainstSchema(
+  value: unknown,
+  schema: ResponseSchema,
+  path = '$'
+): string[] {
+  const type = schema.type as string | undefined;
+  if (value === null) {
+    return schema.nullable || !type || type === 'NULL' || type === 'TYPE_UNSPECIFIED'
+      ? []
+      : [`${path}: expected ${type}, got null`];
+  }
+  if (type && !matchesType(value, type)) {
+    return [`${path}: expected ${type}, got ${describeType(value)}`];
+  }
+
+  const violations: string[] = [];
+  if (schema.enum && typeof value === 'string' && !schema.enum.includes(value)) {
+    violations.push(`${path}: expected one of ${schema.enum.join(', ')}, got "${value}"`);
+  }
+  if (Array.isArray(value)) {
+    const items = schema.items;
+    if (items) {
+      value.forEach((item, index) => {
+        violations.push(...validateAgainstSchema(item, items, `${path}[${index}]`));
+      });
+    }
+  } else if (typeof value === 'object') {
+    const object = value as Record<string, unknown>;
+    for (const key of schema.required ?? []) {
+      if (object[key] === undefined) {
+        violations.push(`${path}.${key}: missing required field`);
+      }
+    }
+    for (const [key, property] of Object.entries(schema.properties ?? {})) {
+      if (object[key] !== undefined) {
+        violations.push(...validateAgainstSchema(object[key], property, `${path}.${key}`));
+      }
+    }
+  }
+  return violations;
+}
+
+function matchesType(value: unknown, type: string): boolean {
+  switch (type) {
+    case 'STRING':
+      return typeof value === 'string';
+    case 'NUMBER':
+      return typeof value === 'number';
+    case 'INTEGER':
+      return Number.isInteger(value);
+    case 'BOOLEAN':
+      return typeof value === 'boolean';
+    case 'ARRAY':
+      return Array.isArray(value);
+    case 'OBJECT':
+      return typeof value === 'object' && value !== null && !Array.isArray(value);
+    case 'NULL':
+      return value === null;
+    default:
+      return true;
+  }
+}
+
+function describeType(value: unknown): string {
+  return Array.isArray(value) ? 'array' : typeof value;
+}
+
+/**
+ * Request options for JSON mode: sets the JSON MIME type and, when an example is
+ * given, a schema inferred from it (see `schemaFromExample`).
+ *
+ * @example
+ * const res = await client.generate('Invent a user', jsonMode({ name: '', age: 0 }));
+ */
+export function jsonMode(
+  example?: unknown,
+  options?: SchemaFromExampleOptions
+): Pick<GenerateOptions, 'responseMimeType' | 'responseSchema'> {
+  return {
+    responseMimeType: 'application/json',
+    ...(example !== undefined && { responseSchema: schemaFromExample(example, options) }),
+  };
+}
@@ -0,0 +1,26 @@
+import { AsyncLocalStorage } from 'async_hooks';
+import type { GeminiModel } from '../types/models';
+
+// The API key and model of the attempt in progress, with the key masked
+export interface KeyInfo {
+  keyIndex: number | null; // Position in `apiKeys`; null in single-key mode
+  maskedKey: string; // e.g. '****abcd'
+  model: GeminiModel;
+}
+
+const keyContext = new AsyncLocalStorage<KeyInfo>();
+
+/**
+ * Runs one attempt with its key info available to `getCurrentKeyInfo()`.
+ */
+export function runWithKeyInfo<T>(info: KeyInfo, fn: () => Promise<T>): Promise<T> {
+  return keyContext.run(info, fn);
+}
+
+/**
+ * Returns the key info of the non-streaming attempt currently running, e.g. from a
+ * custom fetch or interceptor, or undefined when called outside a GemBack request.
+ */
+export function getCurrentKeyInfo(): KeyInfo | undefined {
+  return keyContext.getStore();
+}
@@ -0,0 +1,33 @@
+// A fence line (``` or ~~~, optionally with a language) opening or closing a code block
+const CODE_FENCE = /^[ \t]*(?:```|~~~)[^\n]*(?:\n|$)/m;
+
+/**
+ * Converts markdown to plain text for channels that can't render it (e.g. SMS):
+ * - headings, blockquote markers, list bullets and horizontal rules are removed
+ * - bold, italic, strikethrough and inline code keep their text without the markers
+ * - links become `text (url)`, images their alt text
+ * - code fences are dropped but the code inside them is kept verbatim
+ * Numbered list markers are kept, since the numbers carry meaning.
+ */
+export function stripMarkdown(text: string): string {
+  return text
+    .split(CODE_FENCE)
+    .map((segment, index) => (index % 2 === 1 ? segment : stripInline(segment)))
+    .join('');
+}
+
+function stripInline(text: string): string {
+  return text
+    .replace(/^[ \t]*([-*_])(?:[ \t]*\1){2,}[ \t]*$/gm, '')
+    .replace(/^[ \t]{0,3}#{1,6}[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$/gm, '$1')
+    .replace(/^[ \t]*>[ \t]?/gm, '')
+    .replace(/^([ \t]*)[-*+][ \t]+/gm, '$1')
+    .replace(/!\[([^\]]*)\]\([^)]*\)/g, '$1')
+    .replace(/\[([^\]]+)\]\(([^)\s]+)[^)]*\)/g, '$1 ($2)')
+    .replace(/`([^`\n]+)`/g, '$1')
+    .replace(/(\*\*|__)(?=\S)([^\n]*?\S)\1/g, '$2')
+    .replace(/~~(?=\S)([^\n]*?\S)~~/g, '$1')
+    .replace(/\*(?=\S)([^*\n]*?\S)\*/g, '$1')
+    // Underscores only mark emphasis at word boundaries, so snake_case survives
+    .replace(/(^|\W)_(?=\S)([^_\n]*?\S)_(?=\W|$)/g, '$1$2');
+}
@@ -0,0 +1,7 @@
+/**
+ * Masks an API key for display, keeping only the last 4 characters
+ * (e.g. `****abcd`). Keys too short to reveal any part of are fully masked.
+ */
+export function maskApiKey(key: string): string {
+  return key.length > 8 ? `****${key.slice(-4)}` : '****';
+}
@@ -0,0 +1,71 @@
+import { open } from 'fs/promises';
+import { extname } from 'path';
+
+const MIME_TYPES_BY_EXTENSION: Record<string, string> = {
+  '.png': 'image/png',
+  '.jpg': 'image/jpeg',
+  '.jpeg': 'image/jpeg',
+  '.gif': 'image/gif',
+  '.webp': 'image/webp',
+  '.heic': 'image/heic',
+  '.heif': 'image/heif',
+  '.pdf': 'application/pdf',
+  '.txt': 'text/plain',
+  '.md': 'text/markdown',
+  '.csv': 'text/csv',
+  '.html': 'text/html',
+  '.json': 'application/json',
+  '.mp3': 'audio/mp3',
+  '.wav': 'audio/wav',
+  '.ogg': 'audio/ogg',
+  '.flac': 'audio/flac',
+  '.mp4': 'video/mp4',
+  '.mov': 'video/mov',
+  '.webm': 'video/webm',
+};
+
+// Leading bytes of common formats, for files without a known extension
+const MAGIC_NUMBERS: Array<{ bytes: number[]; mimeType: string }> = [
+  { bytes: [0x89, 0x50, 0x4e, 0x47], mimeType: 'image/png' },
+  { bytes: [0xff, 0xd8, 0xff], mimeType: 'image/jpeg' },
+  { bytes: [0x47, 0x49, 0x46, 0x38], mimeType: 'image/gif' },
+  { bytes: [0x25, 0x50, 0x44, 0x46], mimeType: 'application/pdf' },
+];
+
+/**
+ * Detects a file's MIME type from its extension, then from its leading bytes.
+ * Falls back to 'application/octet-stream'.
+ */
+export function detectMimeType(path: string, header?: Uint8Array): string {
+  const byExtension = MIME_TYPES_BY_EXTENSION[extname(path).toLowerCase()];
+  if (byExtension) {
+    return byExtension;
+  }
+
+  if (header) {
+    const match = MAGIC_NUMBERS.find(({ bytes }) =>
+      bytes.every((byte, index) => header[index] === byte)
+    );
+    if (match) {
+      return match.mimeType;
+    }
+  }
+  return 'application/octet-stream';
+}
+
+// Reads the first bytes of a file, enough to recognise its format
+export async function readHeader(path: string): Promise<Uint8Array> {
+  const file = await open(path, 'r');
+  try {
+    const header = Buffer.alloc(16);
+    const { bytesRead } = await file.read(header, 0, header.length, 0);
+    return header.subarray(0, bytesRead);
+  } finally {
+    await file.close();
+  }
+}
+
+// Size of `bytes` bytes once base64-encoded (with padding), as sent for inline data
+export function base64Length(bytes: number): number {
+  return Math.ceil(bytes / 3) * 4;
+}
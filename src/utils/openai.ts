@@ -0,0 +1,158 @@
+import { randomUUID } from 'crypto';
+import type { GeminiResponse } from '../types/response';
+import type { ChatMessage, Content, FunctionCall } from '../types/config';
+
+export type OpenAIFinishReason = 'stop' | 'length' | 'tool_calls' | 'content_filter';
+
+export interface OpenAIToolCall {
+  id: string;
+  type: 'function';
+  function: { name: string; arguments: string }; // `arguments` is JSON-encoded
+}
+
+export interface OpenAIChatCompletionChoice {
+  index: number;
+  message: {
+    role: 'assistant';
+    content: string | null; // null when the reply is only tool calls
+    tool_calls?: OpenAIToolCall[];
+  };
+  finish_reason: OpenAIFinishReason;
+  logprobs: null;
+}
+
+// The subset of OpenAI's ChatCompletion object that a Gemini response can fill in
+export interface OpenAIChatCompletion {
+  id: string;
+  object: 'chat.completion';
+  created: number; // Unix seconds
+  model: string;
+  choices: OpenAIChatCompletionChoice[];
+  usage?: {
+    prompt_tokens: number;
+    completion_tokens: number;
+    total_tokens: number;
+  };
+}
+
+export interface ToOpenAIResponseOptions {
+  id?: string; // Default: 'chatcmpl-' + a random UUID
+  created?: number; // Default: now
+}
+
+// Gemini finish reasons with an OpenAI counterpart; any other reason maps to 'stop'
+const FINISH_REASONS: Record<string, OpenAIFinishReason> = {
+  STOP: 'stop',
+  MAX_TOKENS: 'length',
+  SAFETY: 'content_filter',
+  RECITATION: 'content_filter',
+  BLOCKLIST: 'content_filter',
+  PROHIBITED_CONTENT: 'content_filter',
+  SPII: 'content_filter',
+  IMAGE_SAFETY: 'content_filter',
+};
+
+/**
+ * Maps a Gemini finish reason to OpenAI's `finish_reason`: STOP → 'stop',
+ * MAX_TOKENS → 'length', safety and recitation blocks → 'content_filter', anything
+ * else (OTHER, unspecified, missing) → 'stop'. A reply with tool calls is 'tool_calls'.
+ */
+export function toOpenAIFinishReason(
+  finishReason: string | undefined,
+  hasToolCalls = false
+): OpenAIFinishReason {
+  if (hasToolCalls) {
+    return 'tool_calls';
+  }
+  return (finishReason && FINISH_REASONS[finishReason]) || 'stop';
+}
+
+/**
+ * Converts a response to the shape of an OpenAI ChatCompletion, for code migrating
+ * off the OpenAI SDK: text → `choices[i].message.content`, function calls →
+ * `tool_calls`, finish reason (see `toOpenAIFinishReason`) and usage. With
+ * `candidateCount > 1`, every candidate becomes a choice.
+ *
+ * @example
+ * res.json(toOpenAIResponse(await client.generate(prompt)));
+ */
+export function toOpenAIResponse(
+  response: GeminiResponse,
+  options: ToOpenAIResponseOptions = {}
+): OpenAIChatCompletion {
+  const toolCalls = response.functionCalls?.map(toOpenAIToolCall);
+  const choices: OpenAIChatCompletionChoice[] = response.candidates
+    ? response.candidates.map((candidate, index) => ({
+        index,
+        message: { role: 'assistant', content: candidate.text },
+        finish_reason: toOpenAIFinishReason(candidate.finishReason),
+        logprobs: null,
+      }))
+    : [
+        {
+          index: 0,
+          message: {
+            role: 'assistant',
+            content: response.isToolCall ? null : response.text,
+            ...(toolCalls && { tool_calls: toolCalls }),
+          },
+          finish_reason: toOpenAIFinishReason(response.finishReason, Boolean(toolCalls)),
+          logprobs: null,
+        },
+      ];
+
+  return {
+    id: options.id ?? `chatcmpl-${randomUUID()}`,
+    object: 'chat.completion',
+    created: options.created ?? Math.floor(Date.now() / 1000),
+    model: response.model,
+    choices,
+    ...(response.usage && {
+      usage: {
+        prompt_tokens: response.usage.promptTokens,
+        completion_tokens: response.usage.completionTokens,
+        total_tokens: response.usage.totalTokens,
+      },
+    }),
+  };
+}
+
+/**
+ * Translates OpenAI-style chat messages into Gemini contents: `system` messages are
+ * joined into the system instruction, `user` stays 'user' and `assistant` becomes
+ * 'model'. Consecutive messages with the same role are merged into one turn.
+ */
+export function fromOpenAIMessages(messages: ChatMessage[]): {
+  contents: Content[];
+  systemInstruction?: string;
+} {
+  const system = messages.filter((message) => message.role === 'system');
+  const contents: Content[] = [];
+  for (const message of messages) {
+    if (message.role === 'system') {
+      continue;
+    }
+    const role = message.role === 'assistant' ? 'model' : 'user';
+    const previous = contents[contents.length - 1];
+    if (previous?.role === role) {
+      previous.parts.push({ text: message.content });
+    } else {
+      contents.push({ role, parts: [{ text: message.content }] });
+    }
+  }
+
+  return {
+    contents,
+    ...(system.length > 0 && {
+      systemInstruction: system.map((message) => message.content).join('\n\n'),
+    }),
+  };
+}
+
+function toOpenAIToolCall(call: FunctionCall, index: number): OpenAIToolCall {
+  return {
+    id: call.id ?? `call_${index}`,
+    type: 'function',
+    function: { name: call.name ?? '', arguments: JSON.stringify(call.args ?? {}) },
+  };
+}
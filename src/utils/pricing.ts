@@ -0,0 +1,16 @@
+import type { ModelPricing } from '../types/config';
+import type { TokenUsage } from '../types/response';
+
+/**
+ * Computes a response's cost in USD from its token usage and the model's price per
+ * million tokens. Returns undefined when either is unknown.
+ */
+export function estimateCost(
+  usage: TokenUsage | undefined,
+  pricing: ModelPricing | undefined
+): number | undefined {
+  if (!usage || !pricing) {
+    return undefined;
+  }
+  return (usage.promptTokens * pricing.input + usage.completionTokens * pricing.output) / 1e6;
+}
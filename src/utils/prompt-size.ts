@@ -0,0 +1,32 @@
+import type { Content } from '../types/config';
+
+/**
+ * Size of the prompt contents in bytes: UTF-8 text plus the base64 length of inline data.
+ * File references (`fileData`) point at already-uploaded files and are not counted.
+ */
+export function getContentsByteLength(contents: Content[]): number {
+  let total = 0;
+  for (const content of contents) {
+    for (const part of content.parts) {
+      if ('text' in part) {
+        total += Buffer.byteLength(part.text, 'utf8');
+      } else if ('inlineData' in part) {
+        total += part.inlineData.data.length;
+      }
+    }
+  }
+  return total;
+}
+
+// The text of every text part; media parts are skipped
+export function getContentsText(contents: Content[]): string {
+  return contents
+    .flatMap((content) => content.parts)
+    .map((part) => ('text' in part ? part.text : ''))
+    .join('');
+}
+
+// Rough token count (about 4 characters per token) for text the API has not counted
+export function estimateTokens(text: string): number {
+  return Math.ceil(text.length / 4);
+}
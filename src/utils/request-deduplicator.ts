@@ -0,0 +1,124 @@
+import { createHash } from 'crypto';
+
+/**
+ * Shares one execution between requests with the same key.
+ * Concurrent duplicates join the in-flight promise (singleflight), and
+ * successful results are replayed for `ttl` ms after completion.
+ * Failures are never remembered, so a failed request can be retried with the same key.
+ */
+export class RequestDeduplicator<T> {
+  private ttl: number;
+  private inFlight: Map<string, Promise<T>> = new Map();
+  private completed: Map<string, { value: T; expiresAt: number }> = new Map();
+
+  constructor(ttl: number) {
+    this.ttl = ttl;
+  }
+
+  run(key: string, fn: () => Promise<T>): Promise<T> {
+    this.pruneExpired();
+
+    const cached = this.completed.get(key);
+    if (cached) {
+      return Promise.resolve(cached.value);
+    }
+
+    const pending = this.inFlight.get(key);
+    if (pending) {
+      return pending;
+    }
+
+    const promise = fn()
+      .then((value) => {
+        if (this.ttl > 0) {
+          this.completed.set(key, { value, expiresAt: Date.now() + this.ttl });
+        }
+        return value;
+      })
+      .finally(() => {
+        this.inFlight.delete(key);
+      });
+
+    this.inFlight.set(key, promise);
+    return promise;
+  }
+
+  has(key: string): boolean {
+    this.pruneExpired();
+    return this.inFlight.has(key) || this.completed.has(key);
+  }
+
+  clear(): void {
+    this.inFlight.clear();
+    this.completed.clear();
+  }
+
+  private pruneExpired(): void {
+    const now = Date.now();
+    for (const [key, entry] of this.completed) {
+      if (entry.expiresAt <= now) {
+        this.completed.delete(key);
+      }
+    }
+  }
+}
+
+/**
+ * Fingerprints a request so identical ones can share a result. Object keys are sorted,
+ * so option order doesn't matter; `deadline` is left out since it is an absolute time
+ * that differs between otherwise identical calls. Returns undefined when the request holds
+ * values JSON can't capture (callbacks, writers, abort signals, ...), since two requests
+ * that only differ there would otherwise share a result.
+ */
+export function fingerprintRequest(...request: unknown[]): string | undefined {
+  if (!isPlainData(request)) {
+    return undefined;
+  }
+  const json = JSON.stringify(request, (key, value: unknown) => {
+    if (key === 'deadline') {
+      return undefined;
+    }
+    if (value !== null && typeof value === 'object' && !Array.isArray(value)) {
+      const entries = Object.entries(value as Record<string, unknown>);
+      return Object.fromEntries(entries.sort(([a], [b]) => (a < b ? -1 : a > b ? 1 : 0)));
+    }
+    return value;
+  });
+  return createHash('sha256').update(json).digest('hex');
+}
+
+/**
+ * Copies arrays and plain objects all the way down, so callers sharing a result can't
+ * see each other's changes. Other objects (e.g. a spill writer) are kept as they are.
+ */
+export function copyPlainData<T>(value: T): T {
+  if (Array.isArray(value)) {
+    return value.map(copyPlainData) as T;
+  }
+  if (isPlainObject(value)) {
+    const entries = Object.entries(value).map(([key, item]) => [key, copyPlainData(item)]);
+    return Object.fromEntries(entries) as T;
+  }
+  return value;
+}
+
+function isPlainData(value: unknown): boolean {
+  if (typeof value === 'function') {
+    return false;
+  }
+  if (value === null || typeof value !== 'object') {
+    return true;
+  }
+  if (Array.isArray(value)) {
+    return value.every(isPlainData);
+  }
+  return isPlainObject(value) && Object.values(value).every(isPlainData);
+}
+
+function isPlainObject(value: unknown): value is Record<string, unknown> {
+  if (value === null || typeof value !== 'object') {
+    return false;
+  }
+  const prototype = Object.getPrototypeOf(value) as unknown;
+  return prototype === Object.prototype || prototype === null;
+}
@@ -1,24 +1,81 @@
+// 'full': uniform in [0, backoff]; 'equal': uniform in [backoff / 2, backoff]
+export type RetryJitter = 'none' | 'full' | 'equal';
+
 export interface RetryOptions {
   maxRetries: number;
   delay: number;
   shouldRetry?: (error: Error) => boolean;
+  jitter?: RetryJitter;
+  random?: () => number; // Returns [0, 1); defaults to Math.random
+  deadline?: number; // Epoch ms; backoffs that would end past it are skipped
+  onDeadline?: (delay: number, remaining: number) => void; // Called before giving up on a backoff
+  backoffFor?: (error: Error) => Pick<RetryOptions, 'delay' | 'jitter'>; // Per-error backoff profile
+  maxRetriesFor?: (error: Error) => number | undefined; // Per-error limit; undefined: maxRetries
+  signal?: AbortSignal; // Once aborted, no further attempt is made and backoffs end early
+}
+
+// Resolves after `ms`, or as soon as `signal` aborts; callers check the signal afterwards
+export async function sleep(ms: number, signal?: AbortSignal): Promise<void> {
+  return new Promise((resolve) => {
+    if (signal?.aborted) {
+      resolve();
+      return;
+    }
+    const done = () => {
+      clearTimeout(timer);
+      signal?.removeEventListener('abort', done);
+      resolve();
+    };
+    const timer = setTimeout(done, ms);
+    signal?.addEventListener('abort', done, { once: true });
+  });
+}
+
+/**
+ * Deterministic PRNG (mulberry32) for reproducible jitter in tests.
+ */
+export function createSeededRandom(seed: number): () => number {
+  let state = seed >>> 0;
+  return () => {
+    state = (state + 0x6d2b79f5) >>> 0;
+    let t = state;
+    t = Math.imul(t ^ (t >>> 15), t | 1);
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61);
+    return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+  };
 }
 
-export async function sleep(ms: number): Promise<void> {
-  return new Promise((resolve) => setTimeout(resolve, ms));
+export function getBackoffDelay(
+  attempt: number,
+  options: Pick<RetryOptions, 'delay' | 'jitter' | 'random'>
+): number {
+  const backoff = options.delay * Math.pow(2, attempt);
+  const random = options.random ?? Math.random;
+
+  switch (options.jitter) {
+    case 'full':
+      return random() * backoff;
+    case 'equal':
+      return backoff / 2 + random() * (backoff / 2);
+    default:
+      return backoff;
+  }
 }
 
 export async function retryWithBackoff<T>(fn: () => Promise<T>, options: RetryOptions): Promise<T> {
-  const { maxRetries, delay, shouldRetry } = options;
-  let lastError: Error;
+  const { maxRetries, shouldRetry } = options;
 
-  for (let attempt = 0; attempt <= maxRetries; attempt++) {
+  // Retries so far count toward the limit of whichever error occurs next
+  for (let attempt = 0; ; attempt++) {
+    if (options.signal?.aborted) {
+      throw new Error('Request aborted');
+    }
     try {
       return await fn();
     } catch (error) {
-      lastError = error as Error;
+      const lastError = error as Error;
 
-      if (attempt === maxRetries) {
+      if (attempt >= (options.maxRetriesFor?.(lastError) ?? maxRetries)) {
         throw lastError;
       }
 
@@ -26,10 +83,20 @@ export async function retryWithBackoff<T>(fn: () => Promise<T>, options: RetryOp
         throw lastError;
       }
 
-      const backoffDelay = delay * Math.pow(2, attempt);
-      await sleep(backoffDelay);
+      const delay = getBackoffDelay(attempt, {
+        ...options,
+        ...options.backoffFor?.(lastError),
+      });
+      if (options.deadline !== undefined) {
+        // Sleeping past the deadline guarantees failure, so surface the error now
+        const remaining = options.deadline - Date.now();
+        if (delay > remaining) {
+          options.onDeadline?.(delay, Math.max(remaining, 0));
+          throw lastError;
+        }
+      }
+
+      await sleep(delay, options.signal);
     }
   }
-
-  throw lastError!;
 }
@@ -0,0 +1,11 @@
+// Byte order marks and C0/C1 control characters other than tab, newline and carriage return
+// eslint-disable-next-line no-control-regex
+const UNWANTED_CHARACTERS = /[\uFEFF\u0000-\u0008\u000B\u000C\u000E-\u001F\u007F-\u009F]/g;
+
+/**
+ * Removes byte order marks and non-printable control characters, keeping whitespace
+ * (tab, newline, carriage return), so the text is safe to parse as JSON or write to a file.
+ */
+export function sanitizeText(text: string): string {
+  return text.replace(UNWANTED_CHARACTERS, '');
+}
@@ -0,0 +1,47 @@
+/**
+ * Caps how many callers hold a slot at once; the rest wait in FIFO order.
+ * A limit of 0 means unlimited.
+ */
+export class Semaphore {
+  private limit: number;
+  private active = 0;
+  private waiters: Array<() => void> = [];
+
+  constructor(limit: number) {
+    this.limit = limit;
+  }
+
+  // Resolves once a slot is free; rejects without taking one if `signal` aborts first
+  acquire(signal?: AbortSignal): Promise<void> {
+    if (this.limit <= 0 || this.active < this.limit) {
+      this.active++;
+      return Promise.resolve();
+    }
+    if (signal?.aborted) {
+      return Promise.reject(new Error('Request aborted'));
+    }
+
+    return new Promise((resolve, reject) => {
+      const grant = () => {
+        signal?.removeEventListener('abort', onAbort);
+        resolve();
+      };
+      const onAbort = () => {
+        this.waiters = this.waiters.filter((waiter) => waiter !== grant);
+        reject(new Error('Request aborted'));
+      };
+      this.waiters.push(grant);
+      signal?.addEventListener('abort', onAbort, { once: true });
+    });
+  }
+
+  // Hands the slot straight to the next waiter, if any
+  release(): void {
+    const next = this.waiters.shift();
+    if (next) {
+      next();
+    } else {
+      this.active--;
+    }
+  }
+}
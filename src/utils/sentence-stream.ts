@@ -0,0 +1,105 @@
+import type { StreamChunk } from '../types/response';
+
+export interface SentenceStreamOptions {
+  delimiters?: string[]; // Sentence-ending characters (default: . ! ? and their CJK forms)
+  abbreviations?: string[]; // Words ending in '.' that don't end a sentence (case-insensitive)
+}
+
+const DEFAULT_DELIMITERS = ['.', '!', '?', '。', '！', '？'];
+const DEFAULT_ABBREVIATIONS = [
+  'mr.',
+  'mrs.',
+  'ms.',
+  'dr.',
+  'prof.',
+  'sr.',
+  'jr.',
+  'st.',
+  'vs.',
+  'e.g.',
+  'i.e.',
+];
+
+// Closing quotes and brackets that stay with the sentence they end
+const CLOSERS = new Set(['"', "'", '”', '’', ')', ']', '」', '』']);
+
+/**
+ * Re-chunks a text stream into whole sentences, e.g. for text-to-speech. Text is
+ * buffered until a delimiter followed by whitespace (or any full-width delimiter such
+ * as '。', which needs none); the remainder is flushed when the stream completes.
+ * Joining the emitted chunks reproduces the original text.
+ *
+ * @example
+ * for await (const chunk of sentenceStream(client.generateStream(prompt))) {
+ *   await speak(chunk.text);
+ * }
+ */
+export async function* sentenceStream(
+  stream: AsyncIterable<StreamChunk>,
+  options: SentenceStreamOptions = {}
+): AsyncGenerator<StreamChunk> {
+  const delimiters = new Set(options.delimiters ?? DEFAULT_DELIMITERS);
+  const abbreviations = new Set(
+    (options.abbreviations ?? DEFAULT_ABBREVIATIONS).map((word) => word.toLowerCase())
+  );
+
+  let buffer = '';
+  for await (const chunk of stream) {
+    if (chunk.isComplete) {
+      if (buffer) {
+        yield { text: buffer, model: chunk.model, isComplete: false };
+        buffer = '';
+      }
+      yield chunk;
+      continue;
+    }
+
+    buffer += chunk.text;
+    let end: number | undefined;
+    while ((end = findSentenceEnd(buffer, delimiters, abbreviations)) !== undefined) {
+      yield { text: buffer.slice(0, end), model: chunk.model, isComplete: false };
+      buffer = buffer.slice(end);
+    }
+  }
+}
+
+// Index just past the first complete sentence (and its trailing whitespace), if any
+function findSentenceEnd(
+  text: string,
+  delimiters: Set<string>,
+  abbreviations: Set<string>
+): number | undefined {
+  for (let i = 0; i < text.length; i++) {
+    if (!delimiters.has(text[i])) {
+      continue;
+    }
+
+    let end = i + 1;
+    while (end < text.length && (delimiters.has(text[end]) || CLOSERS.has(text[end]))) {
+      end++;
+    }
+
+    // Full-width delimiters (CJK) end a sentence without a following space
+    if (text.charCodeAt(i) >= 0x3000) {
+      return end;
+    }
+    if (end === text.length) {
+      return undefined; // Wait for the next chunk to see what follows
+    }
+    if (!/\s/.test(text[end])) {
+      i = end - 1; // e.g. a decimal point or a URL
+      continue;
+    }
+
+    const word = text.slice(0, i + 1).split(/\s/).pop()!.toLowerCase();
+    if (text[i] === '.' && abbreviations.has(word)) {
+      continue;
+    }
+
+    while (end < text.length && /\s/.test(text[end])) {
+      end++;
+    }
+    return end;
+  }
+  return undefined;
+}
@@ -0,0 +1,23 @@
+import type { Content } from '../types/config';
+
+/**
+ * Adds a "Respond in {language}." instruction after any existing system instruction:
+ * a string instruction is extended and a Content instruction gets an extra part.
+ */
+export function composeSystemInstruction(
+  systemInstruction: string | Content | undefined,
+  responseLanguage: string | undefined
+): string | Content | undefined {
+  if (!responseLanguage) {
+    return systemInstruction;
+  }
+
+  const instruction = `Respond in ${responseLanguage}.`;
+  if (!systemInstruction) {
+    return instruction;
+  }
+  if (typeof systemInstruction === 'string') {
+    return `${systemInstruction}\n\n${instruction}`;
+  }
+  return { ...systemInstruction, parts: [...systemInstruction.parts, { text: instruction }] };
+}
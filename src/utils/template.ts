@@ -0,0 +1,31 @@
+const PLACEHOLDER_PATTERN = /\{\{\s*([\w.]+)\s*\}\}/g;
+
+function readPath(data: Record<string, unknown>, path: string): unknown {
+  let current: unknown = data;
+  for (const key of path.split('.')) {
+    if (
+      current === null ||
+      typeof current !== 'object' ||
+      !Object.prototype.hasOwnProperty.call(current, key)
+    ) {
+      return undefined;
+    }
+    current = (current as Record<string, unknown>)[key];
+  }
+  return current;
+}
+
+/**
+ * Renders a prompt template by replacing `{{name}}` placeholders with values from `data`.
+ * Dotted paths (`{{user.name}}`) read nested properties and objects are inserted as JSON.
+ * Throws if a placeholder has no value, so incomplete prompts are never sent.
+ */
+export function renderTemplate(template: string, data: Record<string, unknown>): string {
+  return template.replace(PLACEHOLDER_PATTERN, (_, path: string) => {
+    const value = readPath(data, path);
+    if (value === undefined || value === null) {
+      throw new Error(`Missing value for template variable "${path}"`);
+    }
+    return typeof value === 'object' ? JSON.stringify(value) : String(value);
+  });
+}
@@ -1,5 +1,5 @@
-import { describe, it, expect, beforeEach } from 'vitest';
-import { ApiKeyRotator } from '../../src/utils/api-key-rotator';
+import { describe, it, expect, beforeEach, vi } from 'vitest';
+import { ApiKeyRotator, hashToKeyIndex } from '../../src/utils/api-key-rotator';
 
 describe('ApiKeyRotator', () => {
   describe('constructor', () => {
@@ -171,4 +171,201 @@ describe('ApiKeyRotator', () => {
       expect(rotator.getKeyByIndex(5)).toBeUndefined();
     });
   });
+
+  describe('soft token quota', () => {
+    it('should skip a key once it nears its quota', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2'], 'round-robin', {
+        dailyTokens: 1000,
+      });
+
+      rotator.recordTokens(0, 950);
+
+      expect(rotator.isNearQuota(0)).toBe(true);
+      expect(rotator.getNextKey().index).toBe(1);
+      expect(rotator.getNextKey().index).toBe(1);
+    });
+
+    it('should support per-key quotas with the least-used strategy', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2', 'key3'], 'least-used', {
+        dailyTokens: [100, 10000, 10000],
+        threshold: 0.5,
+      });
+
+      rotator.recordTokens(0, 60);
+      rotator.recordTokens(1, 60);
+
+      expect(rotator.getNextKey().index).toBe(1);
+      expect(rotator.getNextKey().index).toBe(2);
+      expect(rotator.getNextKey().index).toBe(1);
+    });
+
+    it('should fall back to normal rotation when every key is near its quota', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2'], 'round-robin', {
+        dailyTokens: 100,
+      });
+
+      rotator.recordTokens(0, 100);
+      rotator.recordTokens(1, 100);
+
+      expect([rotator.getNextKey().index, rotator.getNextKey().index]).toEqual([0, 1]);
+    });
+
+    it('should reset usage when the window elapses', () => {
+      let now = 0;
+      const rotator = new ApiKeyRotator(['key1', 'key2'], 'round-robin', {
+        dailyTokens: 1000,
+        resetIntervalMs: 60000,
+        now: () => now,
+      });
+
+      rotator.recordTokens(0, 1000);
+      expect(rotator.isNearQuota(0)).toBe(true);
+
+      now = 60000;
+      expect(rotator.isNearQuota(0)).toBe(false);
+      expect(rotator.getNextKey().index).toBe(0);
+    });
+
+    it('should report the tokens left before each quota', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2', 'key3'], 'round-robin', {
+        dailyTokens: [1000, 500],
+      });
+
+      rotator.recordTokens(0, 300);
+      rotator.recordTokens(1, 800);
+
+      expect(rotator.getQuotaHeadroom()).toEqual([700, 0, undefined]);
+    });
+  });
+
+  describe('getKeyFrom', () => {
+    it('should start from the given index without advancing the rotation', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2', 'key3']);
+
+      expect(rotator.getKeyFrom(2)).toEqual({ key: 'key3', index: 2 });
+      expect(rotator.getNextKey().index).toBe(0);
+      expect(rotator.getStats()[2].totalRequests).toBe(1);
+    });
+
+    it('should map equal seeds to the same index', () => {
+      expect(hashToKeyIndex('tenant-a', 5)).toBe(hashToKeyIndex('tenant-a', 5));
+      expect(hashToKeyIndex('tenant-a', 5)).toBeLessThan(5);
+    });
+  });
+
+  describe('circuit breaker', () => {
+    it('should open, half-open and recover a failing key', () => {
+      let now = 0;
+      const onStateChange = vi.fn();
+      const rotator = new ApiKeyRotator(
+        ['key1', 'key2'],
+        'round-robin',
+        undefined,
+        { failureThreshold: 2, resetTimeoutMs: 30000, now: () => now },
+        onStateChange
+      );
+
+      rotator.recordFailure(0);
+      expect(onStateChange).not.toHaveBeenCalled();
+      rotator.recordFailure(0);
+      expect(onStateChange).toHaveBeenLastCalledWith(0, 'circuit-open');
+      expect([rotator.getNextKey().index, rotator.getNextKey().index]).toEqual([1, 1]);
+
+      now = 30000;
+      expect(rotator.getNextKey().index).toBe(0);
+      expect(onStateChange).toHaveBeenLastCalledWith(0, 'circuit-half-open');
+
+      rotator.recordSuccess(0);
+      expect(onStateChange).toHaveBeenLastCalledWith(0, 'recovered');
+      expect(rotator.isCircuitOpen(0)).toBe(false);
+    });
+
+    it('should reopen the circuit when the half-open trial fails', () => {
+      let now = 0;
+      const onStateChange = vi.fn();
+      const rotator = new ApiKeyRotator(
+        ['key1', 'key2'],
+        'round-robin',
+        undefined,
+        { failureThreshold: 1, resetTimeoutMs: 1000, now: () => now },
+        onStateChange
+      );
+
+      rotator.recordFailure(0);
+      now = 1000;
+      expect(rotator.getNextKey().index).toBe(0);
+      rotator.recordFailure(0);
+
+      expect(onStateChange.mock.calls.map(([, state]) => state)).toEqual([
+        'circuit-open',
+        'circuit-half-open',
+        'circuit-open',
+      ]);
+      expect(rotator.isCircuitOpen(0)).toBe(true);
+    });
+
+    it('should let a single trial through a half-open circuit', () => {
+      let now = 0;
+      const onStateChange = vi.fn();
+      const rotator = new ApiKeyRotator(
+        ['key1', 'key2'],
+        'round-robin',
+        undefined,
+        { failureThreshold: 1, resetTimeoutMs: 1000, now: () => now },
+        onStateChange
+      );
+
+      rotator.recordFailure(0);
+      now = 1000;
+      // Querying the circuit does not move it to half-open
+      expect(rotator.isCircuitOpen(0)).toBe(false);
+      expect(onStateChange).toHaveBeenCalledTimes(1);
+
+      expect(rotator.getNextKey().index).toBe(0);
+      expect(onStateChange).toHaveBeenLastCalledWith(0, 'circuit-half-open');
+      // While the trial is in flight, other requests skip the key
+      expect(rotator.isCircuitOpen(0)).toBe(true);
+      expect([rotator.getNextKey().index, rotator.getNextKey().index]).toEqual([1, 1]);
+    });
+
+    it('should not track failures without circuit breaker options', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2']);
+
+      for (let i = 0; i < 10; i++) {
+        rotator.recordFailure(0);
+      }
+
+      expect(rotator.isCircuitOpen(0)).toBe(false);
+    });
+  });
+
+  describe('pin', () => {
+    it('should select only the pinned key until unpinned', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2', 'key3']);
+
+      rotator.pin(1);
+      expect([rotator.getNextKey(), rotator.getNextKey(), rotator.getKeyFrom(2)]).toEqual([
+        { key: 'key2', index: 1 },
+        { key: 'key2', index: 1 },
+        { key: 'key2', index: 1 },
+      ]);
+      expect(rotator.getPinnedIndex()).toBe(1);
+
+      rotator.unpin();
+      expect(rotator.getNextKey().index).toBe(0);
+      expect(rotator.getNextKey().index).toBe(1);
+    });
+  });
+
+  describe('peekNextIndex', () => {
+    it('should report the next index without advancing or counting usage', () => {
+      const rotator = new ApiKeyRotator(['key1', 'key2', 'key3']);
+
+      expect(rotator.peekNextIndex()).toBe(0);
+      expect(rotator.peekNextIndex()).toBe(0);
+      expect(rotator.getStats()[0].totalRequests).toBe(0);
+      expect(rotator.getNextKey().index).toBe(0);
+      expect(rotator.peekNextIndex()).toBe(1);
+    });
+  });
 });
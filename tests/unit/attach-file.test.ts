@@ -0,0 +1,95 @@
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
+import { mkdtemp, writeFile, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { detectMimeType } from '../../src/utils/mime';
+
+vi.mock('../../src/client/GeminiClient');
+
+const PNG_HEADER = Buffer.from([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a]);
+
+describe('attachFile', () => {
+  let mockGeminiClient: any;
+  let dir: string;
+
+  beforeEach(async () => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+      uploadFile: vi.fn().mockResolvedValue({
+        mimeType: 'image/png',
+        fileUri: 'https://generativelanguage.googleapis.com/v1beta/files/abc',
+      }),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+    dir = await mkdtemp(join(tmpdir(), 'gemback-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should inline small files as base64', async () => {
+    const path = join(dir, 'notes.txt');
+    await writeFile(path, 'hello');
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const part = await client.attachFile(path);
+
+    expect(part).toEqual({
+      inlineData: { mimeType: 'text/plain', data: Buffer.from('hello').toString('base64') },
+    });
+    expect(mockGeminiClient.uploadFile).not.toHaveBeenCalled();
+  });
+
+  it('should upload files above the inline limit', async () => {
+    const path = join(dir, 'image');
+    await writeFile(path, Buffer.concat([PNG_HEADER, Buffer.alloc(64)]));
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const part = await client.attachFile(path, { inlineLimitBytes: 16 });
+
+    expect(mockGeminiClient.uploadFile).toHaveBeenCalledWith(path, 'image/png', 'test-key');
+    expect(part).toEqual({
+      fileData: {
+        mimeType: 'image/png',
+        fileUri: 'https://generativelanguage.googleapis.com/v1beta/files/abc',
+      },
+    });
+  });
+
+  it('should prefer an explicit MIME type', async () => {
+    const path = join(dir, 'data.bin');
+    await writeFile(path, 'a,b');
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const part = await client.attachFile(path, { mimeType: 'text/csv' });
+
+    expect(part).toMatchObject({ inlineData: { mimeType: 'text/csv' } });
+  });
+
+  it('should compare the base64 size with the inline limit', async () => {
+    const path = join(dir, 'image.png');
+    await writeFile(path, Buffer.concat([PNG_HEADER, Buffer.alloc(4)]));
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    // 12 bytes fit a 12-byte limit, but their base64 encoding is 16 bytes
+    await client.attachFile(path, { inlineLimitBytes: 12 });
+    expect(mockGeminiClient.uploadFile).toHaveBeenCalledTimes(1);
+
+    const part = await client.attachFile(path, { inlineLimitBytes: 16 });
+    expect(part).toMatchObject({ inlineData: { mimeType: 'image/png' } });
+    expect(mockGeminiClient.uploadFile).toHaveBeenCalledTimes(1);
+  });
+});
+
+describe('detectMimeType', () => {
+  it('should detect types by extension, then by header', () => {
+    expect(detectMimeType('photo.JPG')).toBe('image/jpeg');
+    expect(detectMimeType('upload', PNG_HEADER)).toBe('image/png');
+    expect(detectMimeType('upload', Buffer.from('%PDF-1.7'))).toBe('application/pdf');
+    expect(detectMimeType('upload', Buffer.from('plain'))).toBe('application/octet-stream');
+  });
+});
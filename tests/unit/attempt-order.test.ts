@@ -0,0 +1,394 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { createHash } from 'crypto';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { BillingError } from '../../src/types/errors';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Attempt order', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+      generateStream: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const baseOptions = {
+    apiKeys: ['key1', 'key2'],
+    fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+    maxRetries: 0,
+  };
+
+  const attemptSequence = (calls: any[][]) => calls.map((call) => `${call[1]}/${call[2]}`);
+
+  it('should keep one key per request when unset', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('429 Rate limit exceeded'));
+
+    const client = new GemBack(baseOptions);
+    await expect(client.generate('Hello')).rejects.toThrow('All models failed');
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash-lite/key1',
+    ]);
+  });
+
+  it('should try every key on a model before falling back with keys-first', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('503 Service unavailable'));
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'keys-first' });
+    await expect(client.generate('Hello')).rejects.toThrow('All models failed');
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash/key2',
+      'gemini-2.5-flash-lite/key1',
+      'gemini-2.5-flash-lite/key2',
+    ]);
+  });
+
+  it('should try every model on a key before rotating with models-first', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('503 Service unavailable'));
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'models-first' });
+    await expect(client.generate('Hello')).rejects.toThrow('All models failed');
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash-lite/key1',
+      'gemini-2.5-flash/key2',
+      'gemini-2.5-flash-lite/key2',
+    ]);
+  });
+
+  it('should start from the rotated key on later requests', async () => {
+    mockGeminiClient.generate
+      .mockResolvedValueOnce({ text: 'First', model: 'gemini-2.5-flash' })
+      .mockRejectedValue(new Error('503 Service unavailable'));
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'keys-first' });
+    await client.generate('First');
+    await expect(client.generate('Second')).rejects.toThrow();
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls.slice(1))).toEqual([
+      'gemini-2.5-flash/key2',
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash-lite/key2',
+      'gemini-2.5-flash-lite/key1',
+    ]);
+  });
+
+  it('should credit the key that succeeded and count the keys that failed', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+      .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'keys-first' });
+    const response = await client.generate('Hello');
+
+    expect(response.text).toBe('Success');
+    const [key1, key2] = client.getFallbackStats().apiKeyStats!;
+    expect(key1).toMatchObject({ totalRequests: 1, failureCount: 1, successCount: 0 });
+    expect(key2).toMatchObject({ totalRequests: 1, failureCount: 0, successCount: 1 });
+  });
+
+  it('should apply the same order to streaming', async () => {
+    mockGeminiClient.generateStream.mockImplementation(async function* () {
+      throw new Error('503 Service unavailable');
+    });
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'models-first' });
+
+    const consume = async () => {
+      for await (const _chunk of client.generateStream('Hello')) {
+        // drain
+      }
+    };
+    await expect(consume()).rejects.toThrow('All models failed for streaming');
+
+    expect(attemptSequence(mockGeminiClient.generateStream.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash-lite/key1',
+      'gemini-2.5-flash/key2',
+      'gemini-2.5-flash-lite/key2',
+    ]);
+  });
+
+  it('should stop on an authentication error', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('401 Invalid API key'));
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'keys-first' });
+
+    await expect(client.generate('Hello')).rejects.toThrow('Authentication failed');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+  });
+
+  it('should not rotate keys after a non-retryable client error', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('400 Invalid argument'));
+
+    const client = new GemBack({ ...baseOptions, maxRetries: 2, attemptOrder: 'keys-first' });
+    await expect(client.generate('Hello', { model: 'gemini-2.5-flash' })).rejects.toMatchObject({
+      code: 'ALL_KEYS_EXHAUSTED',
+    });
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+    ]);
+  });
+
+  it('should still fall back to other models after a non-retryable error', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('404 Model not found'))
+      .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash-lite' });
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'keys-first' });
+    await client.generate('Hello');
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash-lite/key1',
+    ]);
+  });
+
+  it('should let a custom retry policy decide what is retryable', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('400 Transient validation backend error'))
+      .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash' });
+
+    const retryPolicy = vi.fn((error: Error) => error.message.includes('Transient'));
+    const client = new GemBack({
+      ...baseOptions,
+      maxRetries: 1,
+      retryDelay: 1,
+      attemptOrder: 'keys-first',
+      retryPolicy,
+    });
+    await client.generate('Hello', { model: 'gemini-2.5-flash' });
+
+    expect(retryPolicy).toHaveBeenCalled();
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash/key1',
+    ]);
+  });
+
+  it('should not retry a rate-limited key on the fallback model with models-first', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+      .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ ...baseOptions, attemptOrder: 'models-first' });
+    await client.generate('Hello');
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash/key2',
+    ]);
+  });
+
+  it('should give each fallback model one attempt when every key is rate limited', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('429 Rate limit exceeded'));
+
+    const keysFirst = new GemBack({ ...baseOptions, attemptOrder: 'keys-first' });
+    await expect(keysFirst.generate('Hello')).rejects.toThrow('All models failed');
+    const keysFirstCalls = mockGeminiClient.generate.mock.calls.length;
+
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash/key2',
+      'gemini-2.5-flash-lite/key1',
+    ]);
+
+    const modelsFirst = new GemBack({ ...baseOptions, attemptOrder: 'models-first' });
+    await expect(modelsFirst.generate('Hello')).rejects.toThrow('All models failed');
+
+    expect(
+      attemptSequence(mockGeminiClient.generate.mock.calls.slice(keysFirstCalls))
+    ).toEqual(['gemini-2.5-flash/key1', 'gemini-2.5-flash/key2', 'gemini-2.5-flash-lite/key2']);
+  });
+
+  it('should stop using a key whose billing is disabled', async () => {
+    const billingError = new Error(
+      JSON.stringify({
+        error: {
+          code: 403,
+          status: 'PERMISSION_DENIED',
+          message: 'Billing account for project is disabled',
+          details: [{ reason: 'BILLING_DISABLED' }],
+        },
+      })
+    );
+    mockGeminiClient.generate.mockImplementation(
+      async (_prompt: string, model: string, key: string) => {
+        if (key === 'key-aaaa-1111') {
+          throw billingError;
+        }
+        return { text: 'ok', model };
+      }
+    );
+
+    const client = new GemBack({ ...baseOptions, apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'] });
+
+    const error = await client.generate('Hello').catch((e) => e);
+    expect(error).toBeInstanceOf(BillingError);
+    expect(error).toMatchObject({ code: 'BILLING_ERROR', maskedKey: '****1111' });
+
+    await client.generate('Hello');
+    await client.generate('Hello');
+    expect(mockGeminiClient.generate.mock.calls.map((call: any[]) => call[2])).toEqual([
+      'key-aaaa-1111',
+      'key-bbbb-2222',
+      'key-bbbb-2222',
+    ]);
+  });
+
+  it('should retry the whole key pool after a cooldown with poolRetries', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+      .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+      .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({
+      apiKeys: ['key1', 'key2'],
+      fallbackOrder: ['gemini-2.5-flash'],
+      maxRetries: 0,
+      attemptOrder: 'keys-first',
+      poolRetries: 1,
+      poolRetryDelay: 10,
+    });
+
+    const response = await client.generate('Hello');
+
+    expect(response.text).toBe('ok');
+    expect(attemptSequence(mockGeminiClient.generate.mock.calls)).toEqual([
+      'gemini-2.5-flash/key1',
+      'gemini-2.5-flash/key2',
+      'gemini-2.5-flash/key2',
+    ]);
+  });
+
+  it('should not retry the pool by default or when a failure was not a rate limit', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('429 Rate limit exceeded'));
+    const noPoolRetry = new GemBack({
+      ...baseOptions,
+      fallbackOrder: ['gemini-2.5-flash'],
+      attemptOrder: 'keys-first',
+    });
+    await expect(noPoolRetry.generate('Hello')).rejects.toThrow('All API keys failed');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+
+    mockGeminiClient.generate.mockClear();
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('503 Service unavailable'))
+      .mockRejectedValue(new Error('429 Rate limit exceeded'));
+    const mixed = new GemBack({
+      ...baseOptions,
+      fallbackOrder: ['gemini-2.5-flash'],
+      attemptOrder: 'keys-first',
+      poolRetries: 2,
+      poolRetryDelay: 10,
+    });
+    await expect(mixed.generate('Hello')).rejects.toThrow('All API keys failed');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+  });
+
+  it('should mask keys with a custom keyMasker in logs and errors', async () => {
+    const errorLog = vi.spyOn(console, 'error').mockImplementation(() => {});
+    mockGeminiClient.generate.mockRejectedValue(
+      new Error(
+        JSON.stringify({
+          error: { code: 403, details: [{ reason: 'BILLING_DISABLED' }] },
+        })
+      )
+    );
+    const keyMasker = (key: string) =>
+      `sha256:${createHash('sha256').update(key).digest('hex').slice(0, 8)}`;
+    const hashed = keyMasker('key-aaaa-1111');
+
+    const client = new GemBack({ ...baseOptions, apiKeys: ['key-aaaa-1111'], keyMasker });
+
+    const error = await client.generate('Hello').catch((e) => e);
+    expect(error).toMatchObject({ code: 'BILLING_ERROR', maskedKey: hashed });
+    expect(errorLog.mock.calls.flat().join('\n')).toContain(`API key ${hashed}`);
+    expect(client.getConfig().apiKeys).toEqual([hashed]);
+    errorLog.mockRestore();
+  });
+
+  describe('key state changes', () => {
+    it('should report a key rejected by the API as invalid until it succeeds', async () => {
+      const onKeyStateChange = vi.fn();
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('403 Forbidden: API key not valid'))
+        .mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({
+        ...baseOptions,
+        apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'],
+        onKeyStateChange,
+      });
+
+      await expect(client.generate('Hello')).rejects.toMatchObject({ code: 'AUTH_ERROR' });
+      expect(onKeyStateChange).toHaveBeenCalledWith('****1111', 'invalid');
+
+      await client.generate('Hello'); // key2
+      await client.generate('Hello'); // key1 again
+      expect(onKeyStateChange.mock.calls).toEqual([
+        ['****1111', 'invalid'],
+        ['****1111', 'recovered'],
+      ]);
+    });
+
+    it('should report when a key circuit opens', async () => {
+      const onKeyStateChange = vi.fn();
+      mockGeminiClient.generate.mockImplementation(
+        async (_prompt: string, model: string, key: string) => {
+          if (key === 'key-aaaa-1111') {
+            throw new Error('503 Service Unavailable');
+          }
+          return { text: 'ok', model };
+        }
+      );
+      const client = new GemBack({
+        ...baseOptions,
+        apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'],
+        attemptOrder: 'keys-first',
+        keyCircuitBreaker: { failureThreshold: 1 },
+        onKeyStateChange,
+      });
+
+      await client.generate('Hello');
+      await client.generate('Hello');
+
+      expect(onKeyStateChange).toHaveBeenCalledWith('****1111', 'circuit-open');
+      // The second request skips the open key entirely
+      expect(mockGeminiClient.generate.mock.calls.map((call: any[]) => call[2])).toEqual([
+        'key-aaaa-1111',
+        'key-bbbb-2222',
+        'key-bbbb-2222',
+      ]);
+    });
+  });
+
+  it('should report quota headroom per masked key from response usage', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: 'ok',
+      model: 'gemini-2.5-flash',
+      usage: { promptTokens: 100, completionTokens: 150, totalTokens: 250 },
+    });
+    const client = new GemBack({
+      ...baseOptions,
+      apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'],
+      keyQuota: { dailyTokens: 1000 },
+    });
+
+    await client.generate('Hello'); // key1
+    await client.generate('Hello'); // key2
+    await client.generate('Hello'); // key1
+
+    expect(client.getQuotaHeadroom()).toEqual({ '****1111': 500, '****2222': 750 });
+  });
+});
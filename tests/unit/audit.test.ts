@@ -0,0 +1,183 @@
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
+import { mkdtemp, readFile, rm } from 'fs/promises';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { JsonlAuditSink } from '../../src/utils/audit';
+import type { AuditEntry } from '../../src/types/config';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('audit sink', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = { generate: vi.fn(), generateContent: vi.fn() };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const usage = { promptTokens: 3, completionTokens: 5, totalTokens: 8 };
+
+  it('should record the prompt, response, model, masked key and usage', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: 'Hello!',
+      model: 'gemini-2.5-flash',
+      usage,
+    });
+    const entries: AuditEntry[] = [];
+    const client = new GemBack({
+      apiKey: 'test-key-1234',
+      fallbackOrder: ['gemini-2.5-flash'],
+      auditSink: { record: (entry) => void entries.push(entry) },
+    });
+
+    await client.generate('Say hello');
+
+    expect(entries).toEqual([
+      {
+        timestamp: expect.any(Date),
+        durationMs: expect.any(Number),
+        prompt: 'Say hello',
+        model: 'gemini-2.5-flash',
+        maskedKey: '****1234',
+        response: 'Hello!',
+        usage,
+      },
+    ]);
+  });
+
+  it('should record failed requests only with auditFailures', async () => {
+    mockGeminiClient.generateContent.mockRejectedValue(new Error('503 Service Unavailable'));
+    const contents = [{ role: 'user' as const, parts: [{ text: 'Hi' }] }];
+    const record = vi.fn();
+    const quiet = new GemBack({ apiKey: 'test-key', maxRetries: 0, auditSink: { record } });
+    const audited = quiet.clone({ auditFailures: true });
+
+    await expect(quiet.generateContent({ contents })).rejects.toThrow();
+    expect(record).not.toHaveBeenCalled();
+
+    await expect(audited.generateContent({ contents })).rejects.toThrow();
+    expect(record).toHaveBeenCalledWith(
+      expect.objectContaining({
+        prompt: contents,
+        error: { code: 'ALL_MODELS_FAILED', message: expect.any(String) },
+      })
+    );
+  });
+
+  it('should record a request rescued by finalFallback as a success', async () => {
+    mockGeminiClient.generateContent.mockRejectedValue(new Error('503 Service Unavailable'));
+    const contents = [{ role: 'user' as const, parts: [{ text: 'Hi' }] }];
+    const record = vi.fn();
+    const client = new GemBack({
+      apiKey: 'test-key',
+      maxRetries: 0,
+      auditSink: { record },
+      auditFailures: true,
+      finalFallback: async () => ({ text: 'From elsewhere', model: 'other-provider' as any }),
+    });
+
+    await client.generateContent({ contents });
+
+    expect(record).toHaveBeenCalledTimes(1);
+    expect(record.mock.calls[0][0]).toMatchObject({
+      model: 'other-provider',
+      response: 'From elsewhere',
+    });
+    expect(record.mock.calls[0][0].error).toBeUndefined();
+  });
+
+  it('should record a blocked response as a failure, not a success', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: '',
+      model: 'gemini-2.5-flash',
+      promptFeedback: { blockReason: 'SAFETY' },
+    });
+    const record = vi.fn();
+    const client = new GemBack({ apiKey: 'test-key', throwOnBlocked: true, auditSink: { record } });
+
+    await expect(client.generate('Hi')).rejects.toMatchObject({ code: 'BLOCKED' });
+    expect(record).not.toHaveBeenCalled();
+
+    await expect(client.clone({ auditFailures: true }).generate('Hi')).rejects.toThrow();
+    expect(record).toHaveBeenCalledWith(
+      expect.objectContaining({ error: { code: 'BLOCKED', message: expect.any(String) } })
+    );
+  });
+
+  it('should record deduplicated requests once', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+    const record = vi.fn();
+    const client = new GemBack({ apiKey: 'test-key', dedupWindow: 5000, auditSink: { record } });
+
+    await Promise.all([client.generate('Hi'), client.generate('Hi')]);
+    await client.generate('Hi');
+
+    expect(record).toHaveBeenCalledTimes(1);
+  });
+
+  it('should not fail the request when the sink fails', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+    const throwing = new GemBack({
+      apiKey: 'test-key',
+      auditSink: {
+        record: () => {
+          throw new Error('disk full');
+        },
+      },
+    });
+    const rejecting = new GemBack({
+      apiKey: 'test-key',
+      auditSink: { record: () => Promise.reject(new Error('disk full')) },
+    });
+
+    await expect(throwing.generate('Hi')).resolves.toMatchObject({ text: 'ok' });
+    await expect(rejecting.generate('Hi')).resolves.toMatchObject({ text: 'ok' });
+  });
+});
+
+describe('JsonlAuditSink', () => {
+  let dir: string;
+
+  beforeEach(async () => {
+    dir = await mkdtemp(join(tmpdir(), 'gemback-'));
+  });
+
+  afterEach(async () => {
+    await rm(dir, { recursive: true, force: true });
+  });
+
+  it('should append one JSON line per entry, in order', async () => {
+    const path = join(dir, 'audit.jsonl');
+    const sink = new JsonlAuditSink(path);
+    const entry = (prompt: string): AuditEntry => ({
+      timestamp: new Date('2025-01-01T00:00:00Z'),
+      durationMs: 10,
+      prompt,
+      response: 'ok',
+    });
+
+    void sink.record(entry('first'));
+    void sink.record(entry('second'));
+    await sink.flush();
+
+    const text = await readFile(path, 'utf8');
+    const lines = text
+      .trim()
+      .split('\n')
+      .map((line) => JSON.parse(line));
+    expect(lines.map((line) => line.prompt)).toEqual(['first', 'second']);
+    expect(lines[0].timestamp).toBe('2025-01-01T00:00:00.000Z');
+  });
+
+  it('should keep writing after a failed write', async () => {
+    const failing = new JsonlAuditSink(join(dir, 'missing', 'audit.jsonl'));
+
+    await expect(
+      failing.record({ timestamp: new Date(), durationMs: 0, prompt: 'Hi' })
+    ).rejects.toThrow();
+    await expect(failing.flush()).resolves.toBeUndefined();
+  });
+});
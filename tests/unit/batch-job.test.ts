@@ -0,0 +1,137 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { BatchJob } from '../../src/client/BatchJob';
+import type { BatchResult } from '../../src/types/response';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Batch jobs', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  it('should drain every request and stream its result', async () => {
+    mockGeminiClient.generate.mockImplementation((prompt: string) =>
+      Promise.resolve({ text: `Answer: ${prompt}`, model: 'gemini-2.5-flash' })
+    );
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const job = client.submitBatch(['a', 'b', { prompt: 'c', options: { temperature: 0 } }], {
+      concurrency: 2,
+    });
+
+    const streamed: BatchResult[] = [];
+    for await (const result of job.results()) {
+      streamed.push(result);
+    }
+
+    expect(streamed.map((r) => r.index).sort()).toEqual([0, 1, 2]);
+    const third = streamed.find((r) => r.index === 2);
+    expect(third?.response?.text).toBe('Answer: c');
+    expect(mockGeminiClient.generate.mock.calls[2][3]).toEqual({ temperature: 0 });
+
+    const all = await job.wait();
+    expect(all).toHaveLength(3);
+  });
+
+  it('should report failed requests without stopping the batch', async () => {
+    mockGeminiClient.generate.mockImplementation((prompt: string) =>
+      prompt === 'bad'
+        ? Promise.reject(new Error('401 Invalid API key'))
+        : Promise.resolve({ text: 'ok', model: 'gemini-2.5-flash' })
+    );
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const results = await client.submitBatch(['good', 'bad', 'good']).wait();
+
+    const failed = results.filter((r) => r.error);
+    expect(results).toHaveLength(3);
+    expect(failed).toHaveLength(1);
+    expect(failed[0].index).toBe(1);
+  });
+
+  it('should respect the concurrency limit', async () => {
+    let active = 0;
+    let peak = 0;
+    mockGeminiClient.generate.mockImplementation(async () => {
+      active++;
+      peak = Math.max(peak, active);
+      await new Promise((resolve) => setTimeout(resolve, 10));
+      active--;
+      return { text: 'ok', model: 'gemini-2.5-flash' };
+    });
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    await client.submitBatch(['1', '2', '3', '4', '5', '6'], { concurrency: 2 }).wait();
+
+    expect(peak).toBe(2);
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(6);
+  });
+
+  it('should abort in-flight requests and skip unstarted ones on cancel', async () => {
+    const resolvers: Array<() => void> = [];
+    mockGeminiClient.generate.mockImplementation(
+      () =>
+        new Promise((resolve) => {
+          resolvers.push(() => resolve({ text: 'ok', model: 'gemini-2.5-flash' }));
+        })
+    );
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const job = client.submitBatch(['1', '2', '3', '4'], { concurrency: 2 });
+
+    await vi.waitFor(() => expect(resolvers).toHaveLength(2));
+    job.cancel();
+
+    const results = await job.wait();
+    resolvers.forEach((resolve) => resolve());
+    expect(job.isCancelled()).toBe(true);
+    expect(results).toHaveLength(2);
+    expect(results.every((r) => r.response === undefined)).toBe(true);
+    expect(results.map((r) => (r.error as any)?.code)).toEqual(['ABORTED', 'ABORTED']);
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+  });
+
+  it('should abort in-flight batch requests on client abort()', async () => {
+    mockGeminiClient.generate.mockImplementation(() => new Promise(() => {}));
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const job = client.submitBatch(['1'], { concurrency: 1 });
+
+    await vi.waitFor(() => expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1));
+    client.abort();
+
+    const [result] = await job.wait();
+    expect((result.error as any)?.code).toBe('ABORTED');
+  });
+
+  it('should give each results() iterator the full result list', async () => {
+    const job = new BatchJob(
+      [{ prompt: 'a' }, { prompt: 'b' }],
+      (request) => Promise.resolve({ text: request.prompt, model: 'gemini-2.5-flash' }),
+      2
+    );
+    await job.wait();
+
+    const first: number[] = [];
+    const second: number[] = [];
+    for await (const result of job.results()) first.push(result.index);
+    for await (const result of job.results()) second.push(result.index);
+
+    expect(first).toHaveLength(2);
+    expect(second).toEqual(first);
+  });
+
+  it('should complete immediately for an empty batch', async () => {
+    const client = new GemBack({ apiKey: 'test-key' });
+    const results = await client.submitBatch([]).wait();
+    expect(results).toEqual([]);
+  });
+});
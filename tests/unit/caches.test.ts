@@ -0,0 +1,86 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+
+const mockCaches = {
+  update: vi.fn(),
+  delete: vi.fn(),
+  list: vi.fn(),
+};
+
+vi.mock('@google/genai', () => ({
+  GoogleGenAI: vi.fn(() => ({
+    models: {},
+    caches: mockCaches,
+  })),
+}));
+
+describe('Context cache lifecycle', () => {
+  beforeEach(() => {
+    vi.clearAllMocks();
+  });
+
+  describe('GeminiClient', () => {
+    it('should refresh a cache TTL in seconds', async () => {
+      mockCaches.update.mockResolvedValue({ name: 'cachedContents/abc', expireTime: 'later' });
+
+      const client = new GeminiClient();
+      const cache = await client.refreshCache('cachedContents/abc', 3600, 'test-key');
+
+      expect(mockCaches.update).toHaveBeenCalledWith({
+        name: 'cachedContents/abc',
+        config: { ttl: '3600s' },
+      });
+      expect(cache.expireTime).toBe('later');
+    });
+
+    it('should delete a cache', async () => {
+      mockCaches.delete.mockResolvedValue({});
+
+      const client = new GeminiClient();
+      await client.deleteCache('cachedContents/abc', 'test-key');
+
+      expect(mockCaches.delete).toHaveBeenCalledWith({ name: 'cachedContents/abc' });
+    });
+
+    it('should collect every page of caches', async () => {
+      mockCaches.list.mockResolvedValue(
+        (async function* () {
+          yield { name: 'cachedContents/a' };
+          yield { name: 'cachedContents/b' };
+        })()
+      );
+
+      const client = new GeminiClient();
+      const caches = await client.listCaches('test-key');
+
+      expect(caches.map((cache) => cache.name)).toEqual(['cachedContents/a', 'cachedContents/b']);
+    });
+  });
+
+  describe('GemBack', () => {
+    it('should rotate keys across cache calls', async () => {
+      mockCaches.delete.mockResolvedValue({});
+      const clientSpy = vi.spyOn(GeminiClient.prototype, 'deleteCache');
+
+      const client = new GemBack({ apiKeys: ['key1', 'key2'] });
+      await client.deleteCache('cachedContents/a');
+      await client.deleteCache('cachedContents/b');
+
+      expect(clientSpy.mock.calls.map((call) => call[1])).toEqual(['key1', 'key2']);
+      clientSpy.mockRestore();
+    });
+
+    it('should surface API errors and count them against the key', async () => {
+      mockCaches.update.mockRejectedValue(new Error('404 Cache not found'));
+
+      const client = new GemBack({ apiKeys: ['key1', 'key2'] });
+      await expect(client.refreshCache('cachedContents/missing', 60)).rejects.toThrow(
+        '404 Cache not found'
+      );
+
+      const [key1] = client.getFallbackStats().apiKeyStats!;
+      expect(key1.failureCount).toBe(1);
+    });
+  });
+});
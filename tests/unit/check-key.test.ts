@@ -0,0 +1,58 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('GemBack.checkKey', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      validateApiKey: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  it('should resolve for a valid key', async () => {
+    mockGeminiClient.validateApiKey.mockResolvedValue(true);
+
+    await expect(GemBack.checkKey('good-key')).resolves.toBeUndefined();
+    expect(mockGeminiClient.validateApiKey).toHaveBeenCalledWith('good-key');
+  });
+
+  it('should reject an invalid key', async () => {
+    mockGeminiClient.validateApiKey.mockResolvedValue(false);
+
+    await expect(GemBack.checkKey('bad-key')).rejects.toMatchObject({
+      code: 'INVALID_API_KEY',
+    });
+  });
+
+  it('should report quota errors', async () => {
+    mockGeminiClient.validateApiKey.mockRejectedValue(new Error('429 Quota exceeded'));
+
+    await expect(GemBack.checkKey('busy-key')).rejects.toMatchObject({
+      code: 'QUOTA_EXCEEDED',
+      statusCode: 429,
+    });
+  });
+
+  it('should report network errors', async () => {
+    mockGeminiClient.validateApiKey.mockRejectedValue(new Error('getaddrinfo ENOTFOUND'));
+
+    await expect(GemBack.checkKey('some-key')).rejects.toMatchObject({
+      code: 'NETWORK_ERROR',
+    });
+  });
+
+  it('should use a client separate from any GemBack instance', async () => {
+    mockGeminiClient.validateApiKey.mockResolvedValue(true);
+    new GemBack({ apiKey: 'pool-key' });
+
+    await GemBack.checkKey('new-key');
+
+    expect(GeminiClient).toHaveBeenCalledTimes(2);
+  });
+});
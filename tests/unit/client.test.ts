@@ -1,5 +1,9 @@
 import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GoogleGenAI } from '@google/genai';
 import { GeminiClient } from '../../src/client/GeminiClient';
+import { isRetryableError } from '../../src/utils/error-handler';
+import { ClientConfigError } from '../../src/types/errors';
+import { Logger } from '../../src/utils/logger';
 
 const mockModels = {
   generateContent: vi.fn(),
@@ -110,6 +114,17 @@ describe('GeminiClient', () => {
         'Request timeout'
       );
     }, 10000);
+
+    it('should prefer a per-request timeout over the client timeout', async () => {
+      mockModels.generateContent.mockImplementation(() => new Promise(() => {})); // Never resolves
+
+      const client = new GeminiClient(60000);
+      const start = Date.now();
+      await expect(
+        client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', { timeout: 50 })
+      ).rejects.toThrow('Request timeout');
+      expect(Date.now() - start).toBeLessThan(5000);
+    }, 10000);
   });
 
   describe('generateStream', () => {
@@ -404,4 +419,887 @@ describe('GeminiClient', () => {
       });
     });
   });
+
+  describe('generationConfig passthrough', () => {
+    it('should apply the full generation config to the request', async () => {
+      const client = new GeminiClient();
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', {
+        generationConfig: {
+          temperature: 0.2,
+          stopSequences: ['END'],
+          presencePenalty: 0.5,
+        },
+      });
+
+      const { config } = mockModels.generateContent.mock.calls[0][0];
+      expect(config.temperature).toBe(0.2);
+      expect(config.stopSequences).toEqual(['END']);
+      expect(config.presencePenalty).toBe(0.5);
+    });
+
+    it('should let individual fields override the generation config', async () => {
+      const client = new GeminiClient();
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', {
+        temperature: 0.9,
+        maxTokens: 100,
+        generationConfig: {
+          temperature: 0.2,
+          maxOutputTokens: 4000,
+          topP: 0.5,
+        },
+      });
+
+      const { config } = mockModels.generateContent.mock.calls[0][0];
+      expect(config.temperature).toBe(0.9);
+      expect(config.maxOutputTokens).toBe(100);
+      expect(config.topP).toBe(0.5);
+    });
+
+    it('should apply the generation config to multimodal streaming', async () => {
+      const client = new GeminiClient();
+      const contents = [{ role: 'user' as const, parts: [{ text: 'Analyze' }] }];
+      const stream = client.generateContentStream(contents, 'gemini-2.5-flash', 'test-api-key', {
+        generationConfig: { seed: 42 },
+      });
+
+      for await (const _ of stream) {
+        // Just consume
+      }
+
+      const { config } = mockModels.generateContentStream.mock.calls[0][0];
+      expect(config.seed).toBe(42);
+    });
+  });
+
+  describe('prompt feedback', () => {
+    it('should surface prompt feedback on successful responses', async () => {
+      const promptFeedback = {
+        safetyRatings: [{ category: 'HARM_CATEGORY_HARASSMENT', probability: 'NEGLIGIBLE' }],
+      };
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Fine',
+        candidates: [{ finishReason: 'STOP' }],
+        promptFeedback,
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.text).toBe('Fine');
+      expect(response.promptFeedback).toEqual(promptFeedback);
+    });
+
+    it('should surface the block reason for blocked prompts', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: undefined,
+        candidates: [],
+        promptFeedback: { blockReason: 'SAFETY' },
+      });
+
+      const client = new GeminiClient();
+      const contents = [{ role: 'user' as const, parts: [{ text: 'Blocked' }] }];
+      const response = await client.generateContent(contents, 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.text).toBe('');
+      expect(response.promptFeedback?.blockReason).toBe('SAFETY');
+    });
+
+    it('should surface the first candidate safety ratings', async () => {
+      const safetyRatings = [
+        { category: 'HARM_CATEGORY_DANGEROUS_CONTENT', probability: 'HIGH', blocked: true },
+      ];
+      mockModels.generateContent.mockResolvedValue({
+        text: undefined,
+        candidates: [{ finishReason: 'SAFETY', safetyRatings }],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.finishReason).toBe('SAFETY');
+      expect(response.safetyRatings).toEqual(safetyRatings);
+    });
+
+    it('should leave prompt feedback undefined when absent', async () => {
+      const client = new GeminiClient();
+      const response = await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.promptFeedback).toBeUndefined();
+    });
+  });
+
+  describe('tool-call-only responses', () => {
+    it('should flag a candidate made only of function calls', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: undefined,
+        candidates: [
+          {
+            finishReason: 'STOP',
+            content: {
+              role: 'model',
+              parts: [{ functionCall: { name: 'get_weather', args: { city: 'Tokyo' } } }],
+            },
+          },
+        ],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Weather?', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.text).toBe('');
+      expect(response.isToolCall).toBe(true);
+      expect(response.functionCalls).toEqual([{ name: 'get_weather', args: { city: 'Tokyo' } }]);
+    });
+
+    it('should not flag responses that also contain text', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Checking the weather.',
+        candidates: [
+          {
+            finishReason: 'STOP',
+            content: {
+              role: 'model',
+              parts: [
+                { text: 'Checking the weather.' },
+                { functionCall: { name: 'get_weather', args: {} } },
+              ],
+            },
+          },
+        ],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Weather?', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.functionCalls).toHaveLength(1);
+      expect(response.isToolCall).toBeUndefined();
+    });
+  });
+
+  describe('seed', () => {
+    it('should pass the seed to the generation config', async () => {
+      const client = new GeminiClient();
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', {
+        seed: 1234,
+        temperature: 0,
+      });
+
+      const { config } = mockModels.generateContent.mock.calls[0][0];
+      expect(config.seed).toBe(1234);
+      expect(config.temperature).toBe(0);
+    });
+
+    it('should omit the seed when unset', async () => {
+      const client = new GeminiClient();
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      const { config } = mockModels.generateContent.mock.calls[0][0];
+      expect(config).not.toHaveProperty('seed');
+    });
+  });
+
+  describe('citations', () => {
+    it('should surface citation sources from the candidate', async () => {
+      const citation = {
+        startIndex: 0,
+        endIndex: 12,
+        uri: 'https://example.com/source',
+        license: 'CC-BY',
+      };
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Quoted text.',
+        candidates: [{ finishReason: 'STOP', citationMetadata: { citations: [citation] } }],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Quote', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.citations).toEqual([citation]);
+    });
+
+    it('should leave citations undefined when the candidate has none', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Original text.',
+        candidates: [{ finishReason: 'STOP', citationMetadata: {} }],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Write', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.citations).toBeUndefined();
+    });
+  });
+
+  describe('textPartSelector', () => {
+    const parts = [{ text: 'Reasoning: 2 + 2 = 4' }, { text: 'Answer: 4' }];
+
+    beforeEach(() => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Reasoning: 2 + 2 = 4Answer: 4',
+        candidates: [{ finishReason: 'STOP', content: { role: 'model', parts } }],
+      });
+    });
+
+    it('should concatenate all text parts by default', async () => {
+      const client = new GeminiClient();
+      const response = await client.generate('2 + 2?', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.text).toBe('Reasoning: 2 + 2 = 4Answer: 4');
+    });
+
+    it('should build text with a custom selector', async () => {
+      const selector = vi.fn((candidateParts: Array<{ text?: string }>) =>
+        candidateParts[candidateParts.length - 1].text ?? ''
+      );
+      const client = new GeminiClient(30000, { textPartSelector: selector });
+      const response = await client.generate('2 + 2?', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(selector).toHaveBeenCalledWith(parts);
+      expect(response.text).toBe('Answer: 4');
+    });
+
+    it('should pass an empty list when the candidate has no parts', async () => {
+      mockModels.generateContent.mockResolvedValue({ text: undefined, candidates: [] });
+      const selector = vi.fn(() => 'fallback');
+      const client = new GeminiClient(30000, { textPartSelector: selector });
+      const response = await client.generate('Hi', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(selector).toHaveBeenCalledWith([]);
+      expect(response.text).toBe('fallback');
+    });
+  });
+
+  describe('candidateCount', () => {
+    it('should pass candidateCount and return every candidate with its token count', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'First answer',
+        candidates: [
+          {
+            finishReason: 'STOP',
+            tokenCount: 2,
+            content: { role: 'model', parts: [{ text: 'First answer' }] },
+          },
+          {
+            finishReason: 'MAX_TOKENS',
+            tokenCount: 5,
+            content: { role: 'model', parts: [{ text: 'Second, longer answer' }] },
+          },
+        ],
+        usageMetadata: {
+          promptTokenCount: 4,
+          candidatesTokenCount: 7,
+          totalTokenCount: 11,
+        },
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Answer', 'gemini-2.5-flash', 'test-api-key', {
+        candidateCount: 2,
+      });
+
+      expect(mockModels.generateContent.mock.calls[0][0].config.candidateCount).toBe(2);
+      expect(response.text).toBe('First answer');
+      expect(response.candidates).toEqual([
+        { text: 'First answer', finishReason: 'STOP', tokenCount: 2 },
+        { text: 'Second, longer answer', finishReason: 'MAX_TOKENS', tokenCount: 5 },
+      ]);
+      expect(response.usage?.completionTokens).toBe(7);
+    });
+
+    it('should leave candidates undefined for a single candidate', async () => {
+      const client = new GeminiClient();
+      const response = await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.candidates).toBeUndefined();
+    });
+  });
+
+  describe('empty results', () => {
+    it('should reject with a retryable error when the API resolves with no result', async () => {
+      mockModels.generateContent.mockResolvedValue(undefined);
+
+      const client = new GeminiClient();
+      const error = await client
+        .generate('Hello', 'gemini-2.5-flash', 'test-api-key')
+        .catch((err: Error) => err);
+
+      expect(error).toBeInstanceOf(Error);
+      expect((error as Error).message).toBe('Empty response from API');
+      expect(isRetryableError(error as Error)).toBe(true);
+    });
+
+    it('should skip null chunks in a stream', async () => {
+      mockModels.generateContentStream.mockImplementation(async function* () {
+        yield { text: 'Hello' };
+        yield null;
+        yield { text: ' world' };
+      });
+
+      const client = new GeminiClient();
+      const chunks: string[] = [];
+      for await (const chunk of client.generateStream('Hi', 'gemini-2.5-flash', 'test-api-key')) {
+        chunks.push(chunk.text);
+      }
+
+      expect(chunks).toEqual(['Hello', ' world']);
+    });
+  });
+
+  describe('cached tokens', () => {
+    it('should report prompt tokens served from a context cache', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Cached answer',
+        candidates: [{ finishReason: 'STOP' }],
+        usageMetadata: {
+          promptTokenCount: 1200,
+          cachedContentTokenCount: 1000,
+          candidatesTokenCount: 20,
+          totalTokenCount: 1220,
+        },
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Question', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.usage).toEqual({
+        promptTokens: 1200,
+        completionTokens: 20,
+        totalTokens: 1220,
+        cachedTokens: 1000,
+      });
+    });
+
+    it('should leave cachedTokens undefined without a cache', async () => {
+      const client = new GeminiClient();
+      const response = await client.generate('Question', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.usage?.cachedTokens).toBeUndefined();
+    });
+  });
+
+  describe('response content', () => {
+    it('should keep text, function calls and inline data from a mixed candidate', async () => {
+      const image = { mimeType: 'image/png', data: 'iVBORw0KGgo=' };
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Here is the chart. It shows growth.',
+        candidates: [
+          {
+            finishReason: 'STOP',
+            content: {
+              role: 'model',
+              parts: [
+                { text: 'Let me think about this.', thought: true },
+                { text: 'Here is the chart. ' },
+                { inlineData: image },
+                { functionCall: { name: 'saveChart', args: { format: 'png' } } },
+                { text: 'It shows growth.' },
+              ],
+            },
+          },
+        ],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Chart it', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.text).toBe('Here is the chart. It shows growth.');
+      expect(response.content).toEqual({
+        textParts: ['Here is the chart. ', 'It shows growth.'],
+        functionCalls: [{ name: 'saveChart', args: { format: 'png' } }],
+        blobs: [image],
+      });
+    });
+
+    it('should log and skip parts of an unknown type', async () => {
+      const logger = new Logger('silent');
+      const debug = vi.spyOn(logger, 'debug');
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Hello world',
+        candidates: [
+          {
+            finishReason: 'STOP',
+            content: {
+              role: 'model',
+              parts: [
+                { text: 'Hello' },
+                { hologram: { frames: 3 } },
+                { text: new String(' world') },
+              ],
+            },
+          },
+        ],
+      });
+
+      const client = new GeminiClient(30000, { logger });
+      const response = await client.generate('Hi', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.content?.textParts).toEqual(['Hello', ' world']);
+      expect(debug).toHaveBeenCalledTimes(1);
+      expect(debug).toHaveBeenCalledWith(expect.stringContaining('hologram'));
+    });
+
+    it('should build text from the text parts rather than the SDK text', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Hello',
+        candidates: [
+          {
+            finishReason: 'STOP',
+            content: {
+              role: 'model',
+              parts: [{ text: 'Hello' }, { text: new String(' world') }],
+            },
+          },
+        ],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Hi', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.text).toBe('Hello world');
+      expect(response.text).toBe(response.content?.textParts.join(''));
+    });
+
+    it('should build stream chunk text from the text parts', async () => {
+      mockModels.generateContentStream.mockImplementation(async function* () {
+        yield {
+          text: 'Hello',
+          candidates: [{ content: { parts: [{ text: 'Hello' }, { text: new String('!') }] } }],
+        };
+      });
+
+      const client = new GeminiClient();
+      const chunks: string[] = [];
+      for await (const chunk of client.generateStream('Hi', 'gemini-2.5-flash', 'test-api-key')) {
+        chunks.push(chunk.text);
+      }
+
+      expect(chunks).toEqual(['Hello!']);
+    });
+
+    it('should leave content undefined when the candidate has no parts', async () => {
+      const client = new GeminiClient();
+      const response = await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.content).toBeUndefined();
+    });
+  });
+
+  describe('pre-built parts', () => {
+    const image = { inlineData: { mimeType: 'image/png', data: 'iVBORw0KGgo=' } };
+
+    it('should send only the parts when the prompt is empty', async () => {
+      const client = new GeminiClient();
+      await client.generate('', 'gemini-2.5-flash', 'test-api-key', { parts: [image] });
+
+      const request = mockModels.generateContent.mock.calls[0][0];
+      expect(request.contents).toEqual([{ role: 'user', parts: [image] }]);
+      expect(request.config).not.toHaveProperty('parts');
+    });
+
+    it('should send the prompt text before the parts', async () => {
+      const client = new GeminiClient();
+      await client.generate('Describe this image', 'gemini-2.5-flash', 'test-api-key', {
+        parts: [image],
+      });
+
+      expect(mockModels.generateContent.mock.calls[0][0].contents).toEqual([
+        { role: 'user', parts: [{ text: 'Describe this image' }, image] },
+      ]);
+    });
+
+    it('should keep the parts in order and put the prompt after them when asked', async () => {
+      const chart = { fileData: { mimeType: 'image/png', fileUri: 'gs://bucket/chart.png' } };
+      const client = new GeminiClient();
+      await client.generate('Which image shows more growth?', 'gemini-2.5-flash', 'test-api-key', {
+        parts: [image, { text: 'versus' }, chart],
+        promptPosition: 'after',
+      });
+
+      expect(mockModels.generateContent.mock.calls[0][0].contents).toEqual([
+        {
+          role: 'user',
+          parts: [image, { text: 'versus' }, chart, { text: 'Which image shows more growth?' }],
+        },
+      ]);
+    });
+  });
+
+  describe('apiVersion', () => {
+    it('should pass the API version to the SDK client', async () => {
+      const client = new GeminiClient(30000, { apiVersion: 'v1' });
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(GoogleGenAI).toHaveBeenCalledWith({ apiKey: 'test-api-key', apiVersion: 'v1' });
+    });
+
+    it('should leave the SDK default when unset', async () => {
+      const client = new GeminiClient();
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(GoogleGenAI).toHaveBeenCalledWith({ apiKey: 'test-api-key' });
+    });
+  });
+
+  describe('logprobs', () => {
+    const logprobsResult = {
+      chosenCandidates: [{ token: 'Hi', logProbability: -0.1 }],
+      topCandidates: [
+        {
+          candidates: [
+            { token: 'Hi', logProbability: -0.1 },
+            { token: 'Hello', logProbability: -2.3 },
+          ],
+        },
+      ],
+    };
+
+    it('should apply the logprobs config and parse the results', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Hi',
+        candidates: [
+          {
+            content: { role: 'model', parts: [{ text: 'Hi' }] },
+            logprobsResult,
+            avgLogprobs: -0.1,
+          },
+        ],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Greet me', 'gemini-2.5-flash', 'test-api-key', {
+        responseLogprobs: true,
+        logprobs: 2,
+      });
+
+      expect(mockModels.generateContent.mock.calls[0][0].config).toMatchObject({
+        responseLogprobs: true,
+        logprobs: 2,
+      });
+      expect(response.logprobs).toEqual(logprobsResult);
+      expect(response.avgLogprobs).toBe(-0.1);
+    });
+
+    it('should retry without logprobs when the model does not support them', async () => {
+      const warn = vi.spyOn(console, 'warn').mockImplementation(() => {});
+      mockModels.generateContent
+        .mockRejectedValueOnce(new Error('400 Bad Request: logprobs is not supported'))
+        .mockResolvedValueOnce({ text: 'Hi' });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Greet me', 'gemini-2.5-flash', 'test-api-key', {
+        responseLogprobs: true,
+        logprobs: 2,
+        temperature: 0.5,
+      });
+
+      expect(response.text).toBe('Hi');
+      expect(response.logprobs).toBeUndefined();
+      expect(mockModels.generateContent.mock.calls[1][0].config).toEqual({ temperature: 0.5 });
+      warn.mockRestore();
+    });
+  });
+
+  describe('warmup', () => {
+    it('should construct and cache one SDK client per key', async () => {
+      const client = new GeminiClient();
+
+      client.warmup('key-1');
+      client.warmup('key-2');
+      await client.generate('Hello', 'gemini-2.5-flash', 'key-1');
+
+      expect(GoogleGenAI).toHaveBeenCalledTimes(2);
+      expect(GoogleGenAI).toHaveBeenCalledWith({ apiKey: 'key-1' });
+      expect(GoogleGenAI).toHaveBeenCalledWith({ apiKey: 'key-2' });
+    });
+  });
+
+  describe('client construction errors', () => {
+    it('should raise config-level construction errors as ClientConfigError', async () => {
+      vi.mocked(GoogleGenAI).mockImplementationOnce(() => {
+        throw new Error('Unsupported apiVersion: v9');
+      });
+
+      const client = new GeminiClient(30000, { apiVersion: 'v9' });
+      const error = await client.generate('Hello', 'gemini-2.5-flash', 'key-1').catch((e) => e);
+
+      expect(error).toBeInstanceOf(ClientConfigError);
+      expect(error.code).toBe('CLIENT_CONFIG_ERROR');
+      expect(error.message).toBe('Could not create the Gemini client: Unsupported apiVersion: v9');
+    });
+
+    it('should rethrow key-level construction errors unchanged', async () => {
+      const keyError = new Error('API key must be a non-empty string');
+      vi.mocked(GoogleGenAI).mockImplementationOnce(() => {
+        throw keyError;
+      });
+
+      const client = new GeminiClient();
+      const error = await client.generate('Hello', 'gemini-2.5-flash', '').catch((e) => e);
+
+      expect(error).toBe(keyError);
+      expect(error).not.toBeInstanceOf(ClientConfigError);
+    });
+  });
+
+  describe('partial usage metadata', () => {
+    it('should derive a missing completion count from the total', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Answer',
+        candidates: [{ finishReason: 'STOP' }],
+        usageMetadata: { promptTokenCount: 12, totalTokenCount: 40 },
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Question', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.usage).toMatchObject({
+        promptTokens: 12,
+        completionTokens: 28,
+        totalTokens: 40,
+        estimated: true,
+      });
+    });
+
+    it('should not count thinking tokens as missing completion tokens', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Answer',
+        candidates: [{ finishReason: 'STOP' }],
+        usageMetadata: {
+          promptTokenCount: 12,
+          candidatesTokenCount: 8,
+          thoughtsTokenCount: 20,
+          totalTokenCount: 40,
+        },
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Question', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.usage).toMatchObject({ promptTokens: 12, completionTokens: 8 });
+      expect(response.usage?.estimated).toBeUndefined();
+    });
+
+    it('should leave usage undefined when no token counts are reported', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'Answer',
+        candidates: [{ finishReason: 'STOP' }],
+        usageMetadata: {},
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Question', 'gemini-2.5-flash', 'test-api-key');
+
+      expect(response.usage).toBeUndefined();
+    });
+  });
+
+  describe('media options', () => {
+    it('should pass media resolution and audio timestamps to the generation config', async () => {
+      const client = new GeminiClient();
+      await client.generate('Describe the clip', 'gemini-2.5-flash', 'test-api-key', {
+        mediaResolution: 'low',
+        audioTimestamp: true,
+      });
+
+      expect(mockModels.generateContent.mock.calls[0][0].config).toMatchObject({
+        mediaResolution: 'MEDIA_RESOLUTION_LOW',
+        audioTimestamp: true,
+      });
+    });
+
+    it('should retry without audio timestamps when the model rejects them', async () => {
+      const warn = vi.spyOn(console, 'warn').mockImplementation(() => {});
+      mockModels.generateContent
+        .mockRejectedValueOnce(new Error('400 Bad Request: audio_timestamp is not supported'))
+        .mockResolvedValueOnce({ text: 'A dog barks' });
+
+      const client = new GeminiClient();
+      const response = await client.generate('Describe', 'gemini-2.5-flash', 'test-api-key', {
+        audioTimestamp: true,
+        mediaResolution: 'high',
+      });
+
+      expect(response.text).toBe('A dog barks');
+      expect(mockModels.generateContent.mock.calls[1][0].config).toEqual({
+        mediaResolution: 'MEDIA_RESOLUTION_HIGH',
+      });
+      warn.mockRestore();
+    });
+  });
+
+  describe('labels', () => {
+    const labels = { team: 'search', env: 'prod' };
+
+    it('should keep labels local by default', async () => {
+      const client = new GeminiClient();
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', { labels });
+
+      expect(mockModels.generateContent.mock.calls[0][0].config.labels).toBeUndefined();
+    });
+
+    it('should send labels with the request when forwardLabels is set', async () => {
+      const client = new GeminiClient(30000, { forwardLabels: true });
+      await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', { labels });
+      await client.generateContent(
+        [{ role: 'user', parts: [{ text: 'Hello' }] }],
+        'gemini-2.5-flash',
+        'test-api-key',
+        { labels }
+      );
+
+      expect(mockModels.generateContent.mock.calls[0][0].config.labels).toEqual(labels);
+      expect(mockModels.generateContent.mock.calls[1][0].config.labels).toEqual(labels);
+    });
+
+    it('should retry without labels when the API does not support them', async () => {
+      const warn = vi.spyOn(console, 'warn').mockImplementation(() => {});
+      mockModels.generateContent
+        .mockRejectedValueOnce(new Error('labels parameter is not supported in Gemini API.'))
+        .mockResolvedValueOnce({ text: 'Hi' });
+
+      const client = new GeminiClient(30000, { forwardLabels: true });
+      const response = await client.generate('Hello', 'gemini-2.5-flash', 'test-api-key', {
+        labels,
+      });
+
+      expect(response.text).toBe('Hi');
+      expect(mockModels.generateContent.mock.calls[1][0].config.labels).toBeUndefined();
+      expect(warn).toHaveBeenCalledWith(expect.stringContaining('does not support labels'));
+      warn.mockRestore();
+    });
+
+    it('should retry streams without labels when the API does not support them', async () => {
+      const logger = new Logger('silent');
+      const warn = vi.spyOn(logger, 'warn');
+      mockModels.generateContentStream.mockRejectedValueOnce(
+        new Error('labels parameter is not supported in Gemini API.')
+      );
+
+      const client = new GeminiClient(30000, { forwardLabels: true, logger });
+      const chunks: string[] = [];
+      for await (const chunk of client.generateStream('Hello', 'gemini-2.5-flash', 'test-api-key', {
+        labels,
+      })) {
+        chunks.push(chunk.text);
+      }
+
+      expect(chunks.length).toBeGreaterThan(0);
+      expect(mockModels.generateContentStream).toHaveBeenCalledTimes(2);
+      expect(mockModels.generateContentStream.mock.calls[0][0].config.labels).toEqual(labels);
+      expect(mockModels.generateContentStream.mock.calls[1][0].config.labels).toBeUndefined();
+      expect(warn).toHaveBeenCalledWith(expect.stringContaining('does not support labels'));
+    });
+  });
+
+  describe('raw stream responses', () => {
+    it('should yield each SDK response alongside its text', async () => {
+      const rating = (probability: string) => ({
+        candidates: [{ safetyRatings: [{ category: 'HARM_CATEGORY_HARASSMENT', probability }] }],
+      });
+      const first = { text: 'Hello', ...rating('NEGLIGIBLE') };
+      const second = { text: ' world', ...rating('LOW') };
+      mockModels.generateContentStream.mockImplementation(async function* () {
+        yield first;
+        yield second;
+      });
+
+      const client = new GeminiClient();
+      const chunks: Array<{ text: string; raw: unknown }> = [];
+      for await (const chunk of client.generateStream('Hi', 'gemini-2.5-flash', 'test-api-key')) {
+        chunks.push(chunk);
+      }
+
+      expect(chunks).toEqual([
+        { text: 'Hello', raw: first },
+        { text: ' world', raw: second },
+      ]);
+    });
+  });
+
+  describe('thoughts', () => {
+    it('should request thought summaries and keep them out of text', async () => {
+      mockModels.generateContent.mockResolvedValue({
+        text: 'The answer is 4.',
+        candidates: [
+          {
+            finishReason: 'STOP',
+            content: {
+              parts: [
+                { text: 'Adding 2 and 2 ', thought: true },
+                { text: 'gives 4.', thought: true },
+                { text: 'The answer is 4.' },
+              ],
+            },
+          },
+        ],
+      });
+
+      const client = new GeminiClient();
+      const response = await client.generate(
+        'What is 2 + 2?',
+        'gemini-2.5-flash',
+        'test-api-key',
+        { includeThoughts: true }
+      );
+
+      expect(response.text).toBe('The answer is 4.');
+      expect(response.thoughts).toBe('Adding 2 and 2 gives 4.');
+      expect(response.content?.textParts).toEqual(['The answer is 4.']);
+      const { config } = mockModels.generateContent.mock.calls[0][0];
+      expect(config.thinkingConfig).toEqual({ includeThoughts: true });
+    });
+
+    it('should retry without thinking config for models that reject it', async () => {
+      mockModels.generateContent
+        .mockRejectedValueOnce(new Error('400 Thinking is not supported for this model.'))
+        .mockResolvedValueOnce({ text: 'Hi', candidates: [{ finishReason: 'STOP' }] });
+      const warn = vi.spyOn(console, 'warn').mockImplementation(() => {});
+
+      const client = new GeminiClient();
+      const response = await client.generate('Hi', 'gemini-2.0-flash', 'test-api-key', {
+        includeThoughts: true,
+      });
+
+      expect(response.text).toBe('Hi');
+      expect(response.thoughts).toBeUndefined();
+      expect(mockModels.generateContent.mock.calls[1][0].config).not.toHaveProperty(
+        'thinkingConfig'
+      );
+      warn.mockRestore();
+    });
+
+    it('should keep a caller thinkingConfig and not retry its errors', async () => {
+      mockModels.generateContent.mockRejectedValue(new Error('400 Invalid thinking budget'));
+      const client = new GeminiClient();
+
+      await expect(
+        client.generate('Hi', 'gemini-2.5-flash', 'test-api-key', {
+          generationConfig: { thinkingConfig: { thinkingBudget: -5 } },
+        })
+      ).rejects.toThrow('Invalid thinking budget');
+      expect(mockModels.generateContent).toHaveBeenCalledTimes(1);
+    });
+
+    it('should drop only includeThoughts when retrying', async () => {
+      mockModels.generateContent
+        .mockRejectedValueOnce(new Error('400 Thoughts are not supported for this model.'))
+        .mockResolvedValueOnce({ text: 'Hi', candidates: [{ finishReason: 'STOP' }] });
+      const warn = vi.spyOn(console, 'warn').mockImplementation(() => {});
+
+      const client = new GeminiClient();
+      await client.generate('Hi', 'gemini-2.5-flash', 'test-api-key', {
+        includeThoughts: true,
+        generationConfig: { thinkingConfig: { thinkingBudget: 512 } },
+      });
+
+      expect(mockModels.generateContent.mock.calls[0][0].config.thinkingConfig).toEqual({
+        thinkingBudget: 512,
+        includeThoughts: true,
+      });
+      expect(mockModels.generateContent.mock.calls[1][0].config.thinkingConfig).toEqual({
+        thinkingBudget: 512,
+      });
+      warn.mockRestore();
+    });
+  });
 });
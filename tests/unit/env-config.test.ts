@@ -0,0 +1,34 @@
+import { describe, it, expect } from 'vitest';
+import { configFromEnv } from '../../src/utils/env-config';
+
+describe('configFromEnv', () => {
+  it('should read generation settings into defaultGenerationConfig', () => {
+    const config = configFromEnv({
+      GEMBACK_TEMPERATURE: '0.7',
+      GEMBACK_TOP_P: ' 0.95 ',
+      GEMBACK_TOP_K: '40',
+      GEMBACK_MAX_TOKENS: '2048',
+    });
+
+    expect(config).toEqual({
+      defaultGenerationConfig: { temperature: 0.7, topP: 0.95, topK: 40, maxTokens: 2048 },
+    });
+  });
+
+  it('should skip unset and empty variables', () => {
+    expect(configFromEnv({ GEMBACK_TEMPERATURE: '0', GEMBACK_TOP_P: '' })).toEqual({
+      defaultGenerationConfig: { temperature: 0 },
+    });
+    expect(configFromEnv({})).toEqual({});
+  });
+
+  it.each([
+    ['GEMBACK_TEMPERATURE', '2.5', 'GEMBACK_TEMPERATURE must be between 0 and 2, got 2.5'],
+    ['GEMBACK_TEMPERATURE', 'warm', 'GEMBACK_TEMPERATURE must be a number, got "warm"'],
+    ['GEMBACK_TOP_P', '-0.1', 'GEMBACK_TOP_P must be between 0 and 1, got -0.1'],
+    ['GEMBACK_TOP_K', '4.5', 'GEMBACK_TOP_K must be an integer, got "4.5"'],
+    ['GEMBACK_MAX_TOKENS', '0', 'GEMBACK_MAX_TOKENS must be at least 1, got 0'],
+  ])('should reject %s=%s', (name, value, message) => {
+    expect(() => configFromEnv({ [name]: value })).toThrow(message);
+  });
+});
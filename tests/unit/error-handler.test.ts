@@ -3,8 +3,16 @@ import {
   isRateLimitError,
   isRetryableError,
   isAuthError,
+  isBillingError,
   getErrorStatusCode,
+  getGrpcStatus,
+  defaultRetryPolicy,
 } from '../../src/utils/error-handler';
+import {
+  EmptyResponseError,
+  IncompleteResponseError,
+  ResponseValidationError,
+} from '../../src/types/errors';
 
 describe('error-handler utility', () => {
   describe('isRateLimitError', () => {
@@ -48,6 +56,15 @@ describe('error-handler utility', () => {
       expect(isRetryableError(new Error('429 Too Many Requests'))).toBe(true);
     });
 
+    it('should classify response errors by type, not message', () => {
+      expect(isRetryableError(new EmptyResponseError())).toBe(true);
+      expect(isRetryableError(new IncompleteResponseError('OTHER'))).toBe(true);
+      expect(isRetryableError(new ResponseValidationError('missing answer'))).toBe(true);
+      expect(isRetryableError(new Error('Response validation failed: missing answer'))).toBe(
+        false
+      );
+    });
+
     it('should return false for non-retryable errors', () => {
       expect(isRetryableError(new Error('400 Bad Request'))).toBe(false);
       expect(isRetryableError(new Error('401 Unauthorized'))).toBe(false);
@@ -75,6 +92,35 @@ describe('error-handler utility', () => {
     });
   });
 
+  describe('isBillingError', () => {
+    const billingError = (reason: string) =>
+      new Error(
+        `got status: 403 Forbidden. ${JSON.stringify({
+          error: {
+            code: 403,
+            status: 'PERMISSION_DENIED',
+            message: 'This API method requires billing to be enabled.',
+            details: [{ '@type': 'type.googleapis.com/google.rpc.ErrorInfo', reason }],
+          },
+        })}`
+      );
+
+    it('should detect billing reason codes in the error details', () => {
+      expect(isBillingError(billingError('BILLING_DISABLED'))).toBe(true);
+      expect(isBillingError(billingError('CONSUMER_SUSPENDED'))).toBe(true);
+    });
+
+    it('should detect legacy errors[].reason codes', () => {
+      const body = { error: { errors: [{ reason: 'billingNotEnabled' }] } };
+      expect(isBillingError(new Error(JSON.stringify(body)))).toBe(true);
+    });
+
+    it('should return false for other errors', () => {
+      expect(isBillingError(billingError('API_KEY_INVALID'))).toBe(false);
+      expect(isBillingError(new Error('403 Forbidden'))).toBe(false);
+    });
+  });
+
   describe('getErrorStatusCode', () => {
     it('should extract 4xx status codes', () => {
       expect(getErrorStatusCode(new Error('400 Bad Request'))).toBe(400);
@@ -98,4 +144,53 @@ describe('error-handler utility', () => {
       expect(getErrorStatusCode(new Error('Request failed with 404 not found'))).toBe(404);
     });
   });
+
+  describe('gRPC status', () => {
+    const withCode = (message: string, code: number) => Object.assign(new Error(message), { code });
+
+    it('should read the status from a numeric gRPC code', () => {
+      expect(getGrpcStatus(withCode('quota', 8))).toBe('RESOURCE_EXHAUSTED');
+      expect(getGrpcStatus(withCode('down', 14))).toBe('UNAVAILABLE');
+      expect(getGrpcStatus(withCode('boom', 2))).toBeUndefined();
+    });
+
+    it('should read the status from JSON bodies and messages', () => {
+      const json = JSON.stringify({ error: { message: 'Busy', status: 'UNAVAILABLE' } });
+      expect(getGrpcStatus(new Error(json))).toBe('UNAVAILABLE');
+      expect(getGrpcStatus(new Error('RESOURCE_EXHAUSTED: quota'))).toBe('RESOURCE_EXHAUSTED');
+      expect(getGrpcStatus(new Error('Internal Server Error'))).toBeUndefined();
+    });
+
+    it('should ignore non-status string properties', () => {
+      const error = Object.assign(new Error('odd'), { status: 'constructor' });
+      expect(getGrpcStatus(error)).toBeUndefined();
+    });
+
+    it('should classify gRPC statuses for retry and fallback', () => {
+      expect(isRateLimitError(withCode('quota', 8))).toBe(true);
+      expect(isRetryableError(withCode('down', 14))).toBe(true);
+      expect(isRetryableError(withCode('crash', 13))).toBe(true);
+      expect(isAuthError(withCode('who are you', 16))).toBe(true);
+      expect(isRetryableError(withCode('bad argument', 3))).toBe(false);
+    });
+
+    it('should map gRPC statuses and numeric status properties to HTTP codes', () => {
+      expect(getErrorStatusCode(withCode('quota', 8))).toBe(429);
+      expect(getErrorStatusCode(withCode('down', 14))).toBe(503);
+      expect(getErrorStatusCode(Object.assign(new Error('failed'), { status: 429 }))).toBe(429);
+    });
+  });
+
+  describe('defaultRetryPolicy', () => {
+    it('should never retry client errors other than 429', () => {
+      expect(defaultRetryPolicy(new Error('400 Bad Request: value must be < 5'))).toBe(false);
+      expect(defaultRetryPolicy(new Error('404 Not Found'))).toBe(false);
+    });
+
+    it('should retry server, rate limit and network errors', () => {
+      expect(defaultRetryPolicy(new Error('503 Service Unavailable'))).toBe(true);
+      expect(defaultRetryPolicy(new Error('429 Too Many Requests'))).toBe(true);
+      expect(defaultRetryPolicy(new Error('ECONNRESET'))).toBe(true);
+    });
+  });
 });
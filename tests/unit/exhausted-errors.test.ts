@@ -0,0 +1,102 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { GeminiBackError } from '../../src/types/errors';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Exhausted request errors', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn().mockRejectedValue(new Error('429 Rate limit exceeded')),
+      generateStream: vi.fn().mockImplementation(async function* () {
+        throw new Error('429 Rate limit exceeded');
+      }),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const catchError = async (promise: Promise<unknown>): Promise<GeminiBackError> => {
+    try {
+      await promise;
+    } catch (error) {
+      return error as GeminiBackError;
+    }
+    throw new Error('Expected the request to fail');
+  };
+
+  it('should report ALL_KEYS_EXHAUSTED when a pinned model runs out of keys', async () => {
+    const client = new GemBack({
+      apiKeys: ['key1', 'key2'],
+      maxRetries: 0,
+      attemptOrder: 'keys-first',
+    });
+
+    const error = await catchError(client.generate('Hello', { model: 'gemini-2.5-flash' }));
+
+    expect(error).toBeInstanceOf(GeminiBackError);
+    expect(error.code).toBe('ALL_KEYS_EXHAUSTED');
+    expect(error.modelAttempted).toBe('gemini-2.5-flash');
+    expect(error.message).toContain('gemini-2.5-flash');
+    expect(error.allAttempts).toHaveLength(2);
+  });
+
+  it('should report ALL_MODELS_FAILED when the fallback chain fails', async () => {
+    const client = new GemBack({
+      apiKey: 'test-key',
+      maxRetries: 0,
+      fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+    });
+
+    const error = await catchError(client.generate('Hello'));
+
+    expect(error.code).toBe('ALL_MODELS_FAILED');
+    expect(error.allAttempts.map((attempt) => attempt.model)).toEqual([
+      'gemini-2.5-flash',
+      'gemini-2.5-flash-lite',
+    ]);
+  });
+
+  it('should distinguish the two cases for streaming', async () => {
+    const client = new GemBack({ apiKey: 'test-key', maxRetries: 0 });
+    const drain = async (options?: { model: 'gemini-2.5-flash' }) => {
+      for await (const _chunk of client.generateStream('Hello', options)) {
+        // drain
+      }
+    };
+
+    expect((await catchError(drain({ model: 'gemini-2.5-flash' }))).code).toBe(
+      'ALL_KEYS_EXHAUSTED'
+    );
+    expect((await catchError(drain())).code).toBe('ALL_MODELS_FAILED');
+  });
+
+  it('should record the model, key, status and duration of every attempt', async () => {
+    mockGeminiClient.generate.mockRejectedValue(new Error('503 Service unavailable'));
+    const client = new GemBack({
+      apiKeys: ['key1', 'key2'],
+      maxRetries: 0,
+      fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+      attemptOrder: 'keys-first',
+    });
+
+    const error = await catchError(client.generate('Hello'));
+
+    expect(error.code).toBe('ALL_MODELS_FAILED');
+    expect(
+      error.allAttempts.map(({ model, keyIndex, statusCode }) => ({ model, keyIndex, statusCode }))
+    ).toEqual([
+      { model: 'gemini-2.5-flash', keyIndex: 0, statusCode: 503 },
+      { model: 'gemini-2.5-flash', keyIndex: 1, statusCode: 503 },
+      { model: 'gemini-2.5-flash-lite', keyIndex: 0, statusCode: 503 },
+      { model: 'gemini-2.5-flash-lite', keyIndex: 1, statusCode: 503 },
+    ]);
+    for (const attempt of error.allAttempts) {
+      expect(attempt.error).toBe('503 Service unavailable');
+      expect(attempt.durationMs).toBeGreaterThanOrEqual(0);
+    }
+  });
+});
@@ -1,7 +1,10 @@
 import { describe, it, expect, vi, beforeEach } from 'vitest';
 import { GemBack } from '../../src/client/FallbackClient';
 import { GeminiClient } from '../../src/client/GeminiClient';
-import { GeminiBackError } from '../../src/types/errors';
+import { GeminiBackError, AbortedError, ClientConfigError } from '../../src/types/errors';
+import { isIncompleteResponse } from '../../src/utils/finish-reason';
+import { getCurrentKeyInfo } from '../../src/utils/key-context';
+import type { StreamChunk } from '../../src/types/response';
 
 vi.mock('../../src/client/GeminiClient');
 
@@ -52,7 +55,7 @@ describe('GemBack', () => {
       const client = new GemBack({ apiKey: 'test-key' });
       const response = await client.generate('Hello');
 
-      expect(response).toEqual(mockResponse);
+      expect(response).toEqual({ ...mockResponse, fallbackDepth: 0 });
       expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
     });
 
@@ -138,7 +141,7 @@ describe('GemBack', () => {
       const client = new GemBack({ apiKey: 'test-key', maxRetries: 1 });
       const response = await client.generate('Hello');
 
-      expect(response).toEqual(successResponse);
+      expect(response).toEqual({ ...successResponse, fallbackDepth: 0 });
       // Called twice: first failed, second succeeded
       expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
     });
@@ -453,4 +456,1813 @@ describe('GemBack', () => {
       expect(chunks).toHaveLength(2); // 1 text + 1 complete
     });
   });
+
+  describe('gRPC status errors', () => {
+    const grpcError = (message: string, code: number) =>
+      Object.assign(new Error(message), { code });
+
+    it('should fall back immediately on RESOURCE_EXHAUSTED', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(grpcError('Quota for this model is used up', 8))
+        .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash-lite' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        retryDelay: 1,
+      });
+      await client.generate('Hello');
+
+      const models = mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1]);
+      expect(models).toEqual(['gemini-2.5-flash', 'gemini-2.5-flash-lite']);
+    });
+
+    it('should retry UNAVAILABLE on the same model', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(grpcError('The service is currently down', 14))
+        .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        retryDelay: 1,
+      });
+      await client.generate('Hello');
+
+      const models = mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1]);
+      expect(models).toEqual(['gemini-2.5-flash', 'gemini-2.5-flash']);
+    });
+  });
+
+  describe('structured contents', () => {
+    it('should send few-shot multi-role contents unchanged on every attempt', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+        .mockResolvedValueOnce({ text: 'negative', model: 'gemini-2.5-flash-lite' });
+
+      const contents = [
+        { role: 'user' as const, parts: [{ text: 'Review: I loved it' }] },
+        { role: 'model' as const, parts: [{ text: 'positive' }] },
+        { role: 'user' as const, parts: [{ text: 'Review: Waste of money' }] },
+        { role: 'model' as const, parts: [{ text: 'negative' }] },
+        { role: 'user' as const, parts: [{ text: 'Review: Broke after a day' }] },
+      ];
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+      });
+      const response = await client.generateContent({
+        contents,
+        systemInstruction: 'Classify the sentiment as positive or negative.',
+      });
+
+      expect(response.text).toBe('negative');
+      for (const call of mockGeminiClient.generateContent.mock.calls) {
+        expect(call[0]).toEqual(contents);
+        expect(call[3].systemInstruction).toBe('Classify the sentiment as positive or negative.');
+      }
+    });
+  });
+
+  describe('deadline', () => {
+    it('should skip a retry wait that would outlast the deadline and fall back', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('503 Service unavailable'))
+        .mockResolvedValueOnce({ text: 'Fallback response', model: 'gemini-2.5-flash-lite' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 2,
+        retryDelay: 5000,
+      });
+
+      const startTime = Date.now();
+      const response = await client.generate('Hello', { deadline: Date.now() + 1000 });
+
+      expect(response.text).toBe('Fallback response');
+      expect(Date.now() - startTime).toBeLessThan(1000);
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+
+    it('should stop with DEADLINE_EXCEEDED once the deadline has passed', async () => {
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const error = await client
+        .generate('Hello', { deadline: Date.now() - 1 })
+        .catch((err: GeminiBackError) => err);
+
+      expect(error).toBeInstanceOf(GeminiBackError);
+      expect((error as GeminiBackError).code).toBe('DEADLINE_EXCEEDED');
+      expect(mockGeminiClient.generate).not.toHaveBeenCalled();
+    });
+
+    it('should cap the attempt timeout at the time left before the deadline', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKey: 'test-key', timeout: 30000 });
+
+      await client.generate('Hello', { deadline: Date.now() + 300 });
+
+      const { timeout } = mockGeminiClient.generate.mock.calls[0][3];
+      expect(timeout).toBeGreaterThan(0);
+      expect(timeout).toBeLessThanOrEqual(300);
+    });
+
+    it('should skip attempts with less than minAttemptTimeMs left', async () => {
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        minAttemptTimeMs: 1000,
+      });
+
+      const error = await client
+        .generate('Hello', { deadline: Date.now() + 200 })
+        .catch((err: GeminiBackError) => err);
+
+      expect(error).toBeInstanceOf(GeminiBackError);
+      expect((error as GeminiBackError).allAttempts.map((attempt) => attempt.error)).toEqual([
+        expect.stringContaining('insufficient time remaining'),
+        expect.stringContaining('insufficient time remaining'),
+      ]);
+      expect(mockGeminiClient.generate).not.toHaveBeenCalled();
+    });
+  });
+
+  describe('configuration introspection', () => {
+    it('should return the effective config with API keys masked', () => {
+      const client = new GemBack({
+        apiKeys: ['AIzaSyKey-one-1111', 'AIzaSyKey-two-2222'],
+        maxRetries: 1,
+      });
+
+      const config = client.getConfig();
+
+      expect(config.apiKeys).toEqual(['****1111', '****2222']);
+      expect(config.apiKey).toBeUndefined();
+      expect(config.maxRetries).toBe(1);
+      expect(config.timeout).toBe(30000);
+      expect(JSON.stringify(config)).not.toContain('AIzaSy');
+    });
+
+    it('should not let callers modify the client configuration', () => {
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+      });
+
+      client.getConfig().fallbackOrder!.push('gemini-2.5-pro');
+      client.getFallbackOrder().pop();
+
+      expect(client.getFallbackOrder()).toEqual(['gemini-2.5-flash', 'gemini-2.5-flash-lite']);
+    });
+
+    it('should resolve aliases in the fallback order', () => {
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['flash', 'gemini-2.5-flash-lite'],
+        modelAliases: { flash: 'gemini-2.5-flash' },
+      });
+
+      expect(client.getFallbackOrder()).toEqual(['gemini-2.5-flash', 'gemini-2.5-flash-lite']);
+    });
+  });
+
+  describe('setFallbackOrder', () => {
+    it('should apply to new requests while in-flight requests keep their order', async () => {
+      let failFirstAttempt!: (error: Error) => void;
+      mockGeminiClient.generate.mockImplementation((_prompt: string, model: string) => {
+        if (mockGeminiClient.generate.mock.calls.length === 1) {
+          return new Promise((_, reject) => {
+            failFirstAttempt = reject;
+          });
+        }
+        return Promise.resolve({ text: 'ok', model });
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 0,
+      });
+
+      const inFlight = client.generate('First');
+      client.setFallbackOrder(['gemini-2.5-pro']);
+      const next = await client.generate('Second');
+      failFirstAttempt(new Error('429 Rate limit exceeded'));
+
+      expect(next.model).toBe('gemini-2.5-pro');
+      expect((await inFlight).model).toBe('gemini-2.5-flash-lite');
+      expect(client.getFallbackOrder()).toEqual(['gemini-2.5-pro']);
+    });
+
+    it('should reject an invalid order and keep the current one', () => {
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+      });
+
+      expect(() => client.setFallbackOrder([])).toThrow('at least one model');
+      expect(() => client.setFallbackOrder(['gemini-2.5-pro', ''])).toThrow('non-empty');
+      expect(client.getFallbackOrder()).toEqual(['gemini-2.5-flash']);
+    });
+  });
+
+  describe('key token quota', () => {
+    it('should rotate away from a key that used up its soft quota', async () => {
+      mockGeminiClient.generate.mockImplementation(
+        (_prompt: string, model: string, apiKey: string) =>
+          Promise.resolve({
+            text: apiKey,
+            model,
+            usage: { promptTokens: 400, completionTokens: 600, totalTokens: 1000 },
+          })
+      );
+
+      const client = new GemBack({
+        apiKeys: ['key-a', 'key-b'],
+        fallbackOrder: ['gemini-2.5-flash'],
+        keyQuota: { dailyTokens: [1000, 100000] },
+      });
+
+      const keys = [];
+      for (let i = 0; i < 4; i++) {
+        keys.push((await client.generate('Hello')).text);
+      }
+
+      expect(keys).toEqual(['key-a', 'key-b', 'key-b', 'key-b']);
+    });
+  });
+
+  describe('echoPrompt and labels', () => {
+    beforeEach(() => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Answer', model: 'gemini-2.5-flash' });
+    });
+
+    it('should copy the prompt and labels onto the response when requested', async () => {
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const response = await client.generate('What is 2+2?', {
+        echoPrompt: true,
+        labels: { requestId: 'req-42' },
+      });
+
+      expect(response.prompt).toBe('What is 2+2?');
+      expect(response.labels).toEqual({ requestId: 'req-42' });
+    });
+
+    it('should not retain the prompt by default', async () => {
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const response = await client.generate('What is 2+2?', { labels: { requestId: 'req-42' } });
+
+      expect(response).not.toHaveProperty('prompt');
+      expect(response.labels).toEqual({ requestId: 'req-42' });
+    });
+
+    it('should correlate batch results with their requests', async () => {
+      const client = new GemBack({ apiKey: 'test-key' });
+      const job = client.submitBatch([
+        { prompt: 'First', options: { labels: { id: '1' } } },
+        { prompt: 'Second', options: { labels: { id: '2' } } },
+      ]);
+
+      const ids = [];
+      for await (const result of job.results()) {
+        ids.push(result.response?.labels?.id);
+      }
+
+      expect(ids.sort()).toEqual(['1', '2']);
+    });
+  });
+
+  describe('generateBatchStream', () => {
+    it('should interleave chunks from concurrent streams tagged by request index', async () => {
+      mockGeminiClient.generateStream.mockImplementation(async function* (prompt: string) {
+        for (let i = 1; i <= 3; i++) {
+          await new Promise((resolve) => setTimeout(resolve, prompt === 'A' ? 5 : 8));
+          yield { text: `${prompt}${i}` };
+        }
+      });
+
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const tagged = [];
+      for await (const entry of client.generateBatchStream(['A', 'B'], { concurrency: 2 })) {
+        tagged.push(entry);
+      }
+
+      const textFor = (index: number) =>
+        tagged
+          .filter((entry) => entry.index === index)
+          .map((entry) => entry.chunk!.text)
+          .join('|');
+      expect(textFor(0)).toBe('A1|A2|A3|');
+      expect(textFor(1)).toBe('B1|B2|B3|');
+      // Both streams ran at once, so B's first chunk arrives before A finishes
+      const firstB = tagged.findIndex((entry) => entry.index === 1);
+      const lastA = tagged.map((entry) => entry.index).lastIndexOf(0);
+      expect(firstB).toBeLessThan(lastA);
+    });
+
+    it('should respect the concurrency limit and report failed streams', async () => {
+      let active = 0;
+      let maxActive = 0;
+      mockGeminiClient.generateStream.mockImplementation(async function* (prompt: string) {
+        active++;
+        maxActive = Math.max(maxActive, active);
+        try {
+          await new Promise((resolve) => setTimeout(resolve, 5));
+          if (prompt === 'bad') {
+            throw new Error('400 Bad request');
+          }
+          yield { text: prompt };
+        } finally {
+          active--;
+        }
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 0,
+      });
+
+      const tagged = [];
+      for await (const entry of client.generateBatchStream(['a', 'bad', 'c'], {
+        concurrency: 1,
+      })) {
+        tagged.push(entry);
+      }
+
+      expect(maxActive).toBe(1);
+      expect(tagged.filter((entry) => entry.error).map((entry) => entry.index)).toEqual([1]);
+      expect(tagged.filter((entry) => entry.chunk?.text).map((entry) => entry.index)).toEqual([
+        0, 2,
+      ]);
+    });
+  });
+
+  describe('incomplete responses', () => {
+    const incomplete = { text: 'The answer is', model: 'gemini-2.5-flash', finishReason: 'OTHER' };
+
+    it('should flag an OTHER finish reason as incomplete', async () => {
+      mockGeminiClient.generate.mockResolvedValue(incomplete);
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const response = await client.generate('Question');
+
+      expect(response).toEqual({ ...incomplete, fallbackDepth: 0 });
+      expect(isIncompleteResponse(response)).toBe(true);
+      expect(isIncompleteResponse({ ...response, finishReason: 'STOP' })).toBe(false);
+    });
+
+    it('should fall back on incomplete responses with retryOnIncomplete', async () => {
+      mockGeminiClient.generate.mockResolvedValueOnce(incomplete).mockResolvedValueOnce({
+        text: 'The answer is 42.',
+        model: 'gemini-2.5-flash-lite',
+        finishReason: 'STOP',
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 0,
+        retryOnIncomplete: true,
+      });
+
+      const response = await client.generate('Question');
+
+      expect(response.text).toBe('The answer is 42.');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+  });
+
+  describe('getCurrentKeyInfo', () => {
+    it('should expose the masked key and model of the running attempt', async () => {
+      const seen: unknown[] = [];
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+        await Promise.resolve();
+        seen.push(getCurrentKeyInfo());
+        if (seen.length === 1) {
+          throw new Error('429 Rate limit exceeded');
+        }
+        return { text: 'ok', model };
+      });
+
+      const client = new GemBack({
+        apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'],
+        fallbackOrder: ['gemini-2.5-flash'],
+        attemptOrder: 'keys-first',
+      });
+      await client.generate('Hello');
+
+      expect(seen).toEqual([
+        { keyIndex: 0, maskedKey: '****1111', model: 'gemini-2.5-flash' },
+        { keyIndex: 1, maskedKey: '****2222', model: 'gemini-2.5-flash' },
+      ]);
+      expect(getCurrentKeyInfo()).toBeUndefined();
+    });
+  });
+
+  describe('apiVersion', () => {
+    it('should create the underlying client with the configured API version', () => {
+      new GemBack({ apiKey: 'test-key', apiVersion: 'v1beta' });
+
+      expect(GeminiClient).toHaveBeenCalledWith(
+        30000,
+        expect.objectContaining({ apiVersion: 'v1beta' })
+      );
+    });
+  });
+
+  describe('keyStartStrategy', () => {
+    const apiKeys = ['key-0', 'key-1', 'key-2', 'key-3'];
+
+    beforeEach(() => {
+      mockGeminiClient.generate.mockImplementation(
+        async (_prompt: string, model: string, apiKey: string) => ({ text: apiKey, model })
+      );
+    });
+
+    it('should spread fresh clients across keys with random starts', async () => {
+      const counts: Record<string, number> = {};
+      for (let i = 0; i < 400; i++) {
+        // A new client per call, as in a serverless cold start
+        const client = new GemBack({ apiKeys, keyStartStrategy: 'random' });
+        const { text } = await client.generate('Hello');
+        counts[text] = (counts[text] ?? 0) + 1;
+      }
+
+      expect(Object.keys(counts).sort()).toEqual(apiKeys);
+      for (const count of Object.values(counts)) {
+        expect(count).toBeGreaterThan(50);
+      }
+    });
+
+    it('should always start on key 0 for fresh clients by default', async () => {
+      const keys = new Set<string>();
+      for (let i = 0; i < 20; i++) {
+        const client = new GemBack({ apiKeys });
+        keys.add((await client.generate('Hello')).text);
+      }
+
+      expect([...keys]).toEqual(['key-0']);
+    });
+
+    it('should derive the start key from keySeed with the hash strategy', async () => {
+      const startKey = async (keySeed: string) => {
+        const client = new GemBack({ apiKeys, keyStartStrategy: 'hash' });
+        return (await client.generate('Hello', { keySeed })).text;
+      };
+
+      expect(await startKey('tenant-a')).toBe(await startKey('tenant-a'));
+      const seeds = Array.from({ length: 40 }, (_, i) => `tenant-${i}`);
+      const keys = new Set(await Promise.all(seeds.map(startKey)));
+      expect(keys.size).toBe(apiKeys.length);
+    });
+  });
+
+  describe('validator', () => {
+    const requireAnswer = (response: { text: string }) => {
+      if (!response.text.includes('answer')) {
+        throw new Error('missing answer');
+      }
+    };
+
+    it('should retry when the validator rejects a response', async () => {
+      mockGeminiClient.generate
+        .mockResolvedValueOnce({ text: 'I am not sure', model: 'gemini-2.5-flash' })
+        .mockResolvedValueOnce({ text: 'The answer is 42', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        retryDelay: 1,
+        validator: requireAnswer,
+      });
+
+      const response = await client.generate('Question');
+
+      expect(response.text).toBe('The answer is 42');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+
+    it('should throw the last validation error when every attempt is rejected', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'No idea', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 0,
+        validator: requireAnswer,
+      });
+
+      const error = await client.generate('Question').catch((e) => e);
+
+      expect(error).toBeInstanceOf(GeminiBackError);
+      expect(error.code).toBe('VALIDATION_FAILED');
+      expect(error.message).toBe('Response validation failed: missing answer');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+  });
+
+  describe('per-request fallbackOrder', () => {
+    it('should try the request order instead of the client order', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('503 Service Unavailable'))
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash-lite'],
+        maxRetries: 0,
+      });
+
+      await client.generate('Hello', {
+        model: 'gemini-2.5-flash-lite',
+        fallbackOrder: ['gemini-2.5-pro', 'gemini-2.5-flash'],
+      });
+
+      const models = mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1]);
+      expect(models).toEqual(['gemini-2.5-pro', 'gemini-2.5-flash']);
+    });
+  });
+
+  describe('token budget', () => {
+    const withUsage = (totalTokens: number) => ({
+      text: 'ok',
+      model: 'gemini-2.5-flash',
+      usage: { promptTokens: totalTokens - 10, completionTokens: 10, totalTokens },
+    });
+
+    it('should accumulate usage across requests and batches', async () => {
+      mockGeminiClient.generate.mockResolvedValue(withUsage(50));
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      await client.generate('Hello');
+      await client.submitBatch(['a', 'b']).wait();
+
+      expect(client.getTotalUsage()).toEqual({
+        promptTokens: 120,
+        completionTokens: 30,
+        totalTokens: 150,
+        cachedTokens: 0,
+      });
+    });
+
+    it('should fail further calls once maxTotalTokens is used up', async () => {
+      mockGeminiClient.generate.mockResolvedValue(withUsage(60));
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxTotalTokens: 100,
+      });
+
+      await client.generate('first');
+      await client.generate('second'); // 60 < 100 before the call, 120 after
+
+      const error = await client.generate('third').catch((e) => e);
+      expect(error).toBeInstanceOf(GeminiBackError);
+      expect(error.code).toBe('BUDGET_EXCEEDED');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+
+    it('should enforce maxTotalTokens on generateOnce', async () => {
+      mockGeminiClient.generate.mockResolvedValue(withUsage(120));
+      const client = new GemBack({ apiKey: 'test-key', maxTotalTokens: 100 });
+
+      await client.generateOnce('first');
+      const error = await client.generateOnce('second').catch((e) => e);
+
+      expect(error.code).toBe('BUDGET_EXCEEDED');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+  });
+
+  describe('trimOutput', () => {
+    beforeEach(() => {
+      mockGeminiClient.generate.mockResolvedValue({
+        text: '\n\n{"ok": true}\n',
+        model: 'gemini-2.5-flash',
+        content: { textParts: ['\n\n{"ok": true}\n'], functionCalls: [], blobs: [] },
+      });
+    });
+
+    it('should trim whitespace around the text when enabled', async () => {
+      const client = new GemBack({ apiKey: 'test-key', trimOutput: true });
+
+      const response = await client.generate('Hello');
+
+      expect(response.text).toBe('{"ok": true}');
+      expect(response.content?.textParts).toEqual(['\n\n{"ok": true}\n']);
+    });
+
+    it('should leave the text as returned by default or when overridden per request', async () => {
+      const client = new GemBack({ apiKey: 'test-key', trimOutput: true });
+      const untrimmed = new GemBack({ apiKey: 'test-key' });
+
+      expect((await client.generate('Hello', { trimOutput: false })).text).toBe(
+        '\n\n{"ok": true}\n'
+      );
+      expect((await untrimmed.generate('Hello')).text).toBe('\n\n{"ok": true}\n');
+    });
+  });
+
+  describe('warmup', () => {
+    it('should warm up a client for every key', async () => {
+      mockGeminiClient.warmup = vi.fn();
+      const client = new GemBack({ apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'] });
+
+      await client.warmup();
+
+      expect(mockGeminiClient.warmup.mock.calls).toEqual([['key-aaaa-1111'], ['key-bbbb-2222']]);
+    });
+
+    it('should aggregate ping failures by masked key', async () => {
+      mockGeminiClient.warmup = vi.fn();
+      mockGeminiClient.validateApiKey = vi.fn(async (key: string) => {
+        if (key === 'key-bbbb-2222') {
+          throw new Error('Network error');
+        }
+        return key !== 'key-cccc-3333';
+      });
+      const client = new GemBack({
+        apiKeys: ['key-aaaa-1111', 'key-bbbb-2222', 'key-cccc-3333'],
+      });
+
+      const error = await client.warmup({ ping: true }).catch((e) => e);
+
+      expect(error).toBeInstanceOf(GeminiBackError);
+      expect(error.code).toBe('WARMUP_FAILED');
+      expect(error.message).toBe(
+        'Warmup failed for 2 of 3 API key(s): ****2222: Network error; ****3333: Invalid API key'
+      );
+    });
+  });
+
+  describe('client configuration errors', () => {
+    it('should stop rotating keys and models on a ClientConfigError', async () => {
+      mockGeminiClient.generate.mockRejectedValue(
+        new ClientConfigError('Could not create the Gemini client: Unsupported apiVersion: v9')
+      );
+      const client = new GemBack({
+        apiKeys: ['key1', 'key2'],
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        attemptOrder: 'keys-first',
+      });
+
+      const error = await client.generate('Hello').catch((e) => e);
+
+      expect(error).toBeInstanceOf(ClientConfigError);
+      expect(error.allAttempts).toHaveLength(1);
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+
+    it('should keep rotating on other errors', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('503 Service unavailable'))
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({
+        apiKeys: ['key1', 'key2'],
+        fallbackOrder: ['gemini-2.5-flash'],
+        attemptOrder: 'keys-first',
+        maxRetries: 0,
+      });
+
+      await expect(client.generate('Hello')).resolves.toMatchObject({ text: 'ok' });
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+  });
+
+  describe('sanitizeOutput', () => {
+    it('should strip a BOM and control characters from the text', async () => {
+      mockGeminiClient.generate.mockResolvedValue({
+        text: '﻿{"ok":\u0000 true}\n\tdone\u0007',
+        model: 'gemini-2.5-flash',
+      });
+      const client = new GemBack({ apiKey: 'test-key', sanitizeOutput: true });
+
+      const response = await client.generate('Hello');
+
+      expect(response.text).toBe('{"ok": true}\n\tdone');
+      expect(JSON.parse(response.text.split('\n')[0])).toEqual({ ok: true });
+    });
+
+    it('should sanitize streaming chunks', async () => {
+      mockGeminiClient.generateStream.mockImplementation(async function* () {
+        yield { text: '﻿Hello' };
+        yield { text: ' world\u001B' };
+      });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        sanitizeOutput: true,
+      });
+
+      let text = '';
+      for await (const chunk of client.generateStream('Hi')) {
+        text += chunk.text;
+      }
+
+      expect(text).toBe('Hello world');
+    });
+
+    it('should leave the text untouched by default', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: '﻿Hi', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      expect((await client.generate('Hello')).text).toBe('﻿Hi');
+    });
+  });
+
+  describe('clone', () => {
+    it('should apply overrides without mutating the parent', () => {
+      const parent = new GemBack({
+        apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'],
+        fallbackOrder: ['gemini-2.5-pro', 'gemini-2.5-flash'],
+        timeout: 30000,
+      });
+
+      const child = parent.clone({ fallbackOrder: ['gemini-2.5-flash'], timeout: 5000 });
+
+      expect(child.getFallbackOrder()).toEqual(['gemini-2.5-flash']);
+      expect(child.getConfig()).toMatchObject({
+        timeout: 5000,
+        apiKeys: ['****1111', '****2222'],
+      });
+      expect(parent.getFallbackOrder()).toEqual(['gemini-2.5-pro', 'gemini-2.5-flash']);
+      expect(parent.getConfig().timeout).toBe(30000);
+    });
+
+    it('should share the SDK client pool but keep its own rotation and stats', async () => {
+      const parentClient = { generate: vi.fn().mockResolvedValue({ text: 'ok', model: 'm' }) };
+      vi.mocked(GeminiClient)
+        .mockImplementationOnce(() => parentClient as any)
+        .mockImplementationOnce(() => ({ generate: vi.fn() }) as any);
+      const parent = new GemBack({
+        apiKeys: ['key1', 'key2'],
+        fallbackOrder: ['gemini-2.5-flash'],
+      });
+      await parent.generate('Hello');
+
+      const child = parent.clone({ maxRetries: 0 });
+      await child.generate('Hello');
+
+      expect(parentClient.generate.mock.calls.map((call: unknown[]) => call[2])).toEqual([
+        'key1',
+        'key1',
+      ]);
+      expect(parent.getFallbackStats().totalRequests).toBe(1);
+      expect(child.getFallbackStats().totalRequests).toBe(1);
+    });
+  });
+
+  describe('fallback depth', () => {
+    it('should report how far down the chain the request succeeded', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('503 Service Unavailable'))
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash-lite' });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 0,
+      });
+
+      const response = await client.generate('Hello');
+
+      expect(response.fallbackDepth).toBe(1);
+      expect(client.getFallbackStats().fallbackDepths).toEqual({ 1: 1 });
+    });
+  });
+
+  describe('generateCompare', () => {
+    it('should run the prompt on each model and collect per-model errors', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+        if (model === 'gemini-2.5-pro') {
+          throw new Error('400 Bad Request');
+        }
+        return { text: `from ${model}`, model };
+      });
+      const client = new GemBack({ apiKey: 'test-key', maxRetries: 0 });
+
+      const results = await client.generateCompare('Hello', ['gemini-2.5-flash', 'gemini-2.5-pro']);
+
+      expect(results['gemini-2.5-flash'].response?.text).toBe('from gemini-2.5-flash');
+      expect(results['gemini-2.5-pro'].error).toBeInstanceOf(GeminiBackError);
+      expect(mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1])).toEqual([
+        'gemini-2.5-flash',
+        'gemini-2.5-pro',
+      ]);
+    });
+  });
+
+  describe('generateCheapest', () => {
+    const usage = { promptTokens: 1000, completionTokens: 1000, totalTokens: 2000 };
+    const modelPricing = {
+      'gemini-2.5-pro': { input: 1.25, output: 10 },
+      'gemini-2.5-flash': { input: 0.3, output: 2.5 },
+      'gemini-2.5-flash-lite': { input: 0.1, output: 0.4 },
+    };
+    const delays: Record<string, number> = {
+      'gemini-2.5-pro': 0,
+      'gemini-2.5-flash': 20,
+      'gemini-2.5-flash-lite': 300,
+    };
+
+    beforeEach(() => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+        await new Promise((resolve) => setTimeout(resolve, delays[model]));
+        return { text: `from ${model}`, model, usage };
+      });
+    });
+
+    it('should return the cheapest model that succeeds within the window', async () => {
+      const client = new GemBack({ apiKey: 'test-key', modelPricing });
+
+      const response = await client.generateCheapest(
+        'Hello',
+        ['gemini-2.5-pro', 'gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        { windowMs: 100 }
+      );
+
+      // flash-lite is cheaper still, but answers after the window closes
+      expect(response.text).toBe('from gemini-2.5-flash');
+    });
+
+    it('should fall back to the fastest response when prices are unknown', async () => {
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const models = ['gemini-2.5-flash', 'gemini-2.5-pro'];
+      const response = await client.generateCheapest('Hello', models, { windowMs: 100 });
+
+      expect(response.text).toBe('from gemini-2.5-pro');
+    });
+
+    it('should cancel candidates still running when the window closes', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+        if (model === 'gemini-2.5-pro') {
+          return { text: `from ${model}`, model, usage };
+        }
+        await new Promise((resolve) => setTimeout(resolve, 10));
+        throw new Error('503 Service Unavailable');
+      });
+      const client = new GemBack({ apiKey: 'test-key', maxRetries: 3, retryDelay: 50 });
+
+      const models = ['gemini-2.5-pro', 'gemini-2.5-flash'];
+      const response = await client.generateCheapest('Hello', models, { windowMs: 30 });
+      await new Promise((resolve) => setTimeout(resolve, 200));
+
+      expect(response.text).toBe('from gemini-2.5-pro');
+      // flash's first retry was due after the window closed, so it never ran
+      const flashCalls = mockGeminiClient.generate.mock.calls.filter(
+        (call: any[]) => call[1] === 'gemini-2.5-flash'
+      );
+      expect(flashCalls).toHaveLength(1);
+    });
+
+    it('should throw when every model fails', async () => {
+      mockGeminiClient.generate.mockRejectedValue(new Error('400 Bad Request'));
+      const client = new GemBack({ apiKey: 'test-key', maxRetries: 0, modelPricing });
+
+      await expect(
+        client.generateCheapest('Hello', ['gemini-2.5-flash', 'gemini-2.5-pro'])
+      ).rejects.toMatchObject({ code: 'ALL_MODELS_FAILED' });
+    });
+  });
+
+  describe('generateOnce', () => {
+    it('should make a single attempt and throw the raw error', async () => {
+      const apiError = new Error('503 Service Unavailable');
+      mockGeminiClient.generate.mockRejectedValue(apiError);
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      await expect(client.generateOnce('Hello')).rejects.toBe(apiError);
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+      expect(mockGeminiClient.generate).toHaveBeenCalledWith(
+        'Hello',
+        'gemini-3-flash-preview',
+        'test-key',
+        undefined
+      );
+    });
+
+    it('should return the response without recording fallback stats', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Hi', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const response = await client.generateOnce('Hello', { model: 'gemini-2.5-flash' });
+
+      expect(response.text).toBe('Hi');
+      expect(client.getFallbackStats().totalRequests).toBe(0);
+    });
+  });
+
+  describe('per-error backoff', () => {
+    it('should use the rate limit and server error backoffs for their errors', async () => {
+      const setTimeoutSpy = vi.spyOn(global, 'setTimeout');
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('429 Too Many Requests'))
+        .mockRejectedValueOnce(new Error('503 Service Unavailable'))
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 2,
+        retryDelay: 1000,
+        rateLimitBackoff: { delay: 7 },
+        serverErrorBackoff: { delay: 3 },
+      });
+
+      const response = await client.generate('Hello');
+
+      expect(response.text).toBe('ok');
+      const delays = setTimeoutSpy.mock.calls.map((call) => call[1]);
+      // 429 on the first attempt: 7ms; 503 on the second: 3ms doubled
+      expect(delays).toEqual(expect.arrayContaining([7, 6]));
+      expect(delays).not.toContain(1000);
+      setTimeoutSpy.mockRestore();
+    });
+
+    it('should not retry 429s on the same key without a rate limit backoff', async () => {
+      mockGeminiClient.generate.mockRejectedValue(new Error('429 Too Many Requests'));
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 2,
+        serverErrorBackoff: { delay: 3 },
+      });
+
+      await expect(client.generate('Hello')).rejects.toThrow();
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+  });
+
+  describe('abort', () => {
+    it('should fail every in-flight request and keep the client usable', async () => {
+      mockGeminiClient.generate.mockImplementation(() => new Promise(() => {}));
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const inFlight = [client.generate('First'), client.generate('Second')].map((request) =>
+        request.catch((e) => e)
+      );
+      await Promise.resolve();
+      client.abort();
+      const errors = await Promise.all(inFlight);
+
+      for (const error of errors) {
+        expect(error).toBeInstanceOf(GeminiBackError);
+        expect(error.code).toBe('ABORTED');
+      }
+
+      mockGeminiClient.generate.mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+      await expect(client.generate('Third')).resolves.toMatchObject({ text: 'ok' });
+    });
+
+    it('should stop a stream between chunks', async () => {
+      mockGeminiClient.generateStream.mockImplementation(async function* () {
+        yield { text: 'Hello' };
+        yield { text: ' world' };
+      });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const chunks: string[] = [];
+      const error = await (async () => {
+        for await (const chunk of client.generateStream('Hi')) {
+          chunks.push(chunk.text);
+          client.abort();
+        }
+      })().catch((e) => e);
+
+      expect(chunks).toEqual(['Hello']);
+      expect(error.code).toBe('ABORTED');
+    });
+
+    it('should not wait out a key pool cooldown once aborted', async () => {
+      mockGeminiClient.generate.mockRejectedValue(new Error('429 Rate limit exceeded'));
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 0,
+        poolRetries: 1,
+        poolRetryDelay: 60000,
+      });
+
+      const request = client.generate('Hello').catch((e) => e);
+      await new Promise((resolve) => setTimeout(resolve, 10));
+      client.abort();
+
+      expect((await request).code).toBe('ABORTED');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+  });
+
+  describe('stripMarkdown', () => {
+    it('should strip markdown from text but leave content parts untouched', async () => {
+      const raw = '## Answer\n**Yes**, it works.';
+      mockGeminiClient.generate.mockResolvedValue({
+        text: raw,
+        model: 'gemini-2.5-flash',
+        content: { textParts: [raw], functionCalls: [], blobs: [] },
+      });
+      const client = new GemBack({ apiKey: 'test-key', stripMarkdown: true });
+
+      const response = await client.generate('Does it work?');
+      const unstripped = await client.generate('Does it work?', { stripMarkdown: false });
+
+      expect(response.text).toBe('Answer\nYes, it works.');
+      expect(response.content?.textParts).toEqual([raw]);
+      expect(unstripped.text).toBe(raw);
+    });
+  });
+
+  describe('maxConcurrency', () => {
+    it('should never run more than maxConcurrency requests at once', async () => {
+      let running = 0;
+      let peak = 0;
+      mockGeminiClient.generate.mockImplementation(async () => {
+        running++;
+        peak = Math.max(peak, running);
+        await new Promise((resolve) => setTimeout(resolve, 5));
+        running--;
+        return { text: 'ok', model: 'gemini-2.5-flash' };
+      });
+      const client = new GemBack({ apiKey: 'test-key', maxConcurrency: 2 });
+
+      const job = client.submitBatch(['A', 'B', 'C'], { concurrency: 3 });
+      const responses = await Promise.all([
+        client.generate('D'),
+        client.generate('E'),
+        job.wait(),
+      ]);
+
+      expect(peak).toBe(2);
+      expect(responses[0].text).toBe('ok');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(5);
+    });
+
+    it('should fail requests waiting for a slot when aborted', async () => {
+      mockGeminiClient.generate.mockImplementation(() => new Promise(() => {}));
+      const client = new GemBack({ apiKey: 'test-key', maxConcurrency: 1 });
+
+      const running = client.generate('First').catch((e) => e);
+      const waiting = client.generate('Second').catch((e) => e);
+      await vi.waitFor(() => expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1));
+      client.abort();
+
+      expect((await waiting).code).toBe('ABORTED');
+      expect((await running).code).toBe('ABORTED');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+  });
+
+  describe('modelRouter', () => {
+    it('should try the models the router picks for the prompt', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => ({
+        text: 'ok',
+        model,
+      }));
+      const modelRouter = vi.fn(({ prompt }: { prompt?: string }) =>
+        prompt && prompt.length > 20 ? ['gemini-2.5-pro'] : ['gemini-2.5-flash-lite']
+      );
+      const client = new GemBack({ apiKey: 'test-key', modelRouter });
+
+      const long = await client.generate('Summarize this rather long document, please.', {
+        fallbackOrder: ['gemini-2.5-flash'],
+      });
+      const short = await client.generate('Hi');
+
+      expect(long.model).toBe('gemini-2.5-pro');
+      expect(short.model).toBe('gemini-2.5-flash-lite');
+      expect(modelRouter).toHaveBeenCalledWith(
+        expect.objectContaining({ fallbackOrder: ['gemini-2.5-flash'] })
+      );
+    });
+
+    it('should leave pinned models alone, so generateCompare runs each model', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => ({
+        text: 'ok',
+        model,
+      }));
+      const modelRouter = vi.fn(() => ['gemini-2.5-pro']);
+      const client = new GemBack({ apiKey: 'test-key', modelRouter });
+
+      const results = await client.generateCompare('Hello', [
+        'gemini-2.5-flash',
+        'gemini-2.5-flash-lite',
+      ]);
+
+      expect(results['gemini-2.5-flash'].response?.model).toBe('gemini-2.5-flash');
+      expect(results['gemini-2.5-flash-lite'].response?.model).toBe('gemini-2.5-flash-lite');
+      expect(modelRouter).not.toHaveBeenCalled();
+    });
+
+    it('should keep the usual selection when the router returns nothing', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKey: 'test-key', modelRouter: () => undefined });
+
+      await client.generateContent({
+        contents: [{ role: 'user', parts: [{ text: 'Hi' }] }],
+        model: 'gemini-2.5-flash',
+      });
+
+      expect(mockGeminiClient.generateContent).toHaveBeenCalledWith(
+        expect.anything(),
+        'gemini-2.5-flash',
+        'test-key',
+        expect.anything()
+      );
+    });
+  });
+
+  describe('countContentTokens', () => {
+    it('should send every part, including images, to the token count call', async () => {
+      mockGeminiClient.countTokens = vi.fn().mockResolvedValue(1290);
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+      const contents = [
+        {
+          role: 'user' as const,
+          parts: [
+            { text: 'Describe this image' },
+            { inlineData: { mimeType: 'image/png', data: 'iVBORw0KGgo=' } },
+            { fileData: { mimeType: 'application/pdf', fileUri: 'files/abc' } },
+          ],
+        },
+      ];
+
+      const tokens = await client.countContentTokens({ contents, model: 'gemini-2.5-pro' });
+
+      expect(tokens).toBe(1290);
+      expect(mockGeminiClient.countTokens).toHaveBeenCalledWith(
+        contents,
+        'gemini-2.5-pro',
+        'test-key'
+      );
+    });
+  });
+
+  describe('missing usage', () => {
+    it('should leave usage undefined instead of reporting zero tokens', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Answer', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const response = await client.generate('Question');
+
+      expect(response.usage).toBeUndefined();
+      expect(client.getTotalUsage().totalTokens).toBe(0);
+    });
+
+    it('should estimate usage locally with estimateMissingUsage', async () => {
+      mockGeminiClient.generate.mockResolvedValue({
+        text: 'Forty-two, of course.',
+        model: 'gemini-2.5-flash',
+      });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        estimateMissingUsage: true,
+      });
+
+      const response = await client.generate('What is the answer?');
+
+      expect(response.usage).toEqual({
+        promptTokens: 5,
+        completionTokens: 6,
+        totalTokens: 11,
+        estimated: true,
+      });
+      expect(client.getTotalUsage().totalTokens).toBe(11);
+    });
+  });
+
+  describe('generateText', () => {
+    it('should return only the response text', async () => {
+      mockGeminiClient.generate.mockResolvedValue({
+        text: 'Paris',
+        model: 'gemini-2.5-flash',
+        finishReason: 'STOP',
+      });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      await expect(client.generateText('Capital of France?')).resolves.toBe('Paris');
+    });
+
+    it('should pin the model with generateTextWithModel', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Paris', model: 'gemini-2.5-pro' });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const text = await client.generateTextWithModel('Capital of France?', 'gemini-2.5-pro', {
+        temperature: 0,
+      });
+
+      expect(text).toBe('Paris');
+      expect(mockGeminiClient.generate).toHaveBeenCalledWith(
+        'Capital of France?',
+        'gemini-2.5-pro',
+        'test-key',
+        expect.objectContaining({ temperature: 0 })
+      );
+    });
+
+    it('should throw when every model fails', async () => {
+      mockGeminiClient.generate.mockRejectedValue(new Error('500 Internal Server Error'));
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 0,
+      });
+
+      await expect(client.generateText('Hi')).rejects.toBeInstanceOf(GeminiBackError);
+    });
+  });
+
+  describe('abort with partial output', () => {
+    it('should report the text streamed before the abort', async () => {
+      let releaseLast: () => void = () => {};
+      mockGeminiClient.generateStream.mockImplementation(async function* () {
+        yield { text: 'Once upon ' };
+        yield { text: 'a time' };
+        // Never delivered: the stream is aborted while waiting for it
+        await new Promise<void>((resolve) => (releaseLast = resolve));
+        yield { text: ' there was' };
+      });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const chunks: string[] = [];
+      const consumed = (async () => {
+        for await (const chunk of client.generateStream('Tell a story')) {
+          chunks.push(chunk.text);
+        }
+      })().catch((e) => e);
+      await vi.waitFor(() => expect(chunks).toHaveLength(2));
+      client.abort();
+      const error = await consumed;
+      releaseLast();
+
+      expect(error).toBeInstanceOf(AbortedError);
+      expect(error.code).toBe('ABORTED');
+      expect(error.partialText).toBe('Once upon a time');
+      expect(chunks).toEqual(['Once upon ', 'a time']);
+    });
+
+    it('should cancel one stream by its signal while another completes', async () => {
+      let releaseOther: () => void = () => {};
+      mockGeminiClient.generateStream.mockImplementation(async function* (prompt: string) {
+        if (prompt === 'Tell a story') {
+          yield { text: 'Once upon ' };
+          yield { text: 'a time' };
+          await new Promise(() => {});
+        }
+        yield { text: 'one ' };
+        await new Promise<void>((resolve) => (releaseOther = resolve));
+        yield { text: 'two' };
+      });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+      const controller = new AbortController();
+
+      const story: string[] = [];
+      const cancelled = (async () => {
+        for await (const chunk of client.generateStream('Tell a story', {
+          signal: controller.signal,
+        })) {
+          story.push(chunk.text);
+        }
+      })().catch((e) => e);
+      const counted: string[] = [];
+      const other = (async () => {
+        for await (const chunk of client.generateStream('Count')) {
+          counted.push(chunk.text);
+        }
+      })();
+      await vi.waitFor(() => expect(story).toHaveLength(2));
+      await vi.waitFor(() => expect(counted).toHaveLength(1));
+
+      controller.abort();
+      const error = await cancelled;
+      releaseOther();
+      await other;
+
+      expect(error).toBeInstanceOf(AbortedError);
+      expect(error.partialText).toBe('Once upon a time');
+      expect(counted).toEqual(['one ', 'two', '']);
+    });
+
+    it('should leave partialText empty for non-streaming requests', async () => {
+      mockGeminiClient.generate.mockImplementation(() => new Promise(() => {}));
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const pending = client.generate('Hi').catch((e) => e);
+      await vi.waitFor(() => expect(mockGeminiClient.generate).toHaveBeenCalled());
+      client.abort();
+
+      expect((await pending).partialText).toBe('');
+    });
+  });
+
+  describe('retryCounts', () => {
+    it('should retry each status code its configured number of times on the same key', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+        throw new Error(
+          model === 'gemini-2.5-flash' ? '503 Service Unavailable' : '500 Internal Server Error'
+        );
+      });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 1,
+        retryDelay: 1,
+        retryCounts: { 503: 2, 500: 0 },
+      });
+
+      await expect(client.generate('Hello')).rejects.toThrow(GeminiBackError);
+
+      const models = mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1]);
+      expect(models).toEqual([
+        'gemini-2.5-flash',
+        'gemini-2.5-flash',
+        'gemini-2.5-flash',
+        'gemini-2.5-flash-lite',
+      ]);
+    });
+
+    it('should retry 429s on the same key when a count is set for them', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('429 Too Many Requests'))
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({
+        apiKeys: ['key-1', 'key-2'],
+        fallbackOrder: ['gemini-2.5-flash'],
+        retryDelay: 1,
+        retryCounts: { 429: 1 },
+      });
+
+      await client.generate('Hello');
+
+      const keys = mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[2]);
+      expect(keys).toEqual(['key-1', 'key-1']);
+    });
+  });
+
+  describe('generateFromMessages', () => {
+    it('should send OpenAI-style messages as contents and system instruction', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockResolvedValue({ text: 'A typed superset of JS.', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const response = await client.generateFromMessages(
+        [
+          { role: 'system', content: 'Be terse.' },
+          { role: 'user', content: 'Hello' },
+          { role: 'assistant', content: 'Hi.' },
+          { role: 'user', content: 'What is TypeScript?' },
+        ],
+        { temperature: 0 }
+      );
+
+      expect(response.text).toBe('A typed superset of JS.');
+      expect(mockGeminiClient.generateContent).toHaveBeenCalledWith(
+        [
+          { role: 'user', parts: [{ text: 'Hello' }] },
+          { role: 'model', parts: [{ text: 'Hi.' }] },
+          { role: 'user', parts: [{ text: 'What is TypeScript?' }] },
+        ],
+        'gemini-2.5-flash',
+        'test-key',
+        expect.objectContaining({ systemInstruction: 'Be terse.', temperature: 0 })
+      );
+    });
+
+    it('should reject empty and system-only message lists before any API call', async () => {
+      mockGeminiClient.generateContent = vi.fn();
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const empty = await client.generateFromMessages([]).catch((e) => e);
+      const systemOnly = await client
+        .generateFromMessages([{ role: 'system', content: 'Be terse.' }])
+        .catch((e) => e);
+
+      expect(empty.code).toBe('INVALID_REQUEST');
+      expect(systemOnly.code).toBe('INVALID_REQUEST');
+      expect(systemOnly.message).toContain('Only system messages');
+      expect(mockGeminiClient.generateContent).not.toHaveBeenCalled();
+    });
+  });
+
+  describe('streamIdleTimeout', () => {
+    it('should fail a stalled stream with STREAM_IDLE_TIMEOUT', async () => {
+      mockGeminiClient.generateStream.mockImplementation(async function* () {
+        yield { text: 'Once' };
+        await new Promise(() => {}); // Stalls forever
+      });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        streamIdleTimeout: 20,
+      });
+
+      const chunks: string[] = [];
+      const error = await (async () => {
+        for await (const chunk of client.generateStream('Tell a story')) {
+          chunks.push(chunk.text);
+        }
+      })().catch((e) => e);
+
+      expect(chunks).toEqual(['Once']);
+      expect(error).toBeInstanceOf(GeminiBackError);
+      expect(error.code).toBe('STREAM_IDLE_TIMEOUT');
+      expect(error.allAttempts[0].error).toContain('no chunk received within 20ms');
+    });
+
+    it('should not time out a long stream that keeps producing chunks', async () => {
+      mockGeminiClient.generateStream.mockImplementation(async function* () {
+        for (let i = 0; i < 5; i++) {
+          await new Promise((resolve) => setTimeout(resolve, 10));
+          yield { text: `${i}` };
+        }
+      });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        streamIdleTimeout: 30,
+      });
+
+      let text = '';
+      for await (const chunk of client.generateStream('Count')) {
+        text += chunk.text;
+      }
+
+      expect(text).toBe('01234');
+    });
+  });
+
+  describe('modelWeights', () => {
+    it('should pick the first model in proportion to its weight', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => ({
+        text: 'ok',
+        model,
+      }));
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite', 'gemini-2.5-pro'],
+        modelWeights: { 'gemini-2.5-flash': 3, 'gemini-2.5-flash-lite': 1 },
+      });
+
+      const counts: Record<string, number> = {};
+      for (let i = 0; i < 2000; i++) {
+        const { model } = await client.generate('Hi');
+        counts[model] = (counts[model] ?? 0) + 1;
+      }
+
+      expect(counts['gemini-2.5-flash'] / 2000).toBeCloseTo(0.75, 1);
+      expect(counts['gemini-2.5-flash-lite'] / 2000).toBeCloseTo(0.25, 1);
+      expect(counts['gemini-2.5-pro']).toBeUndefined();
+    });
+
+    it('should fall back through the rest of the order after the weighted pick', async () => {
+      const random = vi.spyOn(Math, 'random').mockReturnValue(0.9); // Lands on flash-lite
+      try {
+        mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+          if (model === 'gemini-2.5-flash-lite') {
+            throw new Error('503 Service Unavailable');
+          }
+          return { text: 'ok', model };
+        });
+        const client = new GemBack({
+          apiKey: 'test-key',
+          fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite', 'gemini-2.5-pro'],
+          modelWeights: { 'gemini-2.5-flash': 3, 'gemini-2.5-flash-lite': 1 },
+          maxRetries: 0,
+        });
+
+        const response = await client.generate('Hi');
+
+        expect(response.model).toBe('gemini-2.5-flash');
+        expect(mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1])).toEqual([
+          'gemini-2.5-flash-lite',
+          'gemini-2.5-flash',
+        ]);
+      } finally {
+        random.mockRestore();
+      }
+    });
+
+    it('should resolve aliases used as weight keys', async () => {
+      const random = vi.spyOn(Math, 'random').mockReturnValue(0.9); // Lands on flash-lite
+      try {
+        mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => ({
+          text: 'ok',
+          model,
+        }));
+        const client = new GemBack({
+          apiKey: 'test-key',
+          fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+          modelAliases: { fast: 'gemini-2.5-flash', lite: 'gemini-2.5-flash-lite' },
+          modelWeights: { fast: 3, lite: 1 },
+        });
+
+        const response = await client.generate('Hi');
+
+        expect(response.model).toBe('gemini-2.5-flash-lite');
+      } finally {
+        random.mockRestore();
+      }
+    });
+  });
+
+  describe('raw stream responses', () => {
+    it('should pass each raw SDK response through to stream chunks', async () => {
+      const raw = { text: 'Hello', candidates: [{ safetyRatings: [] }] };
+      mockGeminiClient.generateStream.mockImplementation(async function* () {
+        yield { text: 'Hello', raw };
+      });
+      const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+      const chunks: StreamChunk[] = [];
+      for await (const chunk of client.generateStream('Hi')) {
+        chunks.push(chunk);
+      }
+
+      expect(chunks[0].raw).toBe(raw);
+      expect(chunks[1]).toEqual({ text: '', model: 'gemini-2.5-flash', isComplete: true });
+      expect(chunks[1].raw).toBeUndefined();
+    });
+  });
+
+  describe('inspectKeys', () => {
+    it('should report each key as valid, invalid, rate limited or erroring', async () => {
+      mockGeminiClient.validateApiKey = vi.fn(async (key: string) => {
+        if (key === 'key-rate-limited') {
+          throw new Error('429 Rate limit exceeded');
+        }
+        if (key === 'key-offline') {
+          throw new Error('fetch failed');
+        }
+        return key === 'key-valid';
+      });
+      const client = new GemBack({
+        apiKeys: ['key-valid', 'key-invalid', 'key-rate-limited', 'key-offline'],
+        keyQuota: { dailyTokens: 1000 },
+      });
+
+      const report = await client.inspectKeys();
+
+      expect(report.map(({ status }) => status)).toEqual([
+        'valid',
+        'invalid',
+        'rate-limited',
+        'error',
+      ]);
+      expect(report[0]).toEqual({
+        keyIndex: 0,
+        key: '****alid',
+        status: 'valid',
+        circuitOpen: false,
+        disabled: false,
+        quotaHeadroom: 1000,
+      });
+      expect(report[2].error).toBe('429 Rate limit exceeded');
+      expect(report[3].error).toBe('fetch failed');
+    });
+
+    it('should check at most `concurrency` keys at once', async () => {
+      let active = 0;
+      let maxActive = 0;
+      mockGeminiClient.validateApiKey = vi.fn(async () => {
+        active++;
+        maxActive = Math.max(maxActive, active);
+        await new Promise((resolve) => setTimeout(resolve, 5));
+        active--;
+        return true;
+      });
+      const client = new GemBack({ apiKeys: ['key-1', 'key-2', 'key-3', 'key-4', 'key-5'] });
+
+      const report = await client.inspectKeys({ concurrency: 2 });
+
+      expect(report).toHaveLength(5);
+      expect(report.every(({ status }) => status === 'valid')).toBe(true);
+      expect(maxActive).toBe(2);
+    });
+
+    it('should report a key past its circuit reset timeout without moving it to half-open', async () => {
+      let now = 0;
+      const onKeyStateChange = vi.fn();
+      mockGeminiClient.validateApiKey = vi.fn().mockResolvedValue(true);
+      mockGeminiClient.generate.mockImplementation(
+        async (_prompt: string, model: string, key: string) => {
+          if (key === 'key-aaaa-1111') {
+            throw new Error('503 Service Unavailable');
+          }
+          return { text: 'ok', model };
+        }
+      );
+      const client = new GemBack({
+        apiKeys: ['key-aaaa-1111', 'key-bbbb-2222'],
+        attemptOrder: 'keys-first',
+        maxRetries: 0,
+        keyCircuitBreaker: { failureThreshold: 1, resetTimeoutMs: 1000, now: () => now },
+        onKeyStateChange,
+      });
+
+      await client.generate('Hello');
+      now = 1000;
+      const report = await client.inspectKeys();
+
+      expect(report[0].circuitOpen).toBe(false);
+      expect(onKeyStateChange.mock.calls).toEqual([['****1111', 'circuit-open']]);
+    });
+  });
+
+  describe('finalFallback', () => {
+    const request = { contents: [{ role: 'user' as const, parts: [{ text: 'Hi' }] }] };
+
+    it('should delegate to the final fallback once every model failed', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockRejectedValue(new Error('503 Service Unavailable'));
+      const finalFallback = vi.fn().mockResolvedValue({
+        text: 'From another provider',
+        model: 'other-provider',
+        finishReason: 'STOP',
+      });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 0,
+        finalFallback,
+      });
+
+      const response = await client.generateContent(request);
+
+      expect(response.text).toBe('From another provider');
+      expect(mockGeminiClient.generateContent).toHaveBeenCalledTimes(2);
+      expect(finalFallback).toHaveBeenCalledWith(request);
+    });
+
+    it('should not delegate failures that end the chain early', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockRejectedValue(new Error('401 Unauthorized: API key not valid'));
+      const finalFallback = vi.fn();
+      const client = new GemBack({ apiKey: 'test-key', maxRetries: 0, finalFallback });
+
+      await expect(client.generateContent(request)).rejects.toMatchObject({ code: 'AUTH_ERROR' });
+      expect(finalFallback).not.toHaveBeenCalled();
+    });
+
+    it('should keep the Gemini error when the final fallback fails too', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockRejectedValue(new Error('503 Service Unavailable'));
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+        maxRetries: 0,
+        finalFallback: vi.fn().mockRejectedValue(new Error('provider down')),
+      });
+
+      const error = (await client.generateContent(request).catch((err) => err)) as GeminiBackError;
+
+      expect(error.code).toBe('ALL_MODELS_FAILED');
+      expect(error.message).toContain('Final fallback failed: provider down');
+      expect(error.allAttempts).toHaveLength(2);
+    });
+  });
+
+  describe('retryOnMessages', () => {
+    const overloaded = () => new Error('The model is overloaded. Please try again later.');
+
+    it('should retry unclassified errors whose message matches', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(overloaded())
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 1,
+        retryDelay: 1,
+        retryOnMessages: ['Model Is Overloaded'],
+      });
+
+      const response = await client.generate('Hi');
+
+      expect(response.text).toBe('ok');
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+
+    it('should leave such errors unretried without a matching substring', async () => {
+      mockGeminiClient.generate.mockRejectedValue(overloaded());
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 1,
+        retryDelay: 1,
+        retryOnMessages: ['try a smaller prompt'],
+      });
+
+      await expect(client.generate('Hi')).rejects.toThrow(GeminiBackError);
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+
+    it('should not match errors that carry an HTTP status', async () => {
+      mockGeminiClient.generate.mockRejectedValue(
+        new Error('400 Bad Request: model is overloaded')
+      );
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        maxRetries: 1,
+        retryDelay: 1,
+        retryOnMessages: ['model is overloaded'],
+      });
+
+      await expect(client.generate('Hi')).rejects.toThrow(GeminiBackError);
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+  });
+
+  describe('pinKey', () => {
+    it('should send only the pinned key while pinned, even with concurrent requests', async () => {
+      mockGeminiClient.generate.mockImplementation(async (_prompt: string, model: string) => {
+        await new Promise((resolve) => setTimeout(resolve, 1));
+        return { text: 'ok', model };
+      });
+      const client = new GemBack({
+        apiKeys: ['key-1', 'key-2', 'key-3'],
+        attemptOrder: 'keys-first',
+      });
+
+      const keysUsed = () =>
+        new Set(mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[2]));
+
+      client.pinKey(2);
+      await Promise.all(Array.from({ length: 20 }, () => client.generate('Hi')));
+      expect([...keysUsed()]).toEqual(['key-3']);
+
+      mockGeminiClient.generate.mockClear();
+      client.unpinKey();
+      await Promise.all(Array.from({ length: 3 }, () => client.generate('Hi')));
+      expect(keysUsed().size).toBe(3);
+    });
+
+    it('should retry on the pinned key instead of rotating', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('503 Service Unavailable'))
+        .mockResolvedValueOnce({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({
+        apiKeys: ['key-1', 'key-2'],
+        fallbackOrder: ['gemini-2.5-flash'],
+        attemptOrder: 'keys-first',
+        maxRetries: 1,
+        retryDelay: 1,
+      });
+
+      client.pinKey(1);
+      await client.generate('Hi');
+
+      expect(mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[2])).toEqual([
+        'key-2',
+        'key-2',
+      ]);
+      expect(() => client.pinKey(2)).toThrow('No API key at index 2');
+    });
+  });
+
+  describe('peekNextKeyIndex', () => {
+    it('should not advance the rotation, while generateContent does', async () => {
+      mockGeminiClient.generateContent = vi
+        .fn()
+        .mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+      const client = new GemBack({ apiKeys: ['key-1', 'key-2', 'key-3'] });
+      const contents = [{ role: 'user' as const, parts: [{ text: 'Hi' }] }];
+
+      expect(client.peekNextKeyIndex()).toBe(0);
+      expect(client.peekNextKeyIndex()).toBe(0);
+
+      await client.generateContent({ contents });
+      expect(mockGeminiClient.generateContent.mock.calls[0][2]).toBe('key-1');
+      expect(client.peekNextKeyIndex()).toBe(1);
+
+      await client.generateContent({ contents });
+      expect(mockGeminiClient.generateContent.mock.calls[1][2]).toBe('key-2');
+      expect(client.peekNextKeyIndex()).toBe(2);
+    });
+
+    it('should follow the seed with hash starts and give up on random ones', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' });
+      const keys = ['key-1', 'key-2', 'key-3'];
+      const hashed = new GemBack({ apiKeys: keys, keyStartStrategy: 'hash' });
+      const random = hashed.clone({ keyStartStrategy: 'random' });
+
+      const peeked = hashed.peekNextKeyIndex('tenant-a')!;
+      await hashed.generate('Hi', { keySeed: 'tenant-a' });
+
+      expect(mockGeminiClient.generate.mock.calls[0][2]).toBe(keys[peeked]);
+      expect(hashed.peekNextKeyIndex()).toBeUndefined();
+      expect(random.peekNextKeyIndex()).toBeUndefined();
+    });
+  });
 });
@@ -0,0 +1,29 @@
+import { bench, describe, vi } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+
+vi.mock('../../src/client/GeminiClient');
+
+// Measures the client-side overhead only: the API call itself resolves immediately
+describe('single key, single model', () => {
+  vi.mocked(GeminiClient).mockImplementation(
+    () =>
+      ({
+        generate: async () => ({ text: 'ok', model: 'gemini-2.5-flash', finishReason: 'STOP' }),
+      }) as unknown as GeminiClient
+  );
+
+  const client = new GemBack({
+    apiKey: 'test-key',
+    fallbackOrder: ['gemini-2.5-flash'],
+    maxRetries: 0,
+  });
+
+  bench('generate (full fallback path)', async () => {
+    await client.generate('Hello');
+  });
+
+  bench('generateOnce', async () => {
+    await client.generateOnce('Hello');
+  });
+});
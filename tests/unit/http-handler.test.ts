@@ -0,0 +1,181 @@
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
+import * as http from 'http';
+import type { AddressInfo } from 'net';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { createHttpHandler, getHttpStatus } from '../../src/server/http-handler';
+import { GeminiBackError, BlockedError } from '../../src/types/errors';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('HTTP handler', () => {
+  let mockGeminiClient: any;
+  let server: http.Server;
+  let baseUrl: string;
+
+  const body = JSON.stringify({
+    contents: [{ role: 'user', parts: [{ text: 'Hello' }] }],
+  });
+
+  beforeEach(async () => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generateContent: vi.fn(),
+      generateContentStream: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+
+    const client = new GemBack({
+      apiKey: 'test-key',
+      fallbackOrder: ['gemini-2.5-flash'],
+      maxRetries: 0,
+    });
+    server = http.createServer(createHttpHandler(client));
+    await new Promise<void>((resolve) => server.listen(0, resolve));
+    baseUrl = `http://127.0.0.1:${(server.address() as AddressInfo).port}`;
+  });
+
+  afterEach(async () => {
+    await new Promise((resolve) => server.close(resolve));
+  });
+
+  it('should return the response as JSON', async () => {
+    mockGeminiClient.generateContent.mockResolvedValue({
+      text: 'Hi there',
+      model: 'gemini-2.5-flash',
+    });
+
+    const res = await fetch(baseUrl, { method: 'POST', body });
+
+    expect(res.status).toBe(200);
+    expect(await res.json()).toEqual({
+      text: 'Hi there',
+      model: 'gemini-2.5-flash',
+      fallbackDepth: 0,
+    });
+    expect(mockGeminiClient.generateContent.mock.calls[0][0]).toEqual([
+      { role: 'user', parts: [{ text: 'Hello' }] },
+    ]);
+  });
+
+  it('should reject invalid requests with 400', async () => {
+    const invalidJson = await fetch(baseUrl, { method: 'POST', body: '{' });
+    const missingContents = await fetch(baseUrl, { method: 'POST', body: '{}' });
+
+    expect(invalidJson.status).toBe(400);
+    expect(missingContents.status).toBe(400);
+    expect(mockGeminiClient.generateContent).not.toHaveBeenCalled();
+  });
+
+  it('should keep the raw SDK response out of JSON responses', async () => {
+    mockGeminiClient.generateContent.mockResolvedValue({
+      text: 'Hi there',
+      model: 'gemini-2.5-flash',
+      raw: { sdkHttpResponse: { headers: {} } },
+    });
+
+    const res = await fetch(baseUrl, { method: 'POST', body });
+
+    expect(res.status).toBe(200);
+    expect(await res.json()).not.toHaveProperty('raw');
+  });
+
+  it('should reject bodies over the prompt size limit with 413', async () => {
+    const client = new GemBack({ apiKey: 'test-key', maxPromptBytes: 1000 });
+    const limited = http.createServer(createHttpHandler(client));
+    await new Promise<void>((resolve) => limited.listen(0, resolve));
+    const url = `http://127.0.0.1:${(limited.address() as AddressInfo).port}`;
+
+    try {
+      const large = JSON.stringify({
+        contents: [{ role: 'user', parts: [{ text: 'x'.repeat(100 * 1024) }] }],
+      });
+      const res = await fetch(url, { method: 'POST', body: large });
+
+      expect(res.status).toBe(413);
+      expect((await res.json()).error.code).toBe('PROMPT_TOO_LARGE');
+      expect(mockGeminiClient.generateContent).not.toHaveBeenCalled();
+    } finally {
+      await new Promise((resolve) => limited.close(resolve));
+    }
+  });
+
+  it('should reject other methods with 405', async () => {
+    const res = await fetch(baseUrl);
+
+    expect(res.status).toBe(405);
+    expect(res.headers.get('allow')).toBe('POST');
+  });
+
+  it('should map rate limit failures to 429', async () => {
+    mockGeminiClient.generateContent.mockRejectedValue(new Error('429 Rate limit exceeded'));
+
+    const res = await fetch(baseUrl, { method: 'POST', body });
+    const payload = await res.json();
+
+    expect(res.status).toBe(429);
+    expect(payload.error.code).toBe('ALL_KEYS_EXHAUSTED');
+  });
+
+  it('should return 422 for blocked prompts', async () => {
+    mockGeminiClient.generateContent.mockResolvedValue({
+      text: '',
+      model: 'gemini-2.5-flash',
+      promptFeedback: { blockReason: 'SAFETY' },
+    });
+
+    const res = await fetch(baseUrl, { method: 'POST', body });
+
+    expect(res.status).toBe(422);
+    expect((await res.json()).promptFeedback.blockReason).toBe('SAFETY');
+  });
+
+  it('should stream chunks as server-sent events', async () => {
+    mockGeminiClient.generateContentStream.mockImplementation(async function* () {
+      yield { text: 'Hello ' };
+      yield { text: 'world' };
+    });
+
+    const res = await fetch(`${baseUrl}?stream=true`, { method: 'POST', body });
+    const events = (await res.text())
+      .split('\n\n')
+      .filter(Boolean)
+      .map((event) => JSON.parse(event.replace(/^data: /, '')));
+
+    expect(res.headers.get('content-type')).toBe('text/event-stream');
+    expect(events.map((event) => event.text)).toEqual(['Hello ', 'world', '']);
+    expect(events[2].isComplete).toBe(true);
+  });
+
+  it('should keep the error status when streaming fails before the first chunk', async () => {
+    mockGeminiClient.generateContentStream.mockImplementation(async function* () {
+      throw new Error('503 Service unavailable');
+    });
+
+    const res = await fetch(baseUrl, {
+      method: 'POST',
+      body,
+      headers: { Accept: 'text/event-stream' },
+    });
+
+    expect(res.status).toBe(503);
+  });
+});
+
+describe('getHttpStatus', () => {
+  it('should map errors to status codes', () => {
+    const attempt = (statusCode?: number) => ({
+      model: 'gemini-2.5-flash' as const,
+      error: 'failed',
+      timestamp: new Date(),
+      statusCode,
+    });
+
+    expect(getHttpStatus(new GeminiBackError('x', 'ALL_MODELS_FAILED', [attempt(429)]))).toBe(429);
+    expect(getHttpStatus(new GeminiBackError('x', 'ALL_MODELS_FAILED', [attempt(500)]))).toBe(503);
+    expect(getHttpStatus(new GeminiBackError('x', 'AUTH_ERROR', [attempt(401)]))).toBe(502);
+    expect(getHttpStatus(new BlockedError('SAFETY', 'prompt', []))).toBe(422);
+    expect(getHttpStatus(new GeminiBackError('x', 'PROMPT_TOO_LARGE'))).toBe(413);
+    expect(getHttpStatus(new Error('boom'))).toBe(500);
+  });
+});
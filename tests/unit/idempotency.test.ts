@@ -0,0 +1,237 @@
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { RequestDeduplicator, fingerprintRequest } from '../../src/utils/request-deduplicator';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Idempotency keys', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+      generateContent: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  it('should issue a single call for concurrent requests with the same key', async () => {
+    let resolveCall: (value: unknown) => void = () => {};
+    mockGeminiClient.generate.mockImplementation(
+      () =>
+        new Promise((resolve) => {
+          resolveCall = resolve;
+        })
+    );
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const first = client.generate('Hello', { idempotencyKey: 'req-1' });
+    const second = client.generate('Hello', { idempotencyKey: 'req-1' });
+
+    resolveCall({ text: 'Once', model: 'gemini-2.5-flash' });
+    const [a, b] = await Promise.all([first, second]);
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    expect(a).toEqual(b);
+    expect(client.getFallbackStats().totalRequests).toBe(1);
+  });
+
+  it('should replay a recently completed result', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'Done', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const first = await client.generate('Hello', { idempotencyKey: 'req-1' });
+    const second = await client.generate('Hello', { idempotencyKey: 'req-1' });
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    expect(second).toEqual(first);
+  });
+
+  it('should give each caller its own copy of the result', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: 'Done',
+      model: 'gemini-2.5-flash',
+      usage: { promptTokens: 1, completionTokens: 1, totalTokens: 2 },
+    });
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const [first, second] = await Promise.all([
+      client.generate('Hello', { idempotencyKey: 'req-1' }),
+      client.generate('Hello', { idempotencyKey: 'req-1' }),
+    ]);
+    first.text = 'Changed by the first caller';
+    first.usage!.totalTokens = 0;
+    const later = await client.generate('Hello', { idempotencyKey: 'req-1' });
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    expect(second.text).toBe('Done');
+    expect(later.text).toBe('Done');
+    expect(later.usage?.totalTokens).toBe(2);
+  });
+
+  it('should stop waiting for a cancelled caller while the others get the result', async () => {
+    let resolveCall: (value: unknown) => void = () => {};
+    mockGeminiClient.generate.mockImplementation(
+      () =>
+        new Promise((resolve) => {
+          resolveCall = resolve;
+        })
+    );
+    const controller = new AbortController();
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const cancelled = (client as any).generateCancellable(
+      'Hello',
+      { idempotencyKey: 'req-1' },
+      controller.signal
+    );
+    const other = client.generate('Hello', { idempotencyKey: 'req-1' });
+    await vi.waitFor(() => expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1));
+
+    controller.abort();
+    await expect(cancelled).rejects.toMatchObject({ code: 'ABORTED' });
+
+    resolveCall({ text: 'Shared', model: 'gemini-2.5-flash' });
+    expect((await other).text).toBe('Shared');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+  });
+
+  it('should run requests with different keys independently', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'Done', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    await Promise.all([
+      client.generate('Hello', { idempotencyKey: 'req-1' }),
+      client.generate('Hello', { idempotencyKey: 'req-2' }),
+      client.generate('Hello'),
+    ]);
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(3);
+  });
+
+  it('should not remember failed requests', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('401 Invalid API key'))
+      .mockResolvedValueOnce({ text: 'Recovered', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    await expect(client.generate('Hello', { idempotencyKey: 'req-1' })).rejects.toThrow();
+    const response = await client.generate('Hello', { idempotencyKey: 'req-1' });
+
+    expect(response.text).toBe('Recovered');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+  });
+
+  it('should deduplicate multimodal requests', async () => {
+    mockGeminiClient.generateContent.mockResolvedValue({
+      text: 'Image',
+      model: 'gemini-2.5-flash',
+    });
+    const request = {
+      contents: [{ role: 'user' as const, parts: [{ text: 'Describe' }] }],
+      idempotencyKey: 'img-1',
+    };
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    await Promise.all([client.generateContent(request), client.generateContent(request)]);
+
+    expect(mockGeminiClient.generateContent).toHaveBeenCalledTimes(1);
+  });
+});
+
+describe('Dedup window', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn().mockResolvedValue({ text: 'Once', model: 'gemini-2.5-flash' }),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  it('should replay the result of an identical request within the window', async () => {
+    const client = new GemBack({ apiKey: 'test-key', dedupWindow: 5000 });
+
+    const first = await client.generate('Hello', { temperature: 1, maxTokens: 50 });
+    const second = await client.generate('Hello', { maxTokens: 50, temperature: 1 });
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    expect(second).toEqual(first);
+  });
+
+  it('should give each caller its own copy of a shared result', async () => {
+    const client = new GemBack({ apiKey: 'test-key', dedupWindow: 5000 });
+
+    const [first, second] = await Promise.all([client.generate('Hello'), client.generate('Hello')]);
+    first.text = 'Changed by the first caller';
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    expect(second.text).toBe('Once');
+  });
+
+  it('should not deduplicate requests with callbacks or writers in their options', async () => {
+    const client = new GemBack({ apiKey: 'test-key', dedupWindow: 5000 });
+    const spillTo = () => ({ writer: { write: vi.fn() }, thresholdBytes: 1 });
+
+    await client.generate('Hello', { spillOutput: spillTo() });
+    await client.generate('Hello', { spillOutput: spillTo() });
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+  });
+
+  it('should run requests that differ in prompt or options', async () => {
+    const client = new GemBack({ apiKey: 'test-key', dedupWindow: 5000 });
+
+    await client.generate('Hello', { temperature: 1 });
+    await client.generate('Hello', { temperature: 0 });
+    await client.generate('Goodbye', { temperature: 1 });
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(3);
+  });
+
+  it('should not fingerprint requests holding functions or class instances', () => {
+    expect(fingerprintRequest('generate', 'Hi', { onChunk: () => {} })).toBeUndefined();
+    const signal = new AbortController().signal;
+    expect(fingerprintRequest('generate', 'Hi', { signal })).toBeUndefined();
+  });
+
+  it('should ignore the deadline when fingerprinting', () => {
+    expect(fingerprintRequest('generate', 'Hi', { deadline: 1 })).toBe(
+      fingerprintRequest('generate', 'Hi', { deadline: 2 })
+    );
+  });
+});
+
+describe('RequestDeduplicator', () => {
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  it('should forget completed results after the TTL', async () => {
+    vi.useFakeTimers();
+    const dedup = new RequestDeduplicator<string>(1000);
+    const fn = vi.fn().mockResolvedValue('value');
+
+    await dedup.run('key', fn);
+    expect(dedup.has('key')).toBe(true);
+
+    vi.advanceTimersByTime(1001);
+    expect(dedup.has('key')).toBe(false);
+
+    await dedup.run('key', fn);
+    expect(fn).toHaveBeenCalledTimes(2);
+  });
+
+  it('should not keep completed results when the TTL is 0', async () => {
+    const dedup = new RequestDeduplicator<string>(0);
+    const fn = vi.fn().mockResolvedValue('value');
+
+    await dedup.run('key', fn);
+    await dedup.run('key', fn);
+
+    expect(fn).toHaveBeenCalledTimes(2);
+  });
+});
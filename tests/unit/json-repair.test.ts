@@ -0,0 +1,139 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { GeminiBackError, SchemaValidationError } from '../../src/types/errors';
+import { repairJson, parseJsonWithRepair } from '../../src/utils/json-repair';
+import { schemaFromExample } from '../../src/utils/json-schema';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('repairJson', () => {
+  it('should unwrap code fences', () => {
+    expect(JSON.parse(repairJson('```json\n{"a": 1}\n```'))).toEqual({ a: 1 });
+  });
+
+  it('should remove trailing commas outside strings', () => {
+    const repaired = repairJson('{"items": [1, 2, 3,], "note": "a, }",}');
+    expect(JSON.parse(repaired)).toEqual({ items: [1, 2, 3], note: 'a, }' });
+  });
+
+  it('should drop prose around the JSON', () => {
+    const text = 'Sure! Here is the data:\n[{"id": 1}]\nLet me know if you need more.';
+    expect(JSON.parse(repairJson(text))).toEqual([{ id: 1 }]);
+  });
+
+  it('should rethrow when the text cannot be repaired', () => {
+    expect(() => parseJsonWithRepair('{"a": ')).toThrow(SyntaxError);
+  });
+});
+
+describe('GemBack.generateJSON', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = { generate: vi.fn() };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const reply = (text: string) => ({ text, model: 'gemini-2.5-flash' });
+
+  it('should request JSON and repair fenced output', async () => {
+    mockGeminiClient.generate.mockResolvedValue(reply('```json\n{"name": "Ada",}\n```'));
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const result = await client.generateJSON<{ name: string }>('Invent a user', {
+      repairJson: true,
+    });
+
+    expect(result).toEqual({ name: 'Ada' });
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    expect(mockGeminiClient.generate.mock.calls[0][3].responseMimeType).toBe('application/json');
+    expect(mockGeminiClient.generate.mock.calls[0][3]).not.toHaveProperty('repairJson');
+  });
+
+  it('should regenerate once when the output cannot be repaired', async () => {
+    mockGeminiClient.generate
+      .mockResolvedValueOnce(reply('{"name": '))
+      .mockResolvedValueOnce(reply('{"name": "Grace"}'));
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const result = await client.generateJSON('Invent a user', { repairJson: true });
+
+    expect(result).toEqual({ name: 'Grace' });
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+  });
+
+  it('should fail with INVALID_JSON without repair', async () => {
+    mockGeminiClient.generate.mockResolvedValue(reply('```json\n{"name": "Ada"}\n```'));
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const error = await client.generateJSON('Invent a user').catch((err: Error) => err);
+
+    expect(error).toBeInstanceOf(GeminiBackError);
+    expect((error as GeminiBackError).code).toBe('INVALID_JSON');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+  });
+
+  it('should fail with TRUNCATED_JSON when MAX_TOKENS cut the output off', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      ...reply('{"users": [{"name": "Ada"}, {"name": "Gra'),
+      finishReason: 'MAX_TOKENS',
+    });
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const error = await client
+      .generateJSON('List users', { repairJson: true })
+      .catch((err: Error) => err);
+
+    expect((error as GeminiBackError).code).toBe('TRUNCATED_JSON');
+    expect((error as GeminiBackError).message).toContain('raise maxTokens');
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+  });
+
+  describe('validateSchema', () => {
+    const responseSchema = schemaFromExample({ name: 'Ada', email: 'ada@example.com' });
+
+    it('should regenerate when a required field is missing', async () => {
+      mockGeminiClient.generate
+        .mockResolvedValueOnce(reply('{"name": "Ada"}'))
+        .mockResolvedValueOnce(reply('{"name": "Ada", "email": "ada@example.com"}'));
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const result = await client.generateJSON('Invent a user', {
+        responseSchema,
+        validateSchema: true,
+      });
+
+      expect(result).toEqual({ name: 'Ada', email: 'ada@example.com' });
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+
+    it('should throw SchemaValidationError after maxRetries', async () => {
+      mockGeminiClient.generate.mockResolvedValue(reply('{"name": 7}'));
+      const client = new GemBack({ apiKey: 'test-key', maxRetries: 1 });
+
+      const error = await client
+        .generateJSON('Invent a user', { responseSchema, validateSchema: true })
+        .catch((err: Error) => err);
+
+      expect(error).toBeInstanceOf(SchemaValidationError);
+      expect((error as SchemaValidationError).code).toBe('SCHEMA_VALIDATION_FAILED');
+      expect((error as SchemaValidationError).violations).toEqual([
+        '$.email: missing required field',
+        '$.name: expected STRING, got number',
+      ]);
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(2);
+    });
+
+    it('should not validate without validateSchema', async () => {
+      mockGeminiClient.generate.mockResolvedValue(reply('{"name": "Ada"}'));
+      const client = new GemBack({ apiKey: 'test-key' });
+
+      const result = await client.generateJSON('Invent a user', { responseSchema });
+
+      expect(result).toEqual({ name: 'Ada' });
+      expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+    });
+  });
+});
@@ -0,0 +1,96 @@
+import { describe, it, expect } from 'vitest';
+import { schemaFromExample, jsonMode, validateAgainstSchema } from '../../src/utils/json-schema';
+
+describe('schemaFromExample', () => {
+  it('should infer a schema from a sample object', () => {
+    const schema = schemaFromExample(
+      {
+        name: 'Ada',
+        age: 36,
+        score: 9.5,
+        active: true,
+        tags: ['math'],
+        address: { city: 'London', zip: 'N1' },
+      },
+      { optional: ['score', 'zip'] }
+    );
+
+    expect(schema).toEqual({
+      type: 'OBJECT',
+      properties: {
+        name: { type: 'STRING' },
+        age: { type: 'INTEGER' },
+        score: { type: 'NUMBER' },
+        active: { type: 'BOOLEAN' },
+        tags: { type: 'ARRAY', items: { type: 'STRING' } },
+        address: {
+          type: 'OBJECT',
+          properties: { city: { type: 'STRING' }, zip: { type: 'STRING' } },
+          required: ['city'],
+          propertyOrdering: ['city', 'zip'],
+        },
+      },
+      required: ['name', 'age', 'active', 'tags', 'address'],
+      propertyOrdering: ['name', 'age', 'score', 'active', 'tags', 'address'],
+    });
+  });
+
+  it('should infer array item schemas from the first element', () => {
+    expect(schemaFromExample([{ id: 1 }])).toEqual({
+      type: 'ARRAY',
+      items: {
+        type: 'OBJECT',
+        properties: { id: { type: 'INTEGER' } },
+        required: ['id'],
+        propertyOrdering: ['id'],
+      },
+    });
+  });
+
+  it('should reject values whose type cannot be inferred', () => {
+    expect(() => schemaFromExample({ tags: [] })).toThrow('empty array at $.tags');
+    expect(() => schemaFromExample({ note: null })).toThrow('at $.note');
+  });
+});
+
+describe('jsonMode', () => {
+  it('should set only the MIME type without an example', () => {
+    expect(jsonMode()).toEqual({ responseMimeType: 'application/json' });
+  });
+
+  it('should add a schema inferred from the example', () => {
+    expect(jsonMode({ answer: '' })).toEqual({
+      responseMimeType: 'application/json',
+      responseSchema: {
+        type: 'OBJECT',
+        properties: { answer: { type: 'STRING' } },
+        required: ['answer'],
+        propertyOrdering: ['answer'],
+      },
+    });
+  });
+});
+
+describe('validateAgainstSchema', () => {
+  const schema = schemaFromExample({ name: 'Ada', age: 36, tags: ['math'] });
+
+  it('should accept a conforming value', () => {
+    expect(validateAgainstSchema({ name: 'Ada', age: 36, tags: [] }, schema)).toEqual([]);
+  });
+
+  it('should list missing required fields and type mismatches with their paths', () => {
+    expect(validateAgainstSchema({ age: 36.5, tags: ['math', 7] }, schema)).toEqual([
+      '$.name: missing required field',
+      '$.age: expected INTEGER, got number',
+      '$.tags[1]: expected STRING, got number',
+    ]);
+    expect(validateAgainstSchema([], schema)).toEqual(['$: expected OBJECT, got array']);
+  });
+
+  it('should allow null only for nullable schemas', () => {
+    const nullable = { ...schema, nullable: true };
+
+    expect(validateAgainstSchema(null, schema)).toEqual(['$: expected OBJECT, got null']);
+    expect(validateAgainstSchema(null, nullable)).toEqual([]);
+  });
+});
@@ -0,0 +1,32 @@
+import { describe, it, expect } from 'vitest';
+import { stripMarkdown } from '../../src/utils/markdown';
+
+describe('stripMarkdown', () => {
+  it('should remove heading markers', () => {
+    expect(stripMarkdown('# Title\n## Section ##\nLearn C#')).toBe('Title\nSection\nLearn C#');
+  });
+
+  it('should remove emphasis markers but keep snake_case and arithmetic', () => {
+    expect(
+      stripMarkdown('**Bold**, *italic*, __strong__, _em_, ~~old~~ in snake_case_name, 2 * 3 * 4')
+    ).toBe('Bold, italic, strong, em, old in snake_case_name, 2 * 3 * 4');
+  });
+
+  it('should drop code fences and keep the code verbatim', () => {
+    expect(stripMarkdown("Run:\n```python\nx = '**raw**'\n# comment\n```\nDone.")).toBe(
+      "Run:\nx = '**raw**'\n# comment\nDone."
+    );
+  });
+
+  it('should remove list bullets, rules and blockquotes but keep numbering', () => {
+    expect(stripMarkdown('- one\n* two\n  + nested\n1. first\n---\n> quoted')).toBe(
+      'one\ntwo\n  nested\n1. first\n\nquoted'
+    );
+  });
+
+  it('should flatten links, images and inline code', () => {
+    expect(stripMarkdown('See [docs](https://example.com) and ![logo](a.png), run `npm i`.')).toBe(
+      'See docs (https://example.com) and logo, run npm i.'
+    );
+  });
+});
@@ -0,0 +1,108 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Model aliases', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+      generateStream: vi.fn(),
+      generateContent: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const modelAliases = {
+    flash: 'gemini-2.5-flash' as const,
+    lite: 'gemini-2.5-flash-lite' as const,
+  };
+
+  it('should resolve aliases in the fallback order', async () => {
+    mockGeminiClient.generate
+      .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+      .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash-lite' });
+
+    const client = new GemBack({
+      apiKey: 'test-key',
+      maxRetries: 0,
+      modelAliases,
+      fallbackOrder: ['flash', 'lite'],
+    });
+    const response = await client.generate('Hello');
+
+    const calls = mockGeminiClient.generate.mock.calls;
+    expect(calls[0][1]).toBe('gemini-2.5-flash');
+    expect(calls[1][1]).toBe('gemini-2.5-flash-lite');
+    expect(response.model).toBe('gemini-2.5-flash-lite');
+  });
+
+  it('should resolve an alias passed as the request model', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ apiKey: 'test-key', modelAliases });
+    await client.generate('Hello', { model: 'flash' });
+
+    expect(mockGeminiClient.generate.mock.calls[0][1]).toBe('gemini-2.5-flash');
+    expect(client.getFallbackStats().modelUsage['gemini-2.5-flash']).toBe(1);
+  });
+
+  it('should resolve aliases for multimodal requests', async () => {
+    mockGeminiClient.generateContent.mockResolvedValue({
+      text: 'Success',
+      model: 'gemini-2.5-flash-lite',
+    });
+
+    const client = new GemBack({ apiKey: 'test-key', modelAliases });
+    await client.generateContent({
+      contents: [{ role: 'user', parts: [{ text: 'Describe' }] }],
+      model: 'lite',
+    });
+
+    expect(mockGeminiClient.generateContent.mock.calls[0][1]).toBe('gemini-2.5-flash-lite');
+  });
+
+  it('should pass unknown names through unchanged', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-9-ultra' });
+
+    const client = new GemBack({ apiKey: 'test-key', modelAliases, enableMonitoring: true });
+    await client.generate('Hello', { model: 'gemini-9-ultra' });
+
+    expect(mockGeminiClient.generate.mock.calls[0][1]).toBe('gemini-9-ultra');
+  });
+
+  it('should not treat object prototype keys as aliases', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({ apiKey: 'test-key', modelAliases });
+    await client.generate('Hello', { model: 'constructor' });
+
+    expect(mockGeminiClient.generate.mock.calls[0][1]).toBe('constructor');
+  });
+
+  it('should treat names with and without the models/ prefix as the same model', async () => {
+    mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+    const client = new GemBack({
+      apiKey: 'test-key',
+      modelAliases: { 'models/flash': 'gemini-2.5-flash' },
+      fallbackOrder: ['models/gemini-2.5-flash'],
+    });
+    await client.generate('Hello');
+    await client.generate('Hello', { model: 'gemini-2.5-flash' });
+    await client.generate('Hello', { model: 'models/gemini-2.5-flash' });
+    await client.generate('Hello', { model: 'flash' });
+
+    expect(mockGeminiClient.generate.mock.calls.map((call: unknown[]) => call[1])).toEqual([
+      'gemini-2.5-flash',
+      'gemini-2.5-flash',
+      'gemini-2.5-flash',
+      'gemini-2.5-flash',
+    ]);
+    expect(client.getFallbackStats().modelUsage['gemini-2.5-flash']).toBe(4);
+  });
+});
@@ -0,0 +1,290 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Per-model configuration', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+      generateContent: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  describe('timeout', () => {
+    const modelConfigs = { 'gemini-2.5-pro': { timeout: 90000 } };
+
+    it('should apply each model its own timeout while falling back', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+        .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-pro', 'gemini-2.5-flash'],
+        modelConfigs,
+      });
+      await client.generate('Hello', { temperature: 0.5 });
+
+      const [proCall, flashCall] = mockGeminiClient.generate.mock.calls;
+      expect(proCall[3]).toEqual({ temperature: 0.5, timeout: 90000 });
+      expect(flashCall[3]).toEqual({ temperature: 0.5 });
+    });
+
+    it('should leave options untouched for models without overrides', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        modelConfigs,
+      });
+      await client.generate('Hello');
+
+      expect(mockGeminiClient.generate.mock.calls[0][3]).toBeUndefined();
+    });
+
+    it('should let a per-request timeout win over the model timeout', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-pro' });
+
+      const client = new GemBack({ apiKey: 'test-key', modelConfigs });
+      await client.generate('Hello', { model: 'gemini-2.5-pro', timeout: 5000 });
+
+      expect(mockGeminiClient.generate.mock.calls[0][3].timeout).toBe(5000);
+    });
+
+    it('should keep the model timeout when it fits before the deadline', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        timeout: 30000,
+        modelConfigs: { 'gemini-2.5-flash': { timeout: 5000 } },
+      });
+      await client.generate('Hello', { model: 'gemini-2.5-flash', deadline: Date.now() + 60000 });
+      await client.generate('Hello', { model: 'gemini-2.5-flash', deadline: Date.now() + 1000 });
+
+      const [roomy, tight] = mockGeminiClient.generate.mock.calls;
+      expect(roomy[3].timeout).toBe(5000);
+      expect(tight[3].timeout).toBeGreaterThan(0);
+      expect(tight[3].timeout).toBeLessThanOrEqual(1000);
+    });
+
+    it('should apply model timeouts to multimodal requests', async () => {
+      mockGeminiClient.generateContent.mockResolvedValue({
+        text: 'Success',
+        model: 'gemini-2.5-pro',
+      });
+
+      const client = new GemBack({ apiKey: 'test-key', modelConfigs });
+      await client.generateContent({
+        contents: [{ role: 'user', parts: [{ text: 'Describe' }] }],
+        model: 'gemini-2.5-pro',
+      });
+
+      expect(mockGeminiClient.generateContent.mock.calls[0][3].timeout).toBe(90000);
+    });
+  });
+
+  describe('generation settings', () => {
+    const modelConfigs = { 'gemini-2.5-flash': { maxTokens: 512, temperature: 0.2 } };
+
+    it('should apply a tier its own settings only when that model is tried', async () => {
+      mockGeminiClient.generate
+        .mockRejectedValueOnce(new Error('429 Rate limit exceeded'))
+        .mockResolvedValueOnce({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-pro', 'gemini-2.5-flash'],
+        modelConfigs,
+      });
+      await client.generate('Hello', { topP: 0.9 });
+
+      const [proCall, flashCall] = mockGeminiClient.generate.mock.calls;
+      expect(proCall[3]).toEqual({ topP: 0.9 });
+      expect(flashCall[3]).toEqual({ topP: 0.9, maxTokens: 512, temperature: 0.2 });
+    });
+
+    it('should let request options override the tier settings', async () => {
+      mockGeminiClient.generateContent.mockResolvedValue({
+        text: 'Success',
+        model: 'gemini-2.5-flash',
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        modelConfigs,
+      });
+      await client.generateContent({
+        contents: [{ role: 'user', parts: [{ text: 'Hello' }] }],
+        maxTokens: 2048,
+      });
+
+      expect(mockGeminiClient.generateContent.mock.calls[0][3]).toMatchObject({
+        maxTokens: 2048,
+        temperature: 0.2,
+      });
+    });
+
+    it("should let the request's generationConfig override the tier settings", async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        modelConfigs,
+      });
+      await client.generate('Hello', {
+        generationConfig: { temperature: 0, maxOutputTokens: 4096 },
+      });
+
+      expect(mockGeminiClient.generate.mock.calls[0][3]).toEqual({
+        generationConfig: { temperature: 0, maxOutputTokens: 4096 },
+      });
+    });
+
+    it("should match entries keyed by the API's 'models/' resource name", async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        modelConfigs: { 'models/gemini-2.5-flash': { temperature: 0.2 } },
+      });
+      await client.generate('Hello');
+
+      expect(mockGeminiClient.generate.mock.calls[0][3]).toEqual({ temperature: 0.2 });
+    });
+  });
+
+  describe('streaming', () => {
+    it('should apply per-model settings and timeouts to each stream attempt', async () => {
+      mockGeminiClient.generateStream = vi.fn(async function* () {
+        yield { text: 'Hi' };
+      });
+      mockGeminiClient.generateContentStream = vi.fn(async function* () {
+        yield { text: 'Hi' };
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        modelConfigs: { 'gemini-2.5-flash': { temperature: 0.2, timeout: 5000 } },
+      });
+      for await (const _chunk of client.generateStream('Hello', { topP: 0.9 })) {
+        // drain
+      }
+      for await (const _chunk of client.generateContentStream({
+        contents: [{ role: 'user', parts: [{ text: 'Hello' }] }],
+      })) {
+        // drain
+      }
+
+      expect(mockGeminiClient.generateStream.mock.calls[0][3]).toEqual({
+        topP: 0.9,
+        temperature: 0.2,
+        timeout: 5000,
+      });
+      expect(mockGeminiClient.generateContentStream.mock.calls[0][3]).toMatchObject({
+        temperature: 0.2,
+        timeout: 5000,
+      });
+    });
+  });
+
+  describe('defaultGenerationConfig', () => {
+    it('should fill in settings that neither the request nor the model config set', async () => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Success', model: 'gemini-2.5-flash' });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        modelConfigs: { 'gemini-2.5-flash': { maxTokens: 1024, topK: 20 } },
+        defaultGenerationConfig: { temperature: 0.2, topP: 0.9, maxTokens: 4096 },
+      });
+      await client.generate('Hello', { topP: 0.5 });
+
+      expect(mockGeminiClient.generate.mock.calls[0][3]).toEqual({
+        topP: 0.5,
+        temperature: 0.2,
+        maxTokens: 1024,
+        topK: 20,
+      });
+    });
+
+    it('should apply to streams too', async () => {
+      mockGeminiClient.generateStream = vi.fn(async function* () {
+        yield { text: 'Hi' };
+      });
+      mockGeminiClient.generateContentStream = vi.fn(async function* () {
+        yield { text: 'Hi' };
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        defaultGenerationConfig: { temperature: 0.2, topK: 40 },
+      });
+      for await (const _chunk of client.generateStream('Hello', { topK: 10 })) {
+        // drain
+      }
+      for await (const _chunk of client.generateContentStream({
+        contents: [{ role: 'user', parts: [{ text: 'Hello' }] }],
+      })) {
+        // drain
+      }
+
+      expect(mockGeminiClient.generateStream.mock.calls[0][3]).toEqual({
+        topK: 10,
+        temperature: 0.2,
+      });
+      expect(mockGeminiClient.generateContentStream.mock.calls[0][3]).toMatchObject({
+        temperature: 0.2,
+        topK: 40,
+      });
+    });
+
+    it('should let generationConfig win on content requests and streams', async () => {
+      mockGeminiClient.generateContent.mockResolvedValue({
+        text: 'Success',
+        model: 'gemini-2.5-flash',
+      });
+      mockGeminiClient.generateContentStream = vi.fn(async function* () {
+        yield { text: 'Hi' };
+      });
+
+      const client = new GemBack({
+        apiKey: 'test-key',
+        fallbackOrder: ['gemini-2.5-flash'],
+        defaultGenerationConfig: { temperature: 0.7, topP: 0.9, topK: 40 },
+      });
+      const request = {
+        contents: [{ role: 'user' as const, parts: [{ text: 'Hello' }] }],
+        generationConfig: { temperature: 0, topP: 0.5 },
+      };
+      await client.generateContent(request);
+      for await (const _chunk of client.generateContentStream(request)) {
+        // drain
+      }
+
+      for (const call of [
+        mockGeminiClient.generateContent.mock.calls[0],
+        mockGeminiClient.generateContentStream.mock.calls[0],
+      ]) {
+        expect(call[3].temperature).toBeUndefined();
+        expect(call[3].topP).toBeUndefined();
+        expect(call[3]).toMatchObject({
+          topK: 40,
+          generationConfig: { temperature: 0, topP: 0.5 },
+        });
+      }
+    });
+  });
+});
@@ -0,0 +1,68 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GoogleGenAI } from '@google/genai';
+import { GemBack } from '../../src/client/FallbackClient';
+
+vi.mock('@google/genai', () => ({
+  GoogleGenAI: vi.fn(),
+  FunctionCallingConfigMode: {
+    AUTO: 'AUTO',
+    ANY: 'ANY',
+    NONE: 'NONE',
+  },
+}));
+
+describe('Offline mode', () => {
+  beforeEach(() => {
+    vi.clearAllMocks();
+  });
+
+  it('should not require an API key', () => {
+    expect(() => new GemBack({ offline: true })).not.toThrow();
+  });
+
+  it('should serve canned responses and echo other prompts', async () => {
+    const client = new GemBack({
+      offline: true,
+      fallbackOrder: ['gemini-2.5-flash'],
+      cannedResponses: { 'What is 2+2?': '4' },
+    });
+
+    const canned = await client.generate('What is 2+2?');
+    const echoed = await client.generate('Hello');
+
+    expect(canned).toMatchObject({ text: '4', model: 'gemini-2.5-flash' });
+    expect(echoed.text).toBe('[offline] Hello');
+    expect(GoogleGenAI).not.toHaveBeenCalled();
+  });
+
+  it('should key multi-turn contents by the latest user message', async () => {
+    const client = new GemBack({
+      offline: true,
+      cannedResponses: { 'And in French?': 'Bonjour' },
+    });
+
+    const response = await client.generateContent({
+      contents: [
+        { role: 'user', parts: [{ text: 'Say hello' }] },
+        { role: 'model', parts: [{ text: 'Hello' }] },
+        { role: 'user', parts: [{ text: 'And in French?' }] },
+      ],
+    });
+
+    expect(response.text).toBe('Bonjour');
+  });
+
+  it('should stream canned responses word by word', async () => {
+    const client = new GemBack({
+      offline: true,
+      cannedResponses: { Hi: 'Hello there friend' },
+    });
+
+    const chunks: string[] = [];
+    for await (const chunk of client.generateStream('Hi')) {
+      chunks.push(chunk.text);
+    }
+
+    expect(chunks).toEqual(['Hello ', 'there ', 'friend', '']);
+  });
+});
@@ -0,0 +1,122 @@
+import { describe, it, expect } from 'vitest';
+import { toOpenAIResponse, toOpenAIFinishReason, fromOpenAIMessages } from '../../src/utils/openai';
+
+describe('toOpenAIResponse', () => {
+  it('should map text, finish reason and usage', () => {
+    const completion = toOpenAIResponse(
+      {
+        text: 'Hello there!',
+        model: 'gemini-2.5-flash',
+        finishReason: 'STOP',
+        usage: { promptTokens: 4, completionTokens: 3, totalTokens: 7 },
+      },
+      { id: 'chatcmpl-123', created: 1700000000 }
+    );
+
+    expect(completion).toEqual({
+      id: 'chatcmpl-123',
+      object: 'chat.completion',
+      created: 1700000000,
+      model: 'gemini-2.5-flash',
+      choices: [
+        {
+          index: 0,
+          message: { role: 'assistant', content: 'Hello there!' },
+          finish_reason: 'stop',
+          logprobs: null,
+        },
+      ],
+      usage: { prompt_tokens: 4, completion_tokens: 3, total_tokens: 7 },
+    });
+  });
+
+  it('should map function calls to tool calls with null content', () => {
+    const completion = toOpenAIResponse({
+      text: '',
+      model: 'gemini-2.5-flash',
+      finishReason: 'STOP',
+      isToolCall: true,
+      functionCalls: [{ name: 'getWeather', args: { city: 'Seoul' } }],
+    });
+
+    expect(completion.id).toMatch(/^chatcmpl-/);
+    expect(completion.usage).toBeUndefined();
+    expect(completion.choices[0]).toMatchObject({
+      message: {
+        content: null,
+        tool_calls: [
+          {
+            id: 'call_0',
+            type: 'function',
+            function: { name: 'getWeather', arguments: '{"city":"Seoul"}' },
+          },
+        ],
+      },
+      finish_reason: 'tool_calls',
+    });
+  });
+
+  it('should turn every candidate into a choice', () => {
+    const completion = toOpenAIResponse({
+      text: 'A',
+      model: 'gemini-2.5-flash',
+      candidates: [
+        { text: 'A', finishReason: 'STOP' },
+        { text: 'B', finishReason: 'MAX_TOKENS' },
+      ],
+    });
+
+    expect(completion.choices.map((choice) => choice.message.content)).toEqual(['A', 'B']);
+    expect(completion.choices.map((choice) => choice.finish_reason)).toEqual(['stop', 'length']);
+    expect(completion.choices.map((choice) => choice.index)).toEqual([0, 1]);
+  });
+});
+
+describe('toOpenAIFinishReason', () => {
+  it('should translate Gemini finish reasons', () => {
+    const table: Array<[string | undefined, string]> = [
+      ['STOP', 'stop'],
+      ['MAX_TOKENS', 'length'],
+      ['SAFETY', 'content_filter'],
+      ['RECITATION', 'content_filter'],
+      ['BLOCKLIST', 'content_filter'],
+      ['PROHIBITED_CONTENT', 'content_filter'],
+      ['SPII', 'content_filter'],
+      ['IMAGE_SAFETY', 'content_filter'],
+      ['OTHER', 'stop'],
+      ['FINISH_REASON_UNSPECIFIED', 'stop'],
+      [undefined, 'stop'],
+    ];
+
+    for (const [gemini, openai] of table) {
+      expect(toOpenAIFinishReason(gemini)).toBe(openai);
+    }
+    expect(toOpenAIFinishReason('STOP', true)).toBe('tool_calls');
+  });
+});
+
+describe('fromOpenAIMessages', () => {
+  it('should translate roles and lift system messages into the system instruction', () => {
+    const { contents, systemInstruction } = fromOpenAIMessages([
+      { role: 'system', content: 'Be terse.' },
+      { role: 'user', content: 'Hello' },
+      { role: 'assistant', content: 'Hi.' },
+      { role: 'system', content: 'Answer in English.' },
+      { role: 'user', content: 'What is TypeScript?' },
+      { role: 'user', content: 'Briefly.' },
+    ]);
+
+    expect(systemInstruction).toBe('Be terse.\n\nAnswer in English.');
+    expect(contents).toEqual([
+      { role: 'user', parts: [{ text: 'Hello' }] },
+      { role: 'model', parts: [{ text: 'Hi.' }] },
+      { role: 'user', parts: [{ text: 'What is TypeScript?' }, { text: 'Briefly.' }] },
+    ]);
+  });
+
+  it('should leave the system instruction unset without system messages', () => {
+    const result = fromOpenAIMessages([{ role: 'user', content: 'Hello' }]);
+
+    expect(result).toEqual({ contents: [{ role: 'user', parts: [{ text: 'Hello' }] }] });
+  });
+});
@@ -0,0 +1,170 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { PassThrough } from 'stream';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Output spilling', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(),
+      generateContent: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const createWriter = () => {
+    const written: string[] = [];
+    return { written, write: vi.fn((chunk: string) => written.push(chunk)) };
+  };
+
+  it('should write text above the threshold to the writer', async () => {
+    const longText = 'a'.repeat(100);
+    mockGeminiClient.generate.mockResolvedValue({
+      text: longText,
+      model: 'gemini-2.5-flash',
+      finishReason: 'STOP',
+    });
+    const writer = createWriter();
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const response = await client.generate('Write a long document', {
+      spillOutput: { writer, thresholdBytes: 50 },
+    });
+
+    expect(writer.written).toEqual([longText]);
+    expect(response.text).toBe('');
+    expect(response.spilled).toEqual({ bytes: 100, writer });
+    expect(response.finishReason).toBe('STOP');
+  });
+
+  it('should not keep the text in any field of a spilled response', async () => {
+    const longText = '{"story":"' + 'b'.repeat(100) + '"}';
+    mockGeminiClient.generate.mockResolvedValue({
+      text: longText,
+      model: 'gemini-2.5-flash',
+      json: JSON.parse(longText),
+      content: { textParts: [longText], functionCalls: [], blobs: [] },
+      candidates: [
+        { text: longText, finishReason: 'STOP' },
+        { text: longText, finishReason: 'STOP' },
+      ],
+      logprobs: { chosenCandidates: [{ token: longText, logProbability: -0.1 }] },
+    });
+    const writer = createWriter();
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const response = await client.generate('Write a story as JSON', {
+      responseMimeType: 'application/json',
+      spillOutput: { writer, thresholdBytes: 50 },
+    });
+
+    expect(writer.written).toEqual([longText]);
+    const { spilled, ...rest } = response;
+    expect(JSON.stringify(rest)).not.toContain('b'.repeat(100));
+    expect(spilled?.bytes).toBe(longText.length);
+    expect(response.content?.textParts).toEqual([]);
+    expect(response.candidates?.map((candidate) => candidate.finishReason)).toEqual([
+      'STOP',
+      'STOP',
+    ]);
+  });
+
+  it('should wait for the writer to drain under backpressure', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: 'a'.repeat(100),
+      model: 'gemini-2.5-flash',
+    });
+    const writer = new PassThrough({ highWaterMark: 10 });
+    const write = vi.spyOn(writer, 'write');
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    let settled = false;
+    const pending = client
+      .generate('Write a long document', { spillOutput: { writer, thresholdBytes: 50 } })
+      .finally(() => {
+        settled = true;
+      });
+
+    await vi.waitFor(() => expect(write).toHaveBeenCalled());
+    await new Promise((resolve) => setTimeout(resolve, 10));
+    expect(settled).toBe(false);
+
+    writer.resume();
+    const response = await pending;
+    expect(response.spilled?.bytes).toBe(100);
+  });
+
+  it('should fail the request when the writer throws', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: 'a'.repeat(100),
+      model: 'gemini-2.5-flash',
+    });
+    const writer = {
+      write: vi.fn(() => {
+        throw new Error('disk full');
+      }),
+    };
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    await expect(
+      client.generate('Write a long document', { spillOutput: { writer, thresholdBytes: 50 } })
+    ).rejects.toMatchObject({
+      code: 'SPILL_FAILED',
+      message: expect.stringContaining('disk full'),
+    });
+  });
+
+  it('should keep text below the threshold on the response', async () => {
+    mockGeminiClient.generate.mockResolvedValue({
+      text: 'short',
+      model: 'gemini-2.5-flash',
+    });
+    const writer = createWriter();
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const response = await client.generate('Hello', {
+      spillOutput: { writer, thresholdBytes: 50 },
+    });
+
+    expect(writer.write).not.toHaveBeenCalled();
+    expect(response.text).toBe('short');
+    expect(response.spilled).toBeUndefined();
+  });
+
+  it('should measure the threshold in UTF-8 bytes', async () => {
+    // 10 Korean characters = 30 bytes in UTF-8
+    const text = '가'.repeat(10);
+    mockGeminiClient.generate.mockResolvedValue({ text, model: 'gemini-2.5-flash' });
+    const writer = createWriter();
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const response = await client.generate('Hello', {
+      spillOutput: { writer, thresholdBytes: 20 },
+    });
+
+    expect(response.spilled?.bytes).toBe(30);
+    expect(writer.written).toEqual([text]);
+  });
+
+  it('should spill multimodal responses', async () => {
+    mockGeminiClient.generateContent.mockResolvedValue({
+      text: 'x'.repeat(10),
+      model: 'gemini-2.5-flash',
+    });
+    const writer = createWriter();
+
+    const client = new GemBack({ apiKey: 'test-key' });
+    const response = await client.generateContent({
+      contents: [{ role: 'user', parts: [{ text: 'Describe' }] }],
+      spillOutput: { writer, thresholdBytes: 5 },
+    });
+
+    expect(response.text).toBe('');
+    expect(response.spilled?.bytes).toBe(10);
+  });
+});
@@ -0,0 +1,79 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { getContentsByteLength } from '../../src/utils/prompt-size';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Prompt size guard', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn().mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' }),
+      generateStream: vi.fn(),
+      generateContent: vi.fn().mockResolvedValue({ text: 'ok', model: 'gemini-2.5-flash' }),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  it('should reject oversized prompts before any API call', async () => {
+    const client = new GemBack({ apiKey: 'test-key', maxPromptBytes: 10 });
+
+    await expect(client.generate('This prompt is too long')).rejects.toMatchObject({
+      code: 'PROMPT_TOO_LARGE',
+    });
+    expect(mockGeminiClient.generate).not.toHaveBeenCalled();
+    expect(client.getFallbackStats().totalRequests).toBe(0);
+  });
+
+  it('should count UTF-8 bytes rather than characters', async () => {
+    const client = new GemBack({ apiKey: 'test-key', maxPromptBytes: 6 });
+
+    await expect(client.generate('안녕하세요')).rejects.toThrow('15 bytes');
+    await expect(client.generate('hello')).resolves.toBeDefined();
+  });
+
+  it('should sum the parts of multimodal requests', async () => {
+    const client = new GemBack({ apiKey: 'test-key', maxPromptBytes: 12 });
+    const contents = [
+      {
+        role: 'user' as const,
+        parts: [{ text: 'Describe' }, { inlineData: { mimeType: 'image/png', data: 'AAAAAA==' } }],
+      },
+    ];
+
+    await expect(client.generateContent({ contents })).rejects.toMatchObject({
+      code: 'PROMPT_TOO_LARGE',
+    });
+    expect(mockGeminiClient.generateContent).not.toHaveBeenCalled();
+  });
+
+  it('should guard streaming requests', async () => {
+    const client = new GemBack({ apiKey: 'test-key', maxPromptBytes: 4 });
+
+    await expect(client.generateStream('Too long').next()).rejects.toMatchObject({
+      code: 'PROMPT_TOO_LARGE',
+    });
+    expect(mockGeminiClient.generateStream).not.toHaveBeenCalled();
+  });
+
+  it('should not limit prompts by default', async () => {
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    await client.generate('x'.repeat(100000));
+    expect(mockGeminiClient.generate).toHaveBeenCalledTimes(1);
+  });
+
+  it('should ignore file references when measuring contents', () => {
+    expect(
+      getContentsByteLength([
+        {
+          role: 'user',
+          parts: [{ text: 'abc' }, { fileData: { mimeType: 'video/mp4', fileUri: 'gs://x/y' } }],
+        },
+      ])
+    ).toBe(3);
+  });
+});
@@ -1,5 +1,10 @@
 import { describe, it, expect, vi, beforeEach } from 'vitest';
-import { retryWithBackoff, sleep } from '../../src/utils/retry';
+import {
+  retryWithBackoff,
+  sleep,
+  getBackoffDelay,
+  createSeededRandom,
+} from '../../src/utils/retry';
 
 describe('retry utility', () => {
   beforeEach(() => {
@@ -14,6 +19,14 @@ describe('retry utility', () => {
       expect(elapsed).toBeGreaterThanOrEqual(90);
       expect(elapsed).toBeLessThan(150);
     });
+
+    it('should end early when the signal aborts', async () => {
+      const controller = new AbortController();
+      const start = Date.now();
+      setTimeout(() => controller.abort(), 10);
+      await sleep(5000, controller.signal);
+      expect(Date.now() - start).toBeLessThan(500);
+    });
   });
 
   describe('retryWithBackoff', () => {
@@ -97,4 +110,165 @@ describe('retry utility', () => {
       expect(fn).toHaveBeenCalledTimes(2);
     });
   });
+
+  describe('jitter', () => {
+    it('should keep delays within the full jitter band', () => {
+      const random = createSeededRandom(7);
+      const first = getBackoffDelay(1, { delay: 100, jitter: 'full', random });
+      const second = getBackoffDelay(1, { delay: 100, jitter: 'full', random });
+
+      expect(first).not.toBe(second);
+      for (const delay of [first, second]) {
+        expect(delay).toBeGreaterThanOrEqual(0);
+        expect(delay).toBeLessThanOrEqual(200);
+      }
+    });
+
+    it('should keep delays within the equal jitter band', () => {
+      const random = createSeededRandom(7);
+      const delays = [0, 1, 2].map(() =>
+        getBackoffDelay(2, { delay: 100, jitter: 'equal', random })
+      );
+
+      expect(new Set(delays).size).toBe(3);
+      for (const delay of delays) {
+        expect(delay).toBeGreaterThanOrEqual(200);
+        expect(delay).toBeLessThanOrEqual(400);
+      }
+    });
+
+    it('should be reproducible with the same seed', () => {
+      const a = createSeededRandom(42);
+      const b = createSeededRandom(42);
+
+      expect([a(), a(), a()]).toEqual([b(), b(), b()]);
+    });
+
+    it('should not randomize without jitter', () => {
+      const random = vi.fn(() => 0.5);
+
+      expect(getBackoffDelay(3, { delay: 100, random })).toBe(800);
+      expect(random).not.toHaveBeenCalled();
+    });
+
+    it('should use the jittered delay between retries', async () => {
+      const fn = vi.fn().mockRejectedValueOnce(new Error('fail')).mockResolvedValue('success');
+      const random = vi.fn(() => 0);
+
+      const start = Date.now();
+      await retryWithBackoff(fn, { maxRetries: 1, delay: 1000, jitter: 'full', random });
+
+      expect(Date.now() - start).toBeLessThan(100);
+      expect(random).toHaveBeenCalledTimes(1);
+    });
+  });
+
+  describe('deadline', () => {
+    it('should fail fast instead of sleeping past the deadline', async () => {
+      const fn = vi.fn().mockRejectedValue(new Error('503 Service unavailable'));
+      const onDeadline = vi.fn();
+
+      const startTime = Date.now();
+      await expect(
+        retryWithBackoff(fn, {
+          maxRetries: 3,
+          delay: 5000,
+          deadline: Date.now() + 1000,
+          onDeadline,
+        })
+      ).rejects.toThrow('503 Service unavailable');
+
+      expect(Date.now() - startTime).toBeLessThan(500);
+      expect(fn).toHaveBeenCalledTimes(1);
+      expect(onDeadline).toHaveBeenCalledWith(5000, expect.any(Number));
+      expect(onDeadline.mock.calls[0][1]).toBeLessThanOrEqual(1000);
+    });
+
+    it('should keep retrying while the backoff fits before the deadline', async () => {
+      const fn = vi.fn().mockRejectedValueOnce(new Error('fail')).mockResolvedValue('success');
+
+      const result = await retryWithBackoff(fn, {
+        maxRetries: 3,
+        delay: 10,
+        deadline: Date.now() + 1000,
+      });
+
+      expect(result).toBe('success');
+      expect(fn).toHaveBeenCalledTimes(2);
+    });
+  });
+
+  describe('per-error backoff', () => {
+    it('should pick the backoff profile for each error', async () => {
+      const fn = vi.fn().mockRejectedValue(new Error('429 Too Many Requests'));
+      const onDeadline = vi.fn();
+
+      await expect(
+        retryWithBackoff(fn, {
+          maxRetries: 1,
+          delay: 10,
+          deadline: Date.now() + 1000,
+          onDeadline,
+          backoffFor: (error) => ({ delay: error.message.startsWith('429') ? 5000 : 10 }),
+        })
+      ).rejects.toThrow('429');
+
+      expect(onDeadline).toHaveBeenCalledWith(5000, expect.any(Number));
+    });
+  });
+
+  describe('per-error retry counts', () => {
+    it('should use the limit for each error, falling back to maxRetries', async () => {
+      const limits: Record<string, number> = { '503': 2, '500': 0 };
+      const maxRetriesFor = (error: Error) => limits[error.message.slice(0, 3)];
+
+      const unavailable = vi.fn().mockRejectedValue(new Error('503 Service Unavailable'));
+      await expect(
+        retryWithBackoff(unavailable, { maxRetries: 5, delay: 1, maxRetriesFor })
+      ).rejects.toThrow('503');
+      expect(unavailable).toHaveBeenCalledTimes(3);
+
+      const internal = vi.fn().mockRejectedValue(new Error('500 Internal Server Error'));
+      await expect(
+        retryWithBackoff(internal, { maxRetries: 5, delay: 1, maxRetriesFor })
+      ).rejects.toThrow('500');
+      expect(internal).toHaveBeenCalledTimes(1);
+
+      const timeout = vi.fn().mockRejectedValue(new Error('Request timeout'));
+      await expect(
+        retryWithBackoff(timeout, { maxRetries: 1, delay: 1, maxRetriesFor })
+      ).rejects.toThrow('timeout');
+      expect(timeout).toHaveBeenCalledTimes(2);
+    });
+  });
+
+  describe('abort', () => {
+    it('should stop retrying as soon as the signal aborts', async () => {
+      const controller = new AbortController();
+      const fn = vi.fn().mockRejectedValue(new Error('503 Service unavailable'));
+
+      const startTime = Date.now();
+      const retries = retryWithBackoff(fn, {
+        maxRetries: 3,
+        delay: 5000,
+        signal: controller.signal,
+      });
+      setTimeout(() => controller.abort(), 10);
+
+      await expect(retries).rejects.toThrow('Request aborted');
+      expect(Date.now() - startTime).toBeLessThan(500);
+      expect(fn).toHaveBeenCalledTimes(1);
+    });
+
+    it('should not attempt at all once aborted', async () => {
+      const controller = new AbortController();
+      controller.abort();
+      const fn = vi.fn().mockResolvedValue('success');
+
+      await expect(
+        retryWithBackoff(fn, { maxRetries: 3, delay: 10, signal: controller.signal })
+      ).rejects.toThrow('Request aborted');
+      expect(fn).not.toHaveBeenCalled();
+    });
+  });
 });
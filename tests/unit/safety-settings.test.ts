@@ -1,5 +1,5 @@
 import { describe, it, expect, vi, beforeEach } from 'vitest';
-import { GemBack } from '../../src';
+import { GemBack, BlockedError } from '../../src';
 import type { SafetySetting } from '../../src/types/config';
 import * as GoogleGenAI from '@google/genai';
 
@@ -373,4 +373,87 @@ describe('Safety Settings', () => {
       );
     });
   });
+
+  describe('Blocked content', () => {
+    const blockedResponse = {
+      text: '',
+      model: 'gemini-3-flash-preview',
+      finishReason: 'SAFETY',
+      safetyRatings: [
+        { category: 'HARM_CATEGORY_HARASSMENT', probability: 'NEGLIGIBLE' },
+        { category: 'HARM_CATEGORY_DANGEROUS_CONTENT', probability: 'HIGH', blocked: true },
+        { category: 'HARM_CATEGORY_HATE_SPEECH', probability: 'LOW' },
+      ],
+    };
+
+    it('should return blocked responses as-is by default', async () => {
+      (client as any).client.generate = vi.fn().mockResolvedValue(blockedResponse);
+
+      const response = await client.generate('Test prompt');
+
+      expect(response.finishReason).toBe('SAFETY');
+      expect(response.safetyRatings).toHaveLength(3);
+    });
+
+    it('should throw BlockedError with the triggering ratings when throwOnBlocked is set', async () => {
+      const strictClient = new GemBack({ apiKey: 'test-key', throwOnBlocked: true });
+      const mockGenerate = vi.fn().mockResolvedValue(blockedResponse);
+      (strictClient as any).client.generate = mockGenerate;
+
+      const error = await strictClient.generate('Test prompt').catch((e) => e);
+
+      expect(error).toBeInstanceOf(BlockedError);
+      expect(error.code).toBe('BLOCKED');
+      expect(error.blockedPart).toBe('response');
+      expect(error.blockReason).toBe('SAFETY');
+      expect(error.categories()).toEqual(['HARM_CATEGORY_DANGEROUS_CONTENT']);
+      expect(error.safetyRatings).toEqual([
+        { category: 'HARM_CATEGORY_DANGEROUS_CONTENT', probability: 'HIGH', blocked: true },
+      ]);
+      expect(mockGenerate).toHaveBeenCalledTimes(1);
+    });
+
+    it('should fall back to MEDIUM and HIGH ratings for blocked prompts without flags', async () => {
+      const strictClient = new GemBack({ apiKey: 'test-key', throwOnBlocked: true });
+      (strictClient as any).client.generateContent = vi.fn().mockResolvedValue({
+        text: '',
+        model: 'gemini-3-flash-preview',
+        promptFeedback: {
+          blockReason: 'SAFETY',
+          safetyRatings: [
+            { category: 'HARM_CATEGORY_HARASSMENT', probability: 'MEDIUM' },
+            { category: 'HARM_CATEGORY_HATE_SPEECH', probability: 'NEGLIGIBLE' },
+            { category: 'HARM_CATEGORY_SEXUALLY_EXPLICIT', probability: 'HIGH' },
+          ],
+        },
+      });
+
+      const error = await strictClient
+        .generateContent({ contents: [{ role: 'user', parts: [{ text: 'Test prompt' }] }] })
+        .catch((e) => e);
+
+      expect(error.blockedPart).toBe('prompt');
+      expect(error.categories()).toEqual([
+        'HARM_CATEGORY_HARASSMENT',
+        'HARM_CATEGORY_SEXUALLY_EXPLICIT',
+      ]);
+      expect(error.message).toContain('HARM_CATEGORY_HARASSMENT');
+    });
+
+    it('should count a blocked request as a failure in the stats', async () => {
+      const strictClient = new GemBack({ apiKey: 'test-key', throwOnBlocked: true });
+      (strictClient as any).client.generate = vi.fn().mockResolvedValue({
+        ...blockedResponse,
+        usage: { promptTokens: 10, completionTokens: 0, totalTokens: 10 },
+      });
+
+      await expect(strictClient.generate('Test prompt')).rejects.toThrow(BlockedError);
+
+      const stats = strictClient.getFallbackStats();
+      expect(stats.failureCount).toBe(1);
+      expect(stats.successRate).toBe(0);
+      expect(Object.values(stats.modelUsage).every((count) => count === 0)).toBe(true);
+      expect(strictClient.getTotalUsage().totalTokens).toBe(0);
+    });
+  });
 });
@@ -0,0 +1,60 @@
+import { describe, it, expect } from 'vitest';
+import { sentenceStream } from '../../src/utils/sentence-stream';
+import type { StreamChunk } from '../../src/types/response';
+
+async function* fragments(...texts: string[]): AsyncGenerator<StreamChunk> {
+  for (const text of texts) {
+    yield { text, model: 'gemini-2.5-flash', isComplete: false };
+  }
+  yield { text: '', model: 'gemini-2.5-flash', isComplete: true };
+}
+
+async function collect(stream: AsyncIterable<StreamChunk>): Promise<string[]> {
+  const texts: string[] = [];
+  for await (const chunk of stream) {
+    if (!chunk.isComplete) {
+      texts.push(chunk.text);
+    }
+  }
+  return texts;
+}
+
+describe('sentenceStream', () => {
+  it('should re-chunk token fragments into sentences and flush the remainder', async () => {
+    const sentences = await collect(
+      sentenceStream(fragments('Hel', 'lo there', '. How are', ' you? I am', ' fine! Bye'))
+    );
+
+    expect(sentences).toEqual(['Hello there. ', 'How are you? ', 'I am fine! ', 'Bye']);
+  });
+
+  it('should wait for what follows a delimiter at the end of a fragment', async () => {
+    const sentences = await collect(sentenceStream(fragments('Pi is 3.', '14. Next')));
+
+    expect(sentences).toEqual(['Pi is 3.14. ', 'Next']);
+  });
+
+  it('should not split after abbreviations, and keep closing quotes', async () => {
+    const sentences = await collect(
+      sentenceStream(fragments('Dr. Smith said "Hi." Then', ' he left, e.g. quickly.'))
+    );
+
+    expect(sentences).toEqual(['Dr. Smith said "Hi." ', 'Then he left, e.g. quickly.']);
+  });
+
+  it('should split on full-width delimiters without spaces', async () => {
+    const sentences = await collect(sentenceStream(fragments('안녕하세요。반갑', '습니다！끝')));
+
+    expect(sentences).toEqual(['안녕하세요。', '반갑습니다！', '끝']);
+  });
+
+  it('should accept custom delimiters and pass the completion chunk through', async () => {
+    const chunks: StreamChunk[] = [];
+    for await (const chunk of sentenceStream(fragments('one; two; three'), { delimiters: [';'] })) {
+      chunks.push(chunk);
+    }
+
+    expect(chunks.map((chunk) => chunk.text)).toEqual(['one; ', 'two; ', 'three', '']);
+    expect(chunks[chunks.length - 1].isComplete).toBe(true);
+  });
+});
@@ -0,0 +1,179 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import type { StreamChunk } from '../../src/types/response';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Stream resumption', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generateStream: vi.fn(),
+      generateContentStream: vi.fn(),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const collect = async (stream: AsyncGenerator<StreamChunk>) => {
+    const chunks: StreamChunk[] = [];
+    for await (const chunk of stream) {
+      chunks.push(chunk);
+    }
+    return chunks;
+  };
+
+  const interrupted = (texts: string[]) =>
+    async function* () {
+      for (const text of texts) {
+        yield { text };
+      }
+      throw new Error('503 Service Unavailable');
+    };
+
+  const complete = (texts: string[]) =>
+    async function* () {
+      for (const text of texts) {
+        yield { text };
+      }
+    };
+
+  it('should resume on the next key and skip the emitted prefix', async () => {
+    mockGeminiClient.generateStream
+      .mockImplementationOnce(interrupted(['The quick ', 'brown']))
+      .mockImplementationOnce(complete(['The quick brown', ' fox']));
+
+    const client = new GemBack({
+      apiKeys: ['key1', 'key2'],
+      fallbackOrder: ['gemini-2.5-flash'],
+    });
+    const chunks = await collect(client.generateStream('Tell me', { resumeOnError: true }));
+
+    expect(chunks.map((chunk) => chunk.text).join('')).toBe('The quick brown fox');
+    expect(chunks[chunks.length - 1].isComplete).toBe(true);
+    const keys = mockGeminiClient.generateStream.mock.calls.map((call: unknown[]) => call[2]);
+    expect(keys).toEqual(['key1', 'key2']);
+  });
+
+  it('should not resume without the option', async () => {
+    mockGeminiClient.generateStream.mockImplementation(interrupted(['Partial']));
+
+    const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+    await expect(collect(client.generateStream('Tell me'))).rejects.toThrow();
+    expect(mockGeminiClient.generateStream).toHaveBeenCalledTimes(1);
+  });
+
+  it('should not resume errors that happen before any text', async () => {
+    mockGeminiClient.generateStream.mockImplementation(interrupted([]));
+
+    const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+
+    const stream = client.generateStream('Tell me', { resumeOnError: true });
+
+    await expect(collect(stream)).rejects.toThrow();
+    expect(mockGeminiClient.generateStream).toHaveBeenCalledTimes(1);
+  });
+
+  it('should give up after maxRetries resumptions', async () => {
+    mockGeminiClient.generateStream.mockImplementation(interrupted(['Again']));
+
+    const client = new GemBack({
+      apiKey: 'test-key',
+      fallbackOrder: ['gemini-2.5-flash'],
+      maxRetries: 2,
+    });
+
+    const stream = client.generateStream('Tell me', { resumeOnError: true });
+
+    await expect(collect(stream)).rejects.toMatchObject({ code: 'ALL_KEYS_EXHAUSTED' });
+    expect(mockGeminiClient.generateStream).toHaveBeenCalledTimes(3);
+  });
+
+  it('should restart with a reset chunk after a fallback to another model', async () => {
+    mockGeminiClient.generateStream
+      .mockImplementationOnce(interrupted(['Hello ']))
+      .mockImplementationOnce(complete(['Hi', ' there']));
+
+    const client = new GemBack({
+      apiKey: 'test-key',
+      fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+      maxRetries: 0,
+    });
+    const chunks = await collect(client.generateStream('Greet', { resumeOnError: true }));
+
+    const resetAt = chunks.findIndex((chunk) => chunk.reset);
+    expect(chunks.slice(0, resetAt).map((chunk) => chunk.text)).toEqual(['Hello ']);
+    expect(chunks[resetAt]).toMatchObject({ text: 'Hi', model: 'gemini-2.5-flash-lite' });
+    expect(chunks.slice(resetAt).map((chunk) => chunk.text).join('')).toBe('Hi there');
+    expect(chunks.filter((chunk) => chunk.reset)).toHaveLength(1);
+  });
+
+  it("should resume against the new model's text after a switch", async () => {
+    mockGeminiClient.generateStream
+      .mockImplementationOnce(interrupted(['Hello ']))
+      .mockImplementationOnce(interrupted(['Hello ']))
+      .mockImplementationOnce(interrupted(['Hi ']))
+      .mockImplementationOnce(complete(['Hi there']));
+
+    const client = new GemBack({
+      apiKeys: ['key1', 'key2'],
+      fallbackOrder: ['gemini-2.5-flash', 'gemini-2.5-flash-lite'],
+      maxRetries: 1,
+    });
+    const chunks = await collect(client.generateStream('Greet', { resumeOnError: true }));
+
+    const models = mockGeminiClient.generateStream.mock.calls.map((call: unknown[]) => call[1]);
+    expect(models).toEqual([
+      'gemini-2.5-flash',
+      'gemini-2.5-flash',
+      'gemini-2.5-flash-lite',
+      'gemini-2.5-flash-lite',
+    ]);
+    expect(chunks.map((chunk) => [chunk.text, chunk.reset ?? false])).toEqual([
+      ['Hello ', false],
+      ['Hi ', true],
+      ['there', false],
+      ['', false],
+    ]);
+  });
+
+  it('should resume on a key the request has not used', async () => {
+    mockGeminiClient.generateStream
+      .mockImplementationOnce(interrupted(['The quick ']))
+      .mockImplementationOnce(complete(['The quick brown fox']));
+
+    const client = new GemBack({
+      apiKeys: ['key1', 'key2', 'key3'],
+      fallbackOrder: ['gemini-2.5-flash'],
+      keyStartStrategy: 'hash',
+    });
+    const chunks = await collect(
+      client.generateStream('Tell me', { resumeOnError: true, keySeed: 'user-42' })
+    );
+
+    const [first, second] = mockGeminiClient.generateStream.mock.calls.map(
+      (call: unknown[]) => call[2]
+    );
+    expect(second).not.toBe(first);
+    expect(chunks.map((chunk) => chunk.text).join('')).toBe('The quick brown fox');
+  });
+
+  it('should resume multimodal streams', async () => {
+    mockGeminiClient.generateContentStream
+      .mockImplementationOnce(interrupted(['A cat ']))
+      .mockImplementationOnce(complete(['A cat on a mat']));
+
+    const client = new GemBack({ apiKey: 'test-key', fallbackOrder: ['gemini-2.5-flash'] });
+    const chunks = await collect(
+      client.generateContentStream({
+        contents: [{ role: 'user', parts: [{ text: 'Describe' }] }],
+        resumeOnError: true,
+      })
+    );
+
+    expect(chunks.map((chunk) => chunk.text).join('')).toBe('A cat on a mat');
+  });
+});
@@ -349,4 +349,47 @@ describe('System Instructions', () => {
       );
     });
   });
+
+  describe('responseLanguage', () => {
+    beforeEach(() => {
+      mockGeminiClient.generate.mockResolvedValue({ text: 'Bonjour', model: 'gemini-2.5-flash' });
+      mockGeminiClient.generateContent.mockResolvedValue({
+        text: 'Bonjour',
+        model: 'gemini-2.5-flash',
+      });
+    });
+
+    it('should compose the language instruction with an existing system instruction', async () => {
+      await client.generate('Hello', {
+        systemInstruction: 'You are a concise assistant.',
+        responseLanguage: 'French',
+      });
+
+      expect(mockGeminiClient.generate).toHaveBeenCalledWith(
+        'Hello',
+        'gemini-2.5-flash',
+        'test-api-key',
+        expect.objectContaining({
+          systemInstruction: 'You are a concise assistant.\n\nRespond in French.',
+        })
+      );
+    });
+
+    it('should add the language instruction on its own or as an extra Content part', async () => {
+      await client.generate('Hello', { responseLanguage: 'Korean' });
+      await client.generateContent({
+        contents: [{ role: 'user', parts: [{ text: 'Hello' }] }],
+        systemInstruction: { role: 'user', parts: [{ text: 'Be brief.' }] },
+        responseLanguage: 'French',
+      });
+
+      expect(mockGeminiClient.generate.mock.calls[0][3].systemInstruction).toBe(
+        'Respond in Korean.'
+      );
+      expect(mockGeminiClient.generateContent.mock.calls[0][3].systemInstruction).toEqual({
+        role: 'user',
+        parts: [{ text: 'Be brief.' }, { text: 'Respond in French.' }],
+      });
+    });
+  });
 });
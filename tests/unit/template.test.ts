@@ -0,0 +1,72 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { GeminiBackError } from '../../src/types/errors';
+import { renderTemplate } from '../../src/utils/template';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('renderTemplate', () => {
+  it('should substitute variables and nested paths', () => {
+    const rendered = renderTemplate('Hi {{ user.name }}, you have {{count}} {{items}}', {
+      user: { name: 'Ada' },
+      count: 3,
+      items: ['a', 'b'],
+    });
+
+    expect(rendered).toBe('Hi Ada, you have 3 ["a","b"]');
+  });
+
+  it('should throw on missing variables', () => {
+    expect(() => renderTemplate('Hello {{name}}', {})).toThrow('"name"');
+    expect(() => renderTemplate('{{toString}}', {})).toThrow('"toString"');
+  });
+});
+
+describe('Prompt templates', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn().mockResolvedValue({ text: 'Done', model: 'gemini-2.5-flash' }),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  it('should generate from a template registered in the config', async () => {
+    const client = new GemBack({
+      apiKey: 'test-key',
+      templates: { greet: 'Say hello to {{name}}' },
+    });
+
+    await client.generateFromTemplate('greet', { name: 'Ada' }, { temperature: 0 });
+
+    expect(mockGeminiClient.generate).toHaveBeenCalledWith(
+      'Say hello to Ada',
+      expect.any(String),
+      'test-key',
+      { temperature: 0 }
+    );
+  });
+
+  it('should generate from a template registered at runtime', async () => {
+    const client = new GemBack({ apiKey: 'test-key' });
+    client.registerTemplate('translate', 'Translate to {{lang}}: {{text}}');
+
+    await client.generateFromTemplate('translate', { lang: 'French', text: 'Hello' });
+
+    expect(mockGeminiClient.generate.mock.calls[0][0]).toBe('Translate to French: Hello');
+  });
+
+  it('should fail before calling the API when rendering fails', async () => {
+    const client = new GemBack({ apiKey: 'test-key', templates: { greet: 'Hi {{name}}' } });
+
+    await expect(client.generateFromTemplate('greet', {})).rejects.toMatchObject({
+      code: 'TEMPLATE_ERROR',
+    });
+    await expect(client.generateFromTemplate('missing', {})).rejects.toThrow(GeminiBackError);
+    expect(mockGeminiClient.generate).not.toHaveBeenCalled();
+    expect(client.getFallbackStats().totalRequests).toBe(0);
+  });
+});
@@ -0,0 +1,78 @@
+import { describe, it, expect, vi, beforeEach } from 'vitest';
+import { GemBack } from '../../src/client/FallbackClient';
+import { GeminiClient } from '../../src/client/GeminiClient';
+import { GeminiBackError } from '../../src/types/errors';
+
+vi.mock('../../src/client/GeminiClient');
+
+describe('Prompt truncation', () => {
+  let mockGeminiClient: any;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    mockGeminiClient = {
+      generate: vi.fn(async (prompt: string, model: string) => ({ text: prompt, model })),
+      // One token per character (code point), so expectations are easy to read
+      countTokens: vi.fn(async (contents: Array<{ parts: Array<{ text: string }> }>) =>
+        Array.from(contents[0].parts[0].text).length
+      ),
+    };
+    vi.mocked(GeminiClient).mockImplementation(() => mockGeminiClient);
+  });
+
+  const prompt = 'abcdefghijklmnopqrst'; // 20 tokens
+
+  it('should cut the end of the prompt by default', async () => {
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const response = await client.generate(prompt, { truncateToTokens: 10 });
+
+    const sent = mockGeminiClient.generate.mock.calls[0][0];
+    expect(prompt.startsWith(sent)).toBe(true);
+    expect(sent.length).toBeLessThanOrEqual(10);
+    expect(response.truncatedTokens).toBe(20 - sent.length);
+  });
+
+  it('should cut the start of the prompt with truncateFrom: head', async () => {
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    await client.generate(prompt, { truncateToTokens: 10, truncateFrom: 'head' });
+
+    const sent = mockGeminiClient.generate.mock.calls[0][0];
+    expect(prompt.endsWith(sent)).toBe(true);
+    expect(sent.length).toBeLessThanOrEqual(10);
+  });
+
+  it('should leave prompts that already fit untouched', async () => {
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const response = await client.generate('short', { truncateToTokens: 10 });
+
+    expect(mockGeminiClient.generate.mock.calls[0][0]).toBe('short');
+    expect(response.truncatedTokens).toBeUndefined();
+  });
+
+  it('should not split multi-byte characters', async () => {
+    const emoji = '😀🎉🚀🌍✨'.repeat(4); // 20 code points, surrogate pairs included
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    await client.generate(emoji, { truncateToTokens: 7 });
+
+    const sent: string = mockGeminiClient.generate.mock.calls[0][0];
+    expect(emoji.startsWith(sent)).toBe(true);
+    expect(sent).not.toMatch(/[\uD800-\uDBFF]$/);
+    expect(Array.from(sent).length).toBeLessThanOrEqual(7);
+  });
+
+  it('should fail when the prompt cannot be made to fit', async () => {
+    mockGeminiClient.countTokens.mockResolvedValue(100);
+    const client = new GemBack({ apiKey: 'test-key' });
+
+    const error = await client
+      .generate(prompt, { truncateToTokens: 10 })
+      .catch((err: GeminiBackError) => err);
+
+    expect((error as GeminiBackError).code).toBe('PROMPT_TOO_LARGE');
+    expect(mockGeminiClient.generate).not.toHaveBeenCalled();
+  });
+});